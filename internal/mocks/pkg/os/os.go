@@ -185,6 +185,20 @@ func (mr *MockOSMockRecorder) RemoveAll(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAll", reflect.TypeOf((*MockOS)(nil).RemoveAll), arg0)
 }
 
+// Rename mocks base method.
+func (m *MockOS) Rename(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rename", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rename indicates an expected call of Rename.
+func (mr *MockOSMockRecorder) Rename(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockOS)(nil).Rename), arg0, arg1)
+}
+
 // Stat mocks base method.
 func (m *MockOS) Stat(arg0 string) (fs.FileInfo, error) {
 	m.ctrl.T.Helper()
@@ -35,8 +35,14 @@ type MIGDeviceInfo struct {
 	ComputeInstanceID int
 }
 
-// GetMIGDeviceInfoByID returns information about MIG DEVICE by ID
-func GetMIGDeviceInfoByID(uuid string) (*MIGDeviceInfo, error) {
+// GPUInstanceInfo identifies a GPU instance by the UUID of its GPU and its ID
+type GPUInstanceInfo struct {
+	ParentUUID    string
+	GPUInstanceID int
+}
+
+// initNVML initializes NVML on the first call; the error is returned to that call, the NVML calls fail afterwards.
+func initNVML() error {
 	var err error
 
 	nvmlOnce.Do(func() {
@@ -46,7 +52,12 @@ func GetMIGDeviceInfoByID(uuid string) (*MIGDeviceInfo, error) {
 			logrus.Error("Can not init NVML library.")
 		}
 	})
-	if err != nil {
+	return err
+}
+
+// GetMIGDeviceInfoByID returns information about MIG DEVICE by ID
+func GetMIGDeviceInfoByID(uuid string) (*MIGDeviceInfo, error) {
+	if err := initNVML(); err != nil {
 		return nil, err
 	}
 
@@ -112,3 +123,62 @@ func GetMIGDeviceInfoByID(uuid string) (*MIGDeviceInfo, error) {
 		ComputeInstanceID: ci,
 	}, nil
 }
+
+// GetGPUInstanceByPlacement returns the GPU instance of the profile at the placement on the GPU of the minor
+// number, i.e. the MIG device of the canonical name gpu-<minor>-mig-<profile ID>-<start>-<size> of the NVIDIA DRA
+// driver.
+func GetGPUInstanceByPlacement(minor, profileID, start, size int) (*GPUInstanceInfo, error) {
+	if err := initNVML(); err != nil {
+		return nil, err
+	}
+
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+
+	for i := 0; i < count; i++ {
+		device, ret := nvml.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if deviceMinor, ret := device.GetMinorNumber(); ret != nvml.SUCCESS || deviceMinor != minor {
+			continue
+		}
+
+		parentUUID, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+
+		// The profiles are looked up by index, the ID of the name is the one of their info
+		for profile := 0; profile < nvml.GPU_INSTANCE_PROFILE_COUNT; profile++ {
+			profileInfo, ret := device.GetGpuInstanceProfileInfo(profile)
+			if ret != nvml.SUCCESS || int(profileInfo.Id) != profileID {
+				continue
+			}
+
+			gpuInstances, ret := device.GetGpuInstances(&profileInfo)
+			if ret != nvml.SUCCESS {
+				return nil, errors.New(nvml.ErrorString(ret))
+			}
+			for _, gpuInstance := range gpuInstances {
+				info, ret := gpuInstance.GetInfo()
+				if ret != nvml.SUCCESS {
+					return nil, errors.New(nvml.ErrorString(ret))
+				}
+				if int(info.Placement.Start) == start && int(info.Placement.Size) == size {
+					return &GPUInstanceInfo{
+						ParentUUID:    parentUUID,
+						GPUInstanceID: int(info.Id),
+					}, nil
+				}
+			}
+		}
+
+		return nil, fmt.Errorf("no GPU instance of the profile %d at the placement %d+%d on the GPU %d",
+			profileID, start, size, minor)
+	}
+
+	return nil, fmt.Errorf("no GPU of the minor number %d", minor)
+}
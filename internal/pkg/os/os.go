@@ -30,6 +30,7 @@ type OS interface {
 	Open(name string) (*os.File, error)
 	Remove(name string) error
 	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
 	Stat(name string) (os.FileInfo, error)
 	TempDir() string
 	ReadDir(name string) ([]os.DirEntry, error)
@@ -77,6 +78,10 @@ func (RealOS) Remove(name string) error {
 	return os.Remove(name)
 }
 
+func (RealOS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
 func (RealOS) ReadDir(name string) ([]os.DirEntry, error) {
 	return os.ReadDir(name)
 }
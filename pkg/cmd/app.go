@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"runtime"
@@ -21,6 +22,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/NVIDIA/dcgm-exporter/pkg/dcgmexporter"
+	"github.com/NVIDIA/dcgm-exporter/pkg/dcgmexporter/kubeauth"
 	"github.com/NVIDIA/dcgm-exporter/pkg/dcgmexporter/podwatcher"
 	"github.com/NVIDIA/dcgm-exporter/pkg/stdout"
 )
@@ -55,6 +57,9 @@ const (
 	CLIFieldsFile                 = "collectors"
 	CLIAddress                    = "address"
 	CLICollectInterval            = "collect-interval"
+	CLICollectOnScrape            = "collect-on-scrape"
+	CLIMinCollectInterval         = "min-collect-interval"
+	CLISampleInterval             = "sample-interval"
 	CLIKubernetes                 = "kubernetes"
 	CLIKubernetesGPUIDType        = "kubernetes-gpu-id-type"
 	CLIUseOldNamespace            = "use-old-namespace"
@@ -74,10 +79,28 @@ const (
 	CLIEnableDCGMLog              = "enable-dcgm-log"
 	CLIDCGMLogLevel               = "dcgm-log-level"
 	CLIPodResourcesKubeletSocket  = "pod-resources-kubelet-socket"
+	CLIPodResourcesInterval       = "pod-resources-refresh-interval"
+	CLIPodResourcesTTL            = "pod-resources-ttl"
 	CLIHPCJobMappingDir           = "hpc-job-mapping-dir"
 	CLINvidiaResourceNames        = "nvidia-resource-names"
-	CLIOtelInheritPodLabels       = "otel-inherit-pod-labels"
-	CLIOtelInheritPodAnnotations  = "otel-inherit-pod-annotations"
+	CLIGPUSharingStrategy         = "kubernetes-gpu-sharing-strategy"
+	CLIGPUSplitCounters           = "kubernetes-gpu-split-counters"
+	CLIPodLabels                  = "kubernetes-pod-labels"
+	CLIPodAnnotations             = "kubernetes-pod-annotations"
+	CLIKubeconfig                 = "kubeconfig"
+	CLIWorkloadLabels             = "kubernetes-workload-labels"
+	CLIWorkloadKinds              = "kubernetes-workload-kinds"
+	CLINamespaceLabels            = "kubernetes-namespace-labels"
+	CLICounterStateFile           = "counter-state-file"
+	CLIConfigFile                 = "config-file"
+	CLISeriesLimit                = "series-limit"
+	CLISeriesLimitPerCounter      = "series-limit-per-counter"
+	CLIAdminAddress               = "admin-address"
+	CLIAdminWebConfigFile         = "admin-web-config-file"
+	CLIKubernetesAuth             = "kubernetes-auth"
+	CLIKubernetesAuthVerb         = "kubernetes-auth-verb"
+	CLIKubernetesAuthPath         = "kubernetes-auth-path"
+	CLIKubernetesAuthCacheTTL     = "kubernetes-auth-cache-ttl"
 )
 
 func NewApp(buildVersion ...string) *cli.App {
@@ -116,6 +139,24 @@ func NewApp(buildVersion ...string) *cli.App {
 			Usage:   "Interval of time at which point metrics are collected. Unit is milliseconds (ms).",
 			EnvVars: []string{"DCGM_EXPORTER_INTERVAL"},
 		},
+		&cli.BoolFlag{
+			Name:    CLICollectOnScrape,
+			Value:   false,
+			Usage:   "Collect the metrics when they are scraped, instead of every collect interval. Not supported with OTLP export.",
+			EnvVars: []string{"DCGM_EXPORTER_COLLECT_ON_SCRAPE"},
+		},
+		&cli.IntFlag{
+			Name:    CLIMinCollectInterval,
+			Value:   1000,
+			Usage:   "Minimum interval between two collections triggered by scrapes; the scrapes within it get the same metrics. Unit is milliseconds (ms).",
+			EnvVars: []string{"DCGM_EXPORTER_MIN_COLLECT_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    CLISampleInterval,
+			Value:   1000,
+			Usage:   "Interval of time at which DCGM samples the counters with the 'stats' option. Unit is milliseconds (ms).",
+			EnvVars: []string{"DCGM_EXPORTER_SAMPLE_INTERVAL"},
+		},
 		&cli.BoolFlag{
 			Name:    CLIKubernetes,
 			Aliases: []string{"k"},
@@ -154,8 +195,9 @@ func NewApp(buildVersion ...string) *cli.App {
 		&cli.StringFlag{
 			Name:  CLIKubernetesGPUIDType,
 			Value: string(dcgmexporter.GPUUID),
-			Usage: fmt.Sprintf("Choose Type of GPU ID to use to map kubernetes resources to pods. Possible values: '%s', '%s'",
-				dcgmexporter.GPUUID, dcgmexporter.DeviceName),
+			Usage: fmt.Sprintf("Choose Type of GPU ID to use to map kubernetes resources to pods. Possible values: '%s', '%s', '%s', '%s', '%s'",
+				dcgmexporter.GPUUID, dcgmexporter.DeviceName, dcgmexporter.PCIBusID, dcgmexporter.GPUIndex,
+				dcgmexporter.AutoGPUID),
 			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_GPU_ID_TYPE"},
 		},
 		&cli.StringFlag{
@@ -195,7 +237,7 @@ func NewApp(buildVersion ...string) *cli.App {
 			Name:    CLIXIDCountWindowSize,
 			Aliases: []string{"x"},
 			Value:   int((5 * time.Minute).Milliseconds()),
-			Usage:   "Set time window size in milliseconds (ms) for counting active XID errors in DCGM Exporter.",
+			Usage:   "Set time window size in milliseconds (ms) for counting active XID errors in DCGM Exporter. The errors are counted once per collection.",
 			EnvVars: []string{"DCGM_EXPORTER_XID_COUNT_WINDOW_SIZE"},
 		},
 		&cli.BoolFlag{
@@ -214,7 +256,7 @@ func NewApp(buildVersion ...string) *cli.App {
 		&cli.IntFlag{
 			Name:    CLIClockEventsCountWindowSize,
 			Value:   int((5 * time.Minute).Milliseconds()),
-			Usage:   "Set time window size in milliseconds (ms) for counting clock events in DCGM Exporter.",
+			Usage:   "Set time window size in milliseconds (ms) for counting clock events in DCGM Exporter. The events are counted once per collection.",
 			EnvVars: []string{"DCGM_EXPORTER_CLOCK_EVENTS_COUNT_WINDOW_SIZE"},
 		},
 		&cli.BoolFlag{
@@ -235,6 +277,18 @@ func NewApp(buildVersion ...string) *cli.App {
 			Usage:   "Path to the kubelet pod-resources socket file.",
 			EnvVars: []string{"DCGM_POD_RESOURCES_KUBELET_SOCKET"},
 		},
+		&cli.IntFlag{
+			Name:    CLIPodResourcesInterval,
+			Value:   0,
+			Usage:   "Interval of time in milliseconds (ms), at which the pod resources are listed from kubelet; 0 means the collect interval.",
+			EnvVars: []string{"DCGM_POD_RESOURCES_REFRESH_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    CLIPodResourcesTTL,
+			Value:   int(dcgmexporter.DefaultPodResourcesTTL.Milliseconds()),
+			Usage:   "Time in milliseconds (ms), that the last pod resources are used, when kubelet is unavailable.",
+			EnvVars: []string{"DCGM_POD_RESOURCES_TTL"},
+		},
 		&cli.StringFlag{
 			Name:    CLIHPCJobMappingDir,
 			Value:   "",
@@ -247,17 +301,115 @@ func NewApp(buildVersion ...string) *cli.App {
 			Usage:   "Nvidia resource names for specified GPU type like nvidia.com/a100, nvidia.com/a10.",
 			EnvVars: []string{"NVIDIA_RESOURCE_NAMES"},
 		},
+		&cli.StringFlag{
+			Name:    CLIGPUSharingStrategy,
+			Value:   dcgmexporter.DefaultGPUSharingStrategy,
+			Usage:   "Sharing strategy of the device plugin, e.g. time-slicing or mps, that labels the GPUs shared by several pods.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_GPU_SHARING_STRATEGY"},
+		},
 		&cli.StringSliceFlag{
-			Name:    CLIOtelInheritPodLabels,
+			Name:    CLIGPUSplitCounters,
 			Value:   cli.NewStringSlice(),
-			Usage:   "List of pod labels to inherit from the pod observed.",
-			EnvVars: []string{"DCGM_EXPORTER_OTEL_INHERIT_POD_LABELS"},
+			Usage:   "Counters, which values are split evenly among the pods sharing a GPU, rather than reported in full for each pod.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_GPU_SPLIT_COUNTERS"},
 		},
 		&cli.StringSliceFlag{
-			Name:    CLIOtelInheritPodAnnotations,
+			Name:    CLIPodLabels,
+			Aliases: []string{"otel-inherit-pod-labels"},
 			Value:   cli.NewStringSlice(),
-			Usage:   "List of pod annotations to inherit from the pod observed.",
-			EnvVars: []string{"DCGM_EXPORTER_OTEL_INHERIT_POD_ANNOTATIONS"},
+			Usage:   "List of pod labels to copy to the metrics of the devices of the pod. The names are turned into label names, in OTLP too.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_POD_LABELS", "DCGM_EXPORTER_OTEL_INHERIT_POD_LABELS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    CLIPodAnnotations,
+			Aliases: []string{"otel-inherit-pod-annotations"},
+			Value:   cli.NewStringSlice(),
+			Usage:   "List of pod annotations to copy to the metrics of the devices of the pod. The names are turned into label names, in OTLP too.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_POD_ANNOTATIONS", "DCGM_EXPORTER_OTEL_INHERIT_POD_ANNOTATIONS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    CLINamespaceLabels,
+			Value:   cli.NewStringSlice(),
+			Usage:   "List of namespace labels to copy to the metrics of the devices of the pods of the namespace.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_NAMESPACE_LABELS"},
+		},
+		&cli.StringFlag{
+			Name:    CLIKubeconfig,
+			Value:   "",
+			Usage:   "Path to the kubeconfig file used to watch the pods, when running out of the cluster.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBECONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:    CLIWorkloadLabels,
+			Value:   false,
+			Usage:   "Label the metrics of the devices of the pods with the kind and the name of the workloads of the pods.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_WORKLOAD_LABELS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    CLIWorkloadKinds,
+			Value:   cli.NewStringSlice(),
+			Usage:   "Custom resources controlling pods, or other workloads, as <apiVersion>/<kind>, e.g. kubeflow.org/v1/PyTorchJob.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_WORKLOAD_KINDS"},
+		},
+		&cli.StringFlag{
+			Name:    CLICounterStateFile,
+			Value:   "",
+			Usage:   "Path to the file used to persist the state of the integrated <FIELD>_COUNTER metrics across restarts.",
+			EnvVars: []string{"DCGM_EXPORTER_COUNTER_STATE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    CLIConfigFile,
+			Value:   "",
+			Usage:   "Path to the YAML exporter configuration file with derived metrics, static labels and relabeling rules.",
+			EnvVars: []string{"DCGM_EXPORTER_CONFIG_FILE"},
+		},
+		&cli.IntFlag{
+			Name:    CLISeriesLimit,
+			Value:   0,
+			Usage:   "Maximum number of series exposed per collection; the excess is dropped. 0 means no limit.",
+			EnvVars: []string{"DCGM_EXPORTER_SERIES_LIMIT"},
+		},
+		&cli.IntFlag{
+			Name:    CLISeriesLimitPerCounter,
+			Value:   0,
+			Usage:   "Maximum number of series exposed per counter; the excess is dropped. 0 means no limit.",
+			EnvVars: []string{"DCGM_EXPORTER_SERIES_LIMIT_PER_COUNTER"},
+		},
+		&cli.StringFlag{
+			Name:    CLIAdminAddress,
+			Value:   "",
+			Usage:   "Address of the admin listener serving the status page, /health, /ready, /status and /debug/pprof; disabled if empty.",
+			EnvVars: []string{"DCGM_EXPORTER_ADMIN_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:    CLIAdminWebConfigFile,
+			Value:   "",
+			Usage:   "TLS and basic auth config file of the admin listener, following webConfig spec.",
+			EnvVars: []string{"DCGM_EXPORTER_ADMIN_WEB_CONFIG_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    CLIKubernetesAuth,
+			Value:   false,
+			Usage:   "Require a Kubernetes bearer token on /metrics, validated with TokenReview and authorized with SubjectAccessReview.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_AUTH"},
+		},
+		&cli.StringFlag{
+			Name:    CLIKubernetesAuthVerb,
+			Value:   kubeauth.DefaultVerb,
+			Usage:   "Verb of the SubjectAccessReview of the Kubernetes authentication.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_AUTH_VERB"},
+		},
+		&cli.StringFlag{
+			Name:    CLIKubernetesAuthPath,
+			Value:   "",
+			Usage:   "Non-resource URL of the SubjectAccessReview of the Kubernetes authentication; the request path if empty.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_AUTH_PATH"},
+		},
+		&cli.IntFlag{
+			Name:    CLIKubernetesAuthCacheTTL,
+			Value:   int(kubeauth.DefaultCacheTTL.Milliseconds()),
+			Usage:   "Time in milliseconds (ms), that the decisions of the Kubernetes authentication are cached; 0 disables the cache.",
+			EnvVars: []string{"DCGM_EXPORTER_KUBERNETES_AUTH_CACHE_TTL"},
 		},
 	}
 
@@ -304,9 +456,11 @@ func action(c *cli.Context) (err error) {
 }
 
 func startDCGMExporter(c *cli.Context, cancel context.CancelFunc) error {
+restart:
+	// Every run has its own context, the background tasks of the previous run, e.g. the refreshes
+	// of the pod resources, stop with it on SIGHUP.
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
-restart:
 
 	logrus.Info("Starting dcgm-exporter")
 
@@ -346,20 +500,50 @@ restart:
 		return err
 	}
 
-	if config.Kubernetes && otelEnabled {
-		podWatcher, err := podwatcher.New()
+	if config.Kubernetes && (len(config.PodLabels) > 0 || len(config.PodAnnotations) > 0 ||
+		len(config.NamespaceLabels) > 0 || config.WorkloadLabels) {
+		workloadKinds, err := podwatcher.ParseWorkloadKinds(config.WorkloadKinds)
 		if err != nil {
 			return err
 		}
-		go func() {
-			if err := podWatcher.Run(ctx); err != nil {
-				cancel()
-				logrus.Errorf("PodWatcher failed: %v", err)
-			}
-		}()
-		config.PodWatcher = podWatcher
+
+		podWatcher, err := podwatcher.New(podwatcher.Options{
+			Kubeconfig:      config.Kubeconfig,
+			NodeName:        os.Getenv("NODE_NAME"),
+			Labels:          config.PodLabels,
+			Annotations:     config.PodAnnotations,
+			Workloads:       config.WorkloadLabels,
+			WorkloadKinds:   workloadKinds,
+			NamespaceLabels: config.NamespaceLabels,
+		})
+		if err != nil {
+			// The metrics are mapped to the pods nonetheless
+			logrus.WithError(err).Warn("Cannot watch the pods, their labels, annotations and workloads are not added to the metrics")
+		} else {
+			go func() {
+				if err := podWatcher.Run(ctx); err != nil {
+					cancel()
+					logrus.Errorf("PodWatcher failed: %v", err)
+				}
+			}()
+			config.PodWatcher = podWatcher
+		}
 	}
 
+	if config.Kubernetes {
+		interval := config.PodResourcesInterval
+		if interval == 0 {
+			interval = config.CollectInterval
+		}
+		podResources := dcgmexporter.NewPodResourcesCache(config.PodResourcesKubeletSocket,
+			time.Duration(interval)*time.Millisecond,
+			time.Duration(config.PodResourcesTTL)*time.Millisecond)
+		go podResources.Run(ctx)
+		config.PodResources = podResources
+	}
+
+	config.Assignments = dcgmexporter.NewAssignmentTracker()
+
 	pipeline, cleanup, err := dcgmexporter.NewMetricsPipeline(config,
 		cs.DCGMCounters,
 		hostname,
@@ -377,6 +561,8 @@ restart:
 
 	enableDCGMExpClockEventsCount(cs, fieldEntityGroupTypeSystemInfo, hostname, config, cRegistry)
 
+	pipeline.LimitRegistry(cRegistry)
+
 	defer func() {
 		cRegistry.Cleanup()
 	}()
@@ -386,8 +572,10 @@ restart:
 	var wg sync.WaitGroup
 	stop := make(chan interface{})
 
-	wg.Add(1)
-	go pipeline.Run(ch, stop, &wg)
+	if !config.CollectOnScrape {
+		wg.Add(1)
+		go pipeline.Run(ch, stop, &wg)
+	}
 
 	wg.Add(1)
 
@@ -397,8 +585,20 @@ restart:
 		return err
 	}
 
+	if config.CollectOnScrape {
+		server.SetCollector(pipeline.Collect)
+	}
+
+	server.SetInventory(dcgmexporter.NewInventory(fieldEntityGroupTypeSystemInfo))
+	server.SetCollectorStatus(pipeline.CollectorStatus)
+
 	go server.Run(stop, &wg)
 
+	if config.AdminAddress != "" {
+		wg.Add(1)
+		go dcgmexporter.NewAdminServer(config, server).Run(stop, &wg)
+	}
+
 	sigs := newOSWatcher(syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 	sig := <-sigs
 	close(stop)
@@ -632,6 +832,34 @@ func parseDeviceOptions(devices string) (dcgmexporter.DeviceOptions, error) {
 	return dOpt, nil
 }
 
+// sameListenAddress reports whether the listeners of the addresses would clash, i.e. they have the same port,
+// and the same host, or either host is empty or unspecified, e.g. 0.0.0.0 or ::, and so listens on all addresses.
+func sameListenAddress(a, b string) bool {
+	aHost, aPort, aErr := net.SplitHostPort(a)
+	bHost, bPort, bErr := net.SplitHostPort(b)
+	if aErr != nil || bErr != nil {
+		return a == b
+	}
+
+	if aPort != bPort {
+		return false
+	}
+
+	wildcard := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || ip != nil && ip.IsUnspecified()
+	}
+	if wildcard(aHost) || wildcard(bHost) {
+		return true
+	}
+
+	aIP, bIP := net.ParseIP(aHost), net.ParseIP(bHost)
+	if aIP != nil && bIP != nil {
+		return aIP.Equal(bIP)
+	}
+	return strings.EqualFold(aHost, bHost)
+}
+
 func contextToConfig(c *cli.Context) (*dcgmexporter.Config, error) {
 	gOpt, err := parseDeviceOptions(c.String(CLIGPUDevices))
 	if err != nil {
@@ -648,15 +876,55 @@ func contextToConfig(c *cli.Context) (*dcgmexporter.Config, error) {
 		return nil, err
 	}
 
+	if c.Int(CLISampleInterval) <= 0 {
+		return nil, fmt.Errorf("invalid %s parameter value: %d", CLISampleInterval, c.Int(CLISampleInterval))
+	}
+
+	for _, name := range []string{CLIMinCollectInterval, CLISeriesLimit, CLISeriesLimitPerCounter, CLIKubernetesAuthCacheTTL,
+		CLIPodResourcesInterval, CLIPodResourcesTTL} {
+		if c.Int(name) < 0 {
+			return nil, fmt.Errorf("invalid %s parameter value: %d", name, c.Int(name))
+		}
+	}
+
+	if c.Bool(CLICollectOnScrape) {
+		// The scrapes within the minimum interval share a collection, it cannot be disabled
+		if c.Int(CLIMinCollectInterval) == 0 {
+			return nil, fmt.Errorf("invalid %s parameter value: %d; it must be positive with --%s",
+				CLIMinCollectInterval, 0, CLICollectOnScrape)
+		}
+		// OTLP is pushed by the collections every collect interval
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+			return nil, fmt.Errorf("invalid %s parameter value: OTLP export requires the metrics to be collected every %s",
+				CLICollectOnScrape, CLICollectInterval)
+		}
+	}
+
+	if c.String(CLIAdminAddress) != "" && sameListenAddress(c.String(CLIAdminAddress), c.String(CLIAddress)) {
+		return nil, fmt.Errorf("invalid %s parameter value: %s clashes with the metrics address %s",
+			CLIAdminAddress, c.String(CLIAdminAddress), c.String(CLIAddress))
+	}
+
 	dcgmLogLevel := c.String(CLIDCGMLogLevel)
 	if !slices.Contains(dcgmexporter.DCGMDbgLvlValues, dcgmLogLevel) {
 		return nil, fmt.Errorf("invalid %s parameter value: %s", CLIDCGMLogLevel, dcgmLogLevel)
 	}
 
+	exporterConfig := &dcgmexporter.ExporterConfig{}
+	if c.String(CLIConfigFile) != "" {
+		exporterConfig, err = dcgmexporter.LoadExporterConfig(c.String(CLIConfigFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &dcgmexporter.Config{
 		CollectorsFile:             c.String(CLIFieldsFile),
 		Address:                    c.String(CLIAddress),
 		CollectInterval:            c.Int(CLICollectInterval),
+		SampleInterval:             c.Int(CLISampleInterval),
+		CollectOnScrape:            c.Bool(CLICollectOnScrape),
+		MinCollectInterval:         c.Int(CLIMinCollectInterval),
 		Kubernetes:                 c.Bool(CLIKubernetes),
 		KubernetesGPUIdType:        dcgmexporter.KubernetesGPUIDType(c.String(CLIKubernetesGPUIDType)),
 		CollectDCP:                 true,
@@ -678,9 +946,32 @@ func contextToConfig(c *cli.Context) (*dcgmexporter.Config, error) {
 		EnableDCGMLog:              c.Bool(CLIEnableDCGMLog),
 		DCGMLogLevel:               dcgmLogLevel,
 		PodResourcesKubeletSocket:  c.String(CLIPodResourcesKubeletSocket),
+		PodResourcesInterval:       c.Int(CLIPodResourcesInterval),
+		PodResourcesTTL:            c.Int(CLIPodResourcesTTL),
 		HPCJobMappingDir:           c.String(CLIHPCJobMappingDir),
 		NvidiaResourceNames:        c.StringSlice(CLINvidiaResourceNames),
-		OtelInheritPodLabels:       c.StringSlice(CLIOtelInheritPodLabels),
-		OtelInheritPodAnnotations:  c.StringSlice(CLIOtelInheritPodAnnotations),
+		GPUSharingStrategy:         c.String(CLIGPUSharingStrategy),
+		GPUSplitCounters:           c.StringSlice(CLIGPUSplitCounters),
+		PodLabels:                  c.StringSlice(CLIPodLabels),
+		PodAnnotations:             c.StringSlice(CLIPodAnnotations),
+		NamespaceLabels:            c.StringSlice(CLINamespaceLabels),
+		Kubeconfig:                 c.String(CLIKubeconfig),
+		WorkloadLabels:             c.Bool(CLIWorkloadLabels),
+		WorkloadKinds:              c.StringSlice(CLIWorkloadKinds),
+		CounterStateFile:           c.String(CLICounterStateFile),
+		DerivedMetrics:             exporterConfig.DerivedMetrics,
+		StaticLabels:               exporterConfig.StaticLabels,
+		MetricRelabelConfigs:       exporterConfig.MetricRelabelConfigs,
+		SeriesLimit:                c.Int(CLISeriesLimit),
+		SeriesLimitPerCounter:      c.Int(CLISeriesLimitPerCounter),
+		SeriesLimits:               exporterConfig.SeriesLimits,
+		DeviceIDRules:              exporterConfig.DeviceIDRules,
+		AdminAddress:               c.String(CLIAdminAddress),
+		AdminWebConfigFile:         c.String(CLIAdminWebConfigFile),
+		KubernetesAuth:             c.Bool(CLIKubernetesAuth),
+		KubernetesAuthVerb:         c.String(CLIKubernetesAuthVerb),
+		KubernetesAuthPath:         c.String(CLIKubernetesAuthPath),
+		KubernetesAuthCacheTTL:     c.Int(CLIKubernetesAuthCacheTTL),
+		Version:                    c.App.Version,
 	}, nil
 }
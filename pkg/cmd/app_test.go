@@ -193,3 +193,27 @@ func Test_getFieldEntityGroupTypeSystemInfo(t *testing.T) {
 		})
 	}
 }
+
+func Test_sameListenAddress(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{":9400", ":9400", true},
+		{":9400", ":9401", false},
+		{":9400", "localhost:9400", true},
+		{"0.0.0.0:9400", "127.0.0.1:9400", true},
+		{"[::]:9400", "10.0.0.1:9400", true},
+		{"127.0.0.1:9400", "10.0.0.1:9400", false},
+		{"localhost:9400", "LOCALHOST:9400", true},
+		{"[::1]:9400", "[0:0:0:0:0:0:0:1]:9400", true},
+		{"localhost:9400", "127.0.0.1:9401", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, sameListenAddress(tt.a, tt.b))
+			assert.Equal(t, tt.want, sameListenAddress(tt.b, tt.a))
+		})
+	}
+}
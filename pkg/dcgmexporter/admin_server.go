@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/sirupsen/logrus"
+
+	"github.com/NVIDIA/dcgm-exporter/internal/pkg/logging"
+)
+
+// staleCollectIntervals is the number of collect intervals without a collection, after which the exporter is not ready.
+const staleCollectIntervals = 3
+
+// AdminServer serves the health, readiness, status, status page and pprof endpoints on a listener separate from the metrics,
+// with its own TLS and basic auth settings, so the probes do not need the metrics credentials,
+// and the debug endpoints are never exposed on the metrics port.
+type AdminServer struct {
+	server    *http.Server
+	webConfig *web.FlagConfig
+	metrics   *MetricsServer
+
+	startedAt       time.Time
+	collectInterval time.Duration
+	collectOnScrape bool
+}
+
+func NewAdminServer(c *Config, metrics *MetricsServer) *AdminServer {
+	router := mux.NewRouter()
+	systemdSocket := false
+	s := &AdminServer{
+		server: &http.Server{
+			Addr:         c.AdminAddress,
+			Handler:      router,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 60 * time.Second, // The CPU profile takes 30 seconds by default
+		},
+		webConfig: &web.FlagConfig{
+			WebListenAddresses: &[]string{c.AdminAddress},
+			WebSystemdSocket:   &systemdSocket,
+			WebConfigFile:      &c.AdminWebConfigFile,
+		},
+		metrics:         metrics,
+		startedAt:       time.Now(),
+		collectInterval: time.Duration(c.CollectInterval) * time.Millisecond,
+		collectOnScrape: c.CollectOnScrape,
+	}
+
+	router.HandleFunc("/", metrics.StatusPage)
+	router.HandleFunc("/health", metrics.Health)
+	router.HandleFunc("/ready", s.Ready)
+	router.HandleFunc("/status", s.Status)
+
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+
+	return s
+}
+
+func (s *AdminServer) Run(stop chan interface{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	logger := logging.NewLogrusAdapter(logrus.StandardLogger())
+
+	var httpwg sync.WaitGroup
+	httpwg.Add(1)
+	go func() {
+		defer httpwg.Done()
+		logrus.Infof("Starting admin webserver on %s", s.server.Addr)
+		if err := web.ListenAndServe(s.server, s.webConfig, logger); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Fatal("Failed to Listen and Server admin HTTP server.")
+		}
+	}()
+
+	<-stop
+	if err := s.server.Shutdown(context.Background()); err != nil {
+		logrus.WithError(err).Fatal("Failed to shutdown admin HTTP server.")
+	}
+
+	if err := WaitWithTimeout(&httpwg, 3*time.Second); err != nil {
+		logrus.WithError(err).Fatal("Failed waiting for admin HTTP server to shutdown.")
+	}
+}
+
+// ready reports whether the exporter serves fresh metrics. Unlike the health check, it fails also
+// when the collection got stuck, and the served metrics are older than a few collect intervals.
+func (s *AdminServer) ready() bool {
+	if !s.metrics.healthy() {
+		return false
+	}
+	if s.collectOnScrape || s.collectInterval <= 0 {
+		return true
+	}
+	return time.Since(s.metrics.getMetrics().collectedAt) <= staleCollectIntervals*s.collectInterval
+}
+
+func (s *AdminServer) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	status, body := http.StatusOK, "OK"
+	if !s.ready() {
+		status, body = http.StatusServiceUnavailable, "KO"
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(body)); err != nil {
+		logrus.WithError(err).Error("Failed to write response.")
+	}
+}
+
+type adminStatus struct {
+	StartedAt       time.Time  `json:"startedAt"`
+	Healthy         bool       `json:"healthy"`
+	Ready           bool       `json:"ready"`
+	CollectOnScrape bool       `json:"collectOnScrape"`
+	CollectInterval string     `json:"collectInterval"`
+	LastCollection  *time.Time `json:"lastCollection,omitempty"`
+	MetricsBytes    int        `json:"metricsBytes"`
+	LastError       string     `json:"lastError,omitempty"`
+}
+
+func (s *AdminServer) status() adminStatus {
+	snapshot := s.metrics.getMetrics()
+
+	status := adminStatus{
+		StartedAt:       s.startedAt,
+		Healthy:         s.metrics.healthy(),
+		Ready:           s.ready(),
+		CollectOnScrape: s.collectOnScrape,
+		CollectInterval: s.collectInterval.String(),
+		MetricsBytes:    len(snapshot.data),
+	}
+	if !snapshot.collectedAt.IsZero() {
+		status.LastCollection = &snapshot.collectedAt
+	}
+	if err := s.metrics.collectErr(); err != nil {
+		status.LastError = err.Error()
+	}
+
+	return status
+}
+
+func (s *AdminServer) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.status())
+}
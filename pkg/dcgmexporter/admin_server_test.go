@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(s *AdminServer, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestAdminServer_Probes(t *testing.T) {
+	metrics := &MetricsServer{registry: NewRegistry()}
+	s := NewAdminServer(&Config{AdminAddress: ":9401", CollectInterval: 30000}, metrics)
+
+	assert.Equal(t, http.StatusServiceUnavailable, adminRequest(s, "/health").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, adminRequest(s, "/ready").Code)
+
+	metrics.updateMetrics("DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n")
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/health").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/ready").Code)
+
+	// The collection got stuck
+	metrics.snapshot.Store(&metricsSnapshot{
+		data:        "DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n",
+		collectedAt: time.Now().Add(-2 * time.Minute),
+	})
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/health").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, adminRequest(s, "/ready").Code)
+}
+
+func TestAdminServer_ProbesOnScrape(t *testing.T) {
+	metrics := &MetricsServer{registry: NewRegistry()}
+	s := NewAdminServer(&Config{AdminAddress: ":9401", CollectInterval: 30000, CollectOnScrape: true}, metrics)
+
+	var collectErr error
+	metrics.SetCollector(func() (string, time.Time, error) { return "", time.Now(), collectErr })
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/ready").Code)
+
+	collectErr = errors.New("DCGM is not available")
+	metrics.scrapeMetrics()
+	assert.Equal(t, http.StatusServiceUnavailable, adminRequest(s, "/ready").Code)
+
+	var status adminStatus
+	require.NoError(t, json.Unmarshal(adminRequest(s, "/status").Body.Bytes(), &status))
+	assert.Equal(t, "DCGM is not available", status.LastError)
+	assert.True(t, status.CollectOnScrape)
+}
+
+func TestAdminServer_Status(t *testing.T) {
+	metrics := &MetricsServer{registry: NewRegistry()}
+	s := NewAdminServer(&Config{AdminAddress: ":9401", CollectInterval: 30000}, metrics)
+	data := "DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n"
+	metrics.updateMetrics(data)
+
+	rec := adminRequest(s, "/status")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status adminStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.True(t, status.Healthy)
+	assert.True(t, status.Ready)
+	assert.Equal(t, "30s", status.CollectInterval)
+	assert.Equal(t, len(data), status.MetricsBytes)
+	assert.NotNil(t, status.LastCollection)
+	assert.Empty(t, status.LastError)
+}
+
+func TestAdminServer_Pprof(t *testing.T) {
+	s := NewAdminServer(&Config{AdminAddress: ":9401"}, &MetricsServer{registry: NewRegistry()})
+
+	rec := adminRequest(s, "/debug/pprof/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine")
+
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/debug/pprof/goroutine?debug=1").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(s, "/debug/pprof/cmdline").Code)
+
+	// The metrics are not served on the admin listener
+	assert.Equal(t, http.StatusNotFound, adminRequest(s, "/metrics").Code)
+}
+
+func TestAdminServer_StatusPage(t *testing.T) {
+	c := &Config{Address: ":9400", AdminAddress: ":9401", Version: "3.3.5-3.4.0"}
+	metrics, _, err := NewMetricsServer(c, nil, NewRegistry())
+	require.NoError(t, err)
+	s := NewAdminServer(c, metrics)
+
+	rec := adminRequest(s, "/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "3.3.5-3.4.0")
+	// The metrics and the API are not served on the admin listener
+	assert.NotContains(t, rec.Body.String(), "./api/v1/devices")
+
+	// The metrics listener serves only a landing page
+	rec = httptest.NewRecorder()
+	metrics.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "./metrics")
+	assert.NotContains(t, rec.Body.String(), "3.3.5-3.4.0")
+}
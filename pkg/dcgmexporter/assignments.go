@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AssignmentTracker keeps the device ownership computed by the PodMapper and the hpcMapper in the last collection,
+// so it can be served on /api/v1/assignments.
+type AssignmentTracker struct {
+	mtx sync.RWMutex
+
+	pods          map[string][]PodInfo // Device ID, as returned by Metric.getIDOfType -> pods
+	podsUpdatedAt time.Time
+	jobs          map[string][]string // GPU index -> HPC jobs
+	jobsUpdatedAt time.Time
+}
+
+func NewAssignmentTracker() *AssignmentTracker {
+	return &AssignmentTracker{}
+}
+
+func (t *AssignmentTracker) setPods(deviceToPod map[string][]PodInfo) {
+	if t == nil {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.pods = maps.Clone(deviceToPod)
+	t.podsUpdatedAt = time.Now()
+}
+
+func (t *AssignmentTracker) setJobs(gpuToJobs map[string][]string) {
+	if t == nil {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.jobs = maps.Clone(gpuToJobs)
+	t.jobsUpdatedAt = time.Now()
+}
+
+// Assignments is the JSON document of /api/v1/assignments.
+type Assignments struct {
+	// PodsUpdatedAt and JobsUpdatedAt are the times of the last pod and HPC job mappings.
+	// They are omitted, when the mapping is disabled or did not run yet.
+	PodsUpdatedAt *time.Time         `json:"podsUpdatedAt,omitempty"`
+	JobsUpdatedAt *time.Time         `json:"jobsUpdatedAt,omitempty"`
+	Devices       []DeviceAssignment `json:"devices"`
+}
+
+// DeviceAssignment is the owner of a GPU or a MIG GPU instance. Pods and Jobs are empty, when the device is unassigned.
+// A GPU shared through the replicas of the device plugin has several Pods.
+type DeviceAssignment struct {
+	GPU           string    `json:"gpu"`
+	UUID          string    `json:"uuid"`
+	Device        string    `json:"device"`
+	GPUInstanceID string    `json:"gpuInstanceId,omitempty"`
+	MigProfile    string    `json:"migProfile,omitempty"`
+	Assigned      bool      `json:"assigned"`
+	Pods          []PodInfo `json:"pods,omitempty"`
+	Jobs          []string  `json:"jobs,omitempty"`
+}
+
+// Assignments returns the owners of the GPUs and the GPU instances of the inventory. The devices are matched
+// with the pods by the KubernetesGPUIDType, as the PodMapper matches the metrics.
+func (t *AssignmentTracker) Assignments(inventory *Inventory, idType KubernetesGPUIDType) Assignments {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	// The result is read after the lock is released, so it must not refer to the state of the tracker
+	result := Assignments{Devices: []DeviceAssignment{}}
+	if !t.podsUpdatedAt.IsZero() {
+		podsUpdatedAt := t.podsUpdatedAt
+		result.PodsUpdatedAt = &podsUpdatedAt
+	}
+	if !t.jobsUpdatedAt.IsZero() {
+		jobsUpdatedAt := t.jobsUpdatedAt
+		result.JobsUpdatedAt = &jobsUpdatedAt
+	}
+
+	for _, gpu := range inventory.GPUs {
+		m := Metric{
+			GPU:         fmt.Sprint(gpu.GPU),
+			GPUUUID:     gpu.UUID,
+			GPUDevice:   fmt.Sprintf("nvidia%d", gpu.GPU),
+			GPUPCIBusID: gpu.PCIBusID,
+		}
+		result.Devices = append(result.Devices, t.assignment(m, idType))
+
+		for _, instance := range gpu.GPUInstances {
+			m.MigProfile = instance.Profile
+			m.GPUInstanceID = fmt.Sprint(instance.InstanceID)
+			result.Devices = append(result.Devices, t.assignment(m, idType))
+		}
+	}
+
+	return result
+}
+
+func (t *AssignmentTracker) assignment(m Metric, idType KubernetesGPUIDType) DeviceAssignment {
+	a := DeviceAssignment{
+		GPU:           m.GPU,
+		UUID:          m.GPUUUID,
+		Device:        m.GPUDevice,
+		GPUInstanceID: m.GPUInstanceID,
+		MigProfile:    m.MigProfile,
+		Jobs:          slices.Clone(t.jobs[m.GPU]),
+	}
+
+	if deviceID, err := m.getIDOfType(idType); err == nil {
+		a.Pods = slices.Clone(t.pods[deviceID])
+	}
+	a.Assigned = len(a.Pods) > 0 || len(a.Jobs) > 0
+
+	return a
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignmentTracker_Assignments(t *testing.T) {
+	inventory := NewInventory(newInventoryTestSystemInfo())
+	podA := PodInfo{Name: "pod-a", Namespace: "default", Container: "cuda"}
+	podB := PodInfo{Name: "pod-b", Namespace: "ml", Container: "train"}
+
+	tests := []struct {
+		name   string
+		idType KubernetesGPUIDType
+		pods   map[string][]PodInfo
+		jobs   map[string][]string
+		want   []DeviceAssignment
+	}{
+		{
+			name:   "Nothing mapped",
+			idType: GPUUID,
+			want: []DeviceAssignment{
+				{GPU: "0", UUID: "GPU-00000000-0000-0000-0000-000000000000", Device: "nvidia0"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "1", MigProfile: "3g.40gb"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "2", MigProfile: "3g.40gb"},
+			},
+		},
+		{
+			name:   "Pods by UUID and MIG instance, and HPC jobs",
+			idType: GPUUID,
+			pods: map[string][]PodInfo{
+				"GPU-00000000-0000-0000-0000-000000000000": {podA},
+				"1-2": {podB},
+			},
+			jobs: map[string][]string{"0": {"job-1", "job-2"}},
+			want: []DeviceAssignment{
+				{GPU: "0", UUID: "GPU-00000000-0000-0000-0000-000000000000", Device: "nvidia0", Assigned: true, Pods: []PodInfo{podA}, Jobs: []string{"job-1", "job-2"}},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "1", MigProfile: "3g.40gb"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "2", MigProfile: "3g.40gb", Assigned: true, Pods: []PodInfo{podB}},
+			},
+		},
+		{
+			name:   "Pods by device name",
+			idType: DeviceName,
+			pods:   map[string][]PodInfo{"nvidia1": {podA}},
+			want: []DeviceAssignment{
+				{GPU: "0", UUID: "GPU-00000000-0000-0000-0000-000000000000", Device: "nvidia0"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", Assigned: true, Pods: []PodInfo{podA}},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "1", MigProfile: "3g.40gb"},
+				{GPU: "1", UUID: "GPU-11111111-1111-1111-1111-111111111111", Device: "nvidia1", GPUInstanceID: "2", MigProfile: "3g.40gb"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewAssignmentTracker()
+			if tt.pods != nil {
+				tracker.setPods(tt.pods)
+			}
+			if tt.jobs != nil {
+				tracker.setJobs(tt.jobs)
+			}
+
+			got := tracker.Assignments(inventory, tt.idType)
+			assert.Equal(t, tt.want, got.Devices)
+			assert.Equal(t, tt.pods != nil, got.PodsUpdatedAt != nil)
+			assert.Equal(t, tt.jobs != nil, got.JobsUpdatedAt != nil)
+		})
+	}
+}
+
+func TestAssignmentTracker_AssignmentsDoNotShareState(t *testing.T) {
+	inventory := NewInventory(newInventoryTestSystemInfo())
+	tracker := NewAssignmentTracker()
+	tracker.setPods(map[string][]PodInfo{"GPU-00000000-0000-0000-0000-000000000000": {{Name: "pod-a"}}})
+	tracker.setJobs(map[string][]string{"0": {"job-1"}})
+
+	got := tracker.Assignments(inventory, GPUUID)
+	assert.NotSame(t, &tracker.podsUpdatedAt, got.PodsUpdatedAt)
+	assert.NotSame(t, &tracker.jobsUpdatedAt, got.JobsUpdatedAt)
+
+	got.Devices[0].Pods[0].Name = "pod-b"
+	got.Devices[0].Jobs[0] = "job-2"
+	assert.Equal(t, "pod-a", tracker.pods["GPU-00000000-0000-0000-0000-000000000000"][0].Name)
+	assert.Equal(t, "job-1", tracker.jobs["0"][0])
+}
+
+func TestAssignmentTracker_Nil(t *testing.T) {
+	var tracker *AssignmentTracker
+	assert.NotPanics(t, func() {
+		tracker.setPods(map[string][]PodInfo{"nvidia0": {{}}})
+		tracker.setJobs(map[string][]string{"0": {"job"}})
+	})
+}
+
+func TestMetricsServer_Assignments(t *testing.T) {
+	tracker := NewAssignmentTracker()
+	s := &MetricsServer{registry: NewRegistry(), assignments: tracker, gpuIDType: GPUUID}
+
+	rec := httptest.NewRecorder()
+	s.Assignments(rec, httptest.NewRequest(http.MethodGet, "/api/v1/assignments", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	s.SetInventory(NewInventory(newInventoryTestSystemInfo()))
+	tracker.setPods(map[string][]PodInfo{
+		"GPU-00000000-0000-0000-0000-000000000000": {
+			{Name: "pod-a", Namespace: "default", Container: "cuda", SharingStrategy: "time-slicing", Replica: "0"},
+			{Name: "pod-b", Namespace: "default", Container: "cuda", SharingStrategy: "time-slicing", Replica: "1"},
+		},
+	})
+
+	rec = httptest.NewRecorder()
+	s.Assignments(rec, httptest.NewRequest(http.MethodGet, "/api/v1/assignments", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var got Assignments
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.Len(t, got.Devices, 4)
+	assert.NotNil(t, got.PodsUpdatedAt)
+	assert.Nil(t, got.JobsUpdatedAt)
+	require.Len(t, got.Devices[0].Pods, 2)
+	assert.Equal(t, PodInfo{Name: "pod-b", Namespace: "default", Container: "cuda", SharingStrategy: "time-slicing", Replica: "1"},
+		got.Devices[0].Pods[1])
+	assert.False(t, got.Devices[1].Assigned)
+}
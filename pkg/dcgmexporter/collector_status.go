@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CollectorStatus is the outcome of the last collections of a collector.
+type CollectorStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// LastCollection is the end of the last successful collection, and Duration and Series describe it.
+	LastCollection *time.Time `json:"lastCollection,omitempty"`
+	Duration       string     `json:"duration,omitempty"`
+	Series         int        `json:"series"`
+	// LastError is the error of the last collection, if it failed.
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// collectorStatusTracker keeps the CollectorStatus of every collector in the order they were first observed.
+type collectorStatusTracker struct {
+	mtx      sync.Mutex
+	statuses []CollectorStatus
+}
+
+func newCollectorStatusTracker() *collectorStatusTracker {
+	return &collectorStatusTracker{}
+}
+
+// observe records the collection of the collector, that started at start, and returned the metrics or the error.
+func (t *collectorStatusTracker) observe(name string, start time.Time, metrics MetricsByCounter, err error) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	i := slices.IndexFunc(t.statuses, func(s CollectorStatus) bool { return s.Name == name })
+	if i < 0 {
+		i = len(t.statuses)
+		t.statuses = append(t.statuses, CollectorStatus{Name: name})
+	}
+	status := &t.statuses[i]
+
+	if err != nil {
+		status.Healthy = false
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+		return
+	}
+
+	series := 0
+	for _, metricVals := range metrics {
+		series += len(metricVals)
+	}
+
+	status.Healthy = true
+	status.LastCollection = &now
+	status.Duration = now.Sub(start).String()
+	status.Series = series
+	status.LastError = ""
+	status.LastErrorAt = nil
+}
+
+func (t *collectorStatusTracker) get() []CollectorStatus {
+	if t == nil {
+		return nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	statuses := make([]CollectorStatus, len(t.statuses))
+	copy(statuses, t.statuses)
+	return statuses
+}
+
+// collectorName returns the name of a Registry collector, i.e. its Name, if it has one, or its type.
+func collectorName(c Collector) string {
+	if named, ok := c.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", c)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorStatusTracker(t *testing.T) {
+	tracker := newCollectorStatusTracker()
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP"}
+	metrics := MetricsByCounter{counter: {{GPU: "0"}, {GPU: "1"}}}
+
+	tracker.observe("gpu", time.Now(), metrics, nil)
+	tracker.observe("switch", time.Now(), nil, errors.New("boom"))
+
+	statuses := tracker.get()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "gpu", statuses[0].Name)
+	assert.True(t, statuses[0].Healthy)
+	assert.Equal(t, 2, statuses[0].Series)
+	assert.NotNil(t, statuses[0].LastCollection)
+	assert.Equal(t, "switch", statuses[1].Name)
+	assert.False(t, statuses[1].Healthy)
+	assert.Equal(t, "boom", statuses[1].LastError)
+	assert.Nil(t, statuses[1].LastCollection)
+
+	// A failure keeps the last successful collection, and a success clears the error
+	tracker.observe("gpu", time.Now(), nil, errors.New("boom"))
+	tracker.observe("switch", time.Now(), metrics, nil)
+
+	statuses = tracker.get()
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses[0].Healthy)
+	assert.NotNil(t, statuses[0].LastCollection)
+	assert.Equal(t, 2, statuses[0].Series)
+	assert.True(t, statuses[1].Healthy)
+	assert.Empty(t, statuses[1].LastError)
+	assert.Nil(t, statuses[1].LastErrorAt)
+}
+
+func TestRegistry_CollectorStatus(t *testing.T) {
+	counter := Counter{FieldName: dcgmExpXIDErrorsCount}
+	metrics := MetricsByCounter{counter: {{GPU: "0", Counter: counter, Value: "1"}}}
+
+	collector := new(mockCollector)
+	collector.On("GetMetrics").Return(metrics, nil)
+
+	reg := NewRegistry()
+	reg.Register(collector)
+	assert.Nil(t, reg.lastMetrics())
+
+	_, err := reg.Gather()
+	require.NoError(t, err)
+
+	statuses := reg.CollectorStatus()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "*dcgmexporter.mockCollector", statuses[0].Name)
+	assert.True(t, statuses[0].Healthy)
+	assert.Equal(t, 1, statuses[0].Series)
+	assert.Equal(t, metrics, reg.lastMetrics())
+}
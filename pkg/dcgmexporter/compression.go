@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// supportedEncodings are the content codings of the metrics, in the order of preference
+// for the codings accepted by the client with the same quality.
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+// zstdEncoder is shared by the snapshots; EncodeAll is safe for concurrent use.
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+// negotiateEncoding returns the supported content coding of the Accept-Encoding header
+// with the highest quality, or identity, when the client does not accept any of them.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := encodingIdentity, 0.0
+	for _, encoding := range supportedEncodings {
+		q, exists := qualities[encoding]
+		if !exists {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// response returns the body of the snapshot in the content coding, and its ETag.
+// The body, including the metrics of the registry collectors, is rendered once per snapshot,
+// so all scrapes of the same collection cycle get the same representation and ETag.
+func (s *metricsSnapshot) response(registry *Registry, encoding string) ([]byte, string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.etag == "" {
+		if err := s.render(registry); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if encoding == encodingIdentity {
+		return s.body, fmt.Sprintf(`"%s"`, s.etag), nil
+	}
+
+	body, exists := s.encoded[encoding]
+	if !exists {
+		var err error
+		body, err = compress(s.body, encoding)
+		if err != nil {
+			return nil, "", err
+		}
+		if s.encoded == nil {
+			s.encoded = make(map[string][]byte, len(supportedEncodings))
+		}
+		s.encoded[encoding] = body
+	}
+
+	// Every representation has its own strong ETag
+	return body, fmt.Sprintf(`"%s-%s"`, s.etag, encoding), nil
+}
+
+func (s *metricsSnapshot) render(registry *Registry) error {
+	buf := bytes.NewBufferString(s.data)
+
+	if registry != nil {
+		metrics, err := registry.Gather()
+		if err != nil {
+			return fmt.Errorf("failed to gather metrics; err: %w", err)
+		}
+		err = encodeExpMetrics(buf, metrics)
+		if err != nil {
+			return fmt.Errorf("failed to encode metrics; err: %w", err)
+		}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(buf.Bytes())
+
+	s.body = buf.Bytes()
+	s.etag = strconv.FormatUint(h.Sum64(), 16)
+
+	return nil
+}
+
+func compress(body []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case encodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to compress metrics; err: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress metrics; err: %w", err)
+		}
+		return buf.Bytes(), nil
+	case encodingZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder; err: %w", err)
+		}
+		return encoder.EncodeAll(body, make([]byte, 0, len(body)/4)), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding '%s'", encoding)
+	}
+}
+
+// notModified evaluates the conditional headers of the request. If-None-Match takes precedence
+// over If-Modified-Since, and the entity tags are compared weakly, as RFC 9110 requires.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Last-Modified has the resolution of a second
+	return !modified.Truncate(time.Second).After(since)
+}
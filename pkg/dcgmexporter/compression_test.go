@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", encodingIdentity},
+		{"identity", encodingIdentity},
+		{"br", encodingIdentity},
+		{"gzip", encodingGzip},
+		{"gzip, deflate", encodingGzip},
+		{"zstd", encodingZstd},
+		{"gzip, zstd", encodingZstd},
+		{"GZIP", encodingGzip},
+		{"gzip;q=1.0, zstd;q=0.5", encodingGzip},
+		{"zstd;q=0, gzip", encodingGzip},
+		{"gzip;q=0", encodingIdentity},
+		{"*", encodingZstd},
+		{"*;q=0.5, gzip", encodingGzip},
+		{"*, zstd;q=0", encodingGzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func newTestMetricsServer(metrics string) *MetricsServer {
+	s := &MetricsServer{registry: NewRegistry()}
+	s.updateMetrics(metrics)
+	return s
+}
+
+func scrape(s *MetricsServer, header http.Header) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.Metrics(rec, req)
+	return rec.Result()
+}
+
+func TestMetricsServer_Metrics_Encodings(t *testing.T) {
+	metrics := "# HELP DCGM_FI_DEV_GPU_TEMP Temperature.\n# TYPE DCGM_FI_DEV_GPU_TEMP gauge\nDCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n"
+	s := newTestMetricsServer(metrics)
+
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		encodingIdentity: func(r io.Reader) (io.Reader, error) { return r, nil },
+		encodingGzip:     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		encodingZstd:     func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	etags := map[string]bool{}
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			resp := scrape(s, http.Header{"Accept-Encoding": {encoding}})
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, metricsContentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+			if encoding == encodingIdentity {
+				assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			} else {
+				assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+
+			r, err := decode(bytes.NewReader(body))
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, metrics, string(decoded))
+
+			etag := resp.Header.Get("ETag")
+			assert.NotEmpty(t, etag)
+			assert.False(t, etags[etag], "the representations must have different ETags")
+			etags[etag] = true
+		})
+	}
+}
+
+func TestMetricsServer_Metrics_Conditional(t *testing.T) {
+	s := newTestMetricsServer("DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n")
+
+	resp := scrape(s, http.Header{"Accept-Encoding": {"gzip"}})
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	t.Run("same collection cycle", func(t *testing.T) {
+		resp := scrape(s, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+	})
+
+	t.Run("weak comparison of a list", func(t *testing.T) {
+		resp := scrape(s, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {`"other", W/` + etag}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("if modified since", func(t *testing.T) {
+		resp := scrape(s, http.Header{"If-Modified-Since": {resp.Header.Get("Last-Modified")}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("other representation", func(t *testing.T) {
+		resp := scrape(s, http.Header{"If-None-Match": {etag}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("next collection cycle", func(t *testing.T) {
+		s.updateMetrics("DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 43\n")
+
+		resp := scrape(s, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	})
+}
+
+func TestMetricsServer_Metrics_CollectOnScrapeSameOutput(t *testing.T) {
+	xidCounter := Counter{FieldName: "DCGM_EXP_XID_ERRORS_COUNT", PromType: "gauge"}
+	xidMetrics := func(value string) MetricsByCounter {
+		return MetricsByCounter{xidCounter: {{Counter: xidCounter, Value: value, GPU: "0", Labels: map[string]string{}}}}
+	}
+	collector := new(mockCollector)
+	collector.On("GetMetrics").Return(xidMetrics("1"), nil).Once()
+	collector.On("GetMetrics").Return(xidMetrics("2"), nil).Once()
+
+	s := &MetricsServer{registry: NewRegistry()}
+	s.registry.Register(collector)
+
+	// The collections have the same output, the XID errors collector does not
+	collectedAt := time.Now()
+	s.SetCollector(func() (string, time.Time, error) {
+		return "DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n", collectedAt, nil
+	})
+
+	resp := scrape(s, nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	// The scrapes of the same collection are not modified
+	resp = scrape(s, http.Header{"If-None-Match": {etag}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	collectedAt = collectedAt.Add(time.Second)
+	resp = scrape(s, http.Header{"If-None-Match": {etag}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "DCGM_EXP_XID_ERRORS_COUNT{gpu=\"0\"")
+	assert.Contains(t, string(body), "} 2")
+	collector.AssertExpectations(t)
+}
+
+func TestMetricsSnapshot_ResponseIsCached(t *testing.T) {
+	snapshot := newMetricsSnapshot("DCGM_FI_DEV_GPU_TEMP{gpu=\"0\"} 42\n")
+
+	first, firstETag, err := snapshot.response(NewRegistry(), encodingZstd)
+	require.NoError(t, err)
+	second, secondETag, err := snapshot.response(NewRegistry(), encodingZstd)
+	require.NoError(t, err)
+
+	assert.Same(t, &first[0], &second[0])
+	assert.Equal(t, firstETag, secondETag)
+}
@@ -27,8 +27,14 @@ type KubernetesGPUIDType string
 const (
 	GPUUID     KubernetesGPUIDType = "uid"
 	DeviceName KubernetesGPUIDType = "device-name"
+	PCIBusID   KubernetesGPUIDType = "pci-bus-id"
+	GPUIndex   KubernetesGPUIDType = "index"
+	// AutoGPUID detects the format of every device ID of kubelet, and maps the GPUs by UUID.
+	AutoGPUID KubernetesGPUIDType = "auto"
 )
 
+const DefaultGPUSharingStrategy = "time-slicing"
+
 type DeviceOptions struct {
 	Flex       bool  // If true, then monitor all GPUs if MIG mode is disabled or all GPU instances if MIG is enabled.
 	MajorRange []int // The indices of each GPU/NvSwitch to monitor, or -1 to monitor all
@@ -39,6 +45,7 @@ type Config struct {
 	CollectorsFile             string
 	Address                    string
 	CollectInterval            int
+	SampleInterval             int
 	Kubernetes                 bool
 	KubernetesGPUIdType        KubernetesGPUIDType
 	CollectDCP                 bool
@@ -63,14 +70,73 @@ type Config struct {
 	PodResourcesKubeletSocket  string
 	HPCJobMappingDir           string
 	NvidiaResourceNames        []string
+	// DeviceIDRules map the device IDs of the device plugins, that the built-in rules do not know, see DeviceIDRule.
+	DeviceIDRules []DeviceIDRule
+	// GPUSharingStrategy labels the pods sharing a GPU through the replicas of the device plugin.
+	// The series of a shared GPU are reported for each pod, and the values of the GPUSplitCounters
+	// are split evenly among the pods.
+	GPUSharingStrategy string
+	GPUSplitCounters   []string
+	// AdminAddress is the address of the listener serving the health, readiness, status and pprof endpoints.
+	// The listener is disabled, when it is empty. AdminWebConfigFile is its TLS and basic auth config.
+	AdminAddress       string
+	AdminWebConfigFile string
+	// KubernetesAuth requires a bearer token on /metrics, that is validated with a TokenReview,
+	// and authorized with a SubjectAccessReview of the KubernetesAuthVerb on the KubernetesAuthPath,
+	// by default "get" on the request path. The decisions are cached for KubernetesAuthCacheTTL (ms).
+	KubernetesAuth         bool
+	KubernetesAuthVerb     string
+	KubernetesAuthPath     string
+	KubernetesAuthCacheTTL int
+	// CollectOnScrape makes a scrape trigger the collection, instead of collecting every CollectInterval.
+	// The result is reused by the scrapes within the MinCollectInterval (ms).
+	CollectOnScrape    bool
+	MinCollectInterval int
+	// CounterStateFile is the file, where the accumulators of the <FIELD>_COUNTER series are persisted.
+	// If empty, the accumulators start from zero after every restart.
+	CounterStateFile string
+	// DerivedMetrics are the metrics computed from the collected counters, see ExporterConfig.
+	DerivedMetrics []DerivedMetric
+	// StaticLabels are added to every series, e.g. the cluster or the region.
+	StaticLabels map[string]string
+	// MetricRelabelConfigs are the relabeling rules applied to every series, see RelabelConfig.
+	MetricRelabelConfigs []RelabelConfig
+	// SeriesLimit is the maximum number of series per collection, SeriesLimitPerCounter the maximum
+	// number of series per counter, and SeriesLimits overrides it for the named counters. Zero means no limit.
+	SeriesLimit           int
+	SeriesLimitPerCounter int
+	SeriesLimits          map[string]int
 	// OtelMeter is the OpenTelemetry meter to use for metrics
 	// If nil, the OpenTelemetry is disabled
-	OtelMeter                 metric.Meter
-	OtelInheritPodLabels      []string
-	OtelInheritPodAnnotations []string
+	OtelMeter metric.Meter
+	// PodLabels and PodAnnotations are copied from the pods to the metrics of their devices,
+	// for Prometheus and OpenTelemetry alike. The names are sanitized into label names.
+	PodLabels      []string
+	PodAnnotations []string
+	// NamespaceLabels are copied from the namespaces of the pods likewise. The labels of the pods take precedence.
+	NamespaceLabels []string
+	// WorkloadLabels labels the metrics of the devices of the pods with the kind and the name of the workloads
+	// of the pods, following their controllers through the ReplicaSets, the Jobs and the WorkloadKinds,
+	// e.g. kubeflow.org/v1/PyTorchJob.
+	WorkloadLabels bool
+	WorkloadKinds  []string
+	// Kubeconfig is the kubeconfig file of the PodWatcher for out-of-cluster runs.
+	// If empty, the in-cluster config is used.
+	Kubeconfig string
 	// PodWatcher builds up the pod cache to be used
-	// for propagating labels and annotations to otel meters
+	// for propagating labels and annotations. If nil, they are not propagated.
 	PodWatcher *podwatcher.PodWatcher
+	// Assignments keeps the pods and the HPC jobs mapped to the devices in the last collection
+	// for /api/v1/assignments. If nil, they are not kept.
+	Assignments *AssignmentTracker
+	// PodResources is the cache of the pod resources shared by the pod mappers. If nil, every pod mapper lists
+	// the pod resources on every collection. It is refreshed every PodResourcesInterval (ms),
+	// and its last list is used for PodResourcesTTL (ms), when kubelet is unavailable.
+	PodResources         *PodResourcesCache
+	PodResourcesInterval int
+	PodResourcesTTL      int
+	// Version is the version of the exporter shown on the status page.
+	Version string
 }
 
 func (c *Config) OtelEnabled() bool {
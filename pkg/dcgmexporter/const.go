@@ -32,6 +32,41 @@ const (
 	windowSizeInMSLabel = "window_size_in_ms"
 )
 
+// Options, that can be set in the fourth column of the counters file
+const (
+	counterOptionIntegrate   = "integrate"
+	counterOptionSampleStats = "stats"
+	counterOptionQuantiles   = "quantiles"
+	counterOptionBuckets     = "buckets"
+)
+
+// counterOptionListSeparator separates the values of list options, e.g. "quantiles=0.5|0.99"
+const counterOptionListSeparator = "|"
+
+var defaultSampleStatsQuantiles = []float64{0.5, 0.9, 0.99}
+
+// defaultHistogramBuckets suit the percentage counters, e.g. utilization
+var defaultHistogramBuckets = []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+const (
+	integratedCounterSuffix = "_COUNTER"
+	sampleMinSuffix         = "_MIN"
+	sampleMaxSuffix         = "_MAX"
+	sampleMeanSuffix        = "_MEAN"
+	sampleQuantileSuffix    = "_QUANTILE"
+)
+
+const (
+	histogramBucketSuffix = "_bucket"
+	histogramSumSuffix    = "_sum"
+	histogramCountSuffix  = "_count"
+)
+
+const (
+	quantileLabel = "quantile"
+	bucketLabel   = "le"
+)
+
 // DCGMDbgLvl is a DCGM library debug level.
 const (
 	DCGMDbgLvlNone  = "NONE"
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// integratedSeries is the accumulator state of a single <FIELD>_COUNTER series.
+type integratedSeries struct {
+	total      float64
+	lastSeen   time.Time
+	generation uint64
+}
+
+// counterIntegrator synthesizes the <FIELD>_COUNTER series for counters with the "integrate" option.
+// Every cycle it adds value × elapsed seconds since the series was seen last time, so that power (W)
+// becomes energy (J) and utilization (%) becomes busy-seconds (%·s).
+type counterIntegrator struct {
+	stateFile  string
+	series     map[string]*integratedSeries
+	generation uint64
+	now        func() time.Time
+}
+
+// counterStateVersion is the version of the state file, the series are keyed by metricFingerprint.
+const counterStateVersion = 1
+
+// counterIntegratorState is the on-disk representation of the accumulators.
+type counterIntegratorState struct {
+	Version int                `json:"version"`
+	Series  map[string]float64 `json:"series"`
+}
+
+func newCounterIntegrator(stateFile string) *counterIntegrator {
+	ci := &counterIntegrator{
+		stateFile: stateFile,
+		series:    make(map[string]*integratedSeries),
+		now:       time.Now,
+	}
+
+	if stateFile != "" {
+		err := ci.load()
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to load counter state from '%s'; starting from zero", stateFile)
+		}
+	}
+
+	return ci
+}
+
+// Integrate returns the <FIELD>_COUNTER series for every counter with the "integrate" option.
+// The accumulators of series, that are not present in the metrics, are evicted.
+func (ci *counterIntegrator) Integrate(metrics MetricsByCounter) MetricsByCounter {
+	now := ci.now()
+	ci.generation++
+
+	integrated := make(MetricsByCounter)
+
+	for counter, metricVals := range metrics {
+		if !counter.integrate() {
+			continue
+		}
+
+		newCounter := counter
+		newCounter.FieldName += integratedCounterSuffix
+		newCounter.PromType = "counter"
+		newCounter.Help = fmt.Sprintf("%s Integrated over time in seconds.", counter.Help)
+		newCounter.Options = nil
+
+		newMetrics := make([]Metric, 0, len(metricVals))
+		for _, metricVal := range metricVals {
+			val, err := strconv.ParseFloat(metricVal.Value, 64)
+			if err != nil {
+				logrus.Warnf("Failed to parse metric value %s as float64: %v", metricVal.Value, err)
+				continue
+			}
+
+			fp := metricVal.metricFingerprint()
+			s, exists := ci.series[fp]
+			if !exists {
+				s = &integratedSeries{}
+				ci.series[fp] = s
+			}
+
+			// The first sample of a series, including the first sample after a restart,
+			// only starts the clock.
+			if !s.lastSeen.IsZero() {
+				s.total += val * now.Sub(s.lastSeen).Seconds()
+			}
+			s.lastSeen = now
+			s.generation = ci.generation
+
+			newMetricVal := metricVal
+			newMetricVal.Counter = newCounter
+			newMetricVal.Value = strconv.FormatFloat(s.total, 'f', -1, 64)
+			newMetrics = append(newMetrics, newMetricVal)
+		}
+
+		integrated[newCounter] = newMetrics
+	}
+
+	for fp, s := range ci.series {
+		if s.generation != ci.generation {
+			delete(ci.series, fp)
+		}
+	}
+
+	if ci.stateFile != "" {
+		err := ci.save()
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to save counter state to '%s'", ci.stateFile)
+		}
+	}
+
+	return integrated
+}
+
+func (ci *counterIntegrator) load() error {
+	file, err := os.Open(ci.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var state counterIntegratorState
+	err = json.NewDecoder(file).Decode(&state)
+	if err != nil {
+		return err
+	}
+
+	if state.Version != counterStateVersion {
+		return fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+	for fp, total := range state.Series {
+		ci.series[fp] = &integratedSeries{total: total}
+	}
+
+	logrus.Infof("Loaded %d counter accumulators from '%s'", len(state.Series), ci.stateFile)
+
+	return nil
+}
+
+// save writes the accumulators into a temporary file and renames it,
+// so that the state file is never left partially written.
+func (ci *counterIntegrator) save() error {
+	state := counterIntegratorState{
+		Version: counterStateVersion,
+		Series:  make(map[string]float64, len(ci.series)),
+	}
+	for fp, s := range ci.series {
+		state.Series[fp] = s.total
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(ci.stateFile), filepath.Base(ci.stateFile)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(state)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), ci.stateFile)
+}
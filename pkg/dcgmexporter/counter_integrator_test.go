@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterIntegrator_Integrate(t *testing.T) {
+	powerCounter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_POWER_USAGE,
+		FieldName: "DCGM_FI_DEV_POWER_USAGE",
+		PromType:  "gauge",
+		Options:   &CounterOptions{Integrate: true},
+	}
+	tempCounter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_GPU_TEMP,
+		FieldName: "DCGM_FI_DEV_GPU_TEMP",
+		PromType:  "gauge",
+	}
+
+	newMetric := func(gpu, value string) Metric {
+		return Metric{
+			Counter:    powerCounter,
+			GPU:        gpu,
+			Value:      value,
+			Labels:     map[string]string{},
+			Attributes: map[string]string{},
+		}
+	}
+
+	start := time.Now()
+	now := start
+	ci := newCounterIntegrator("")
+	ci.now = func() time.Time { return now }
+
+	integrated := ci.Integrate(MetricsByCounter{
+		powerCounter: {newMetric("0", "100"), newMetric("1", "200")},
+		tempCounter:  {{Counter: tempCounter, GPU: "0", Value: "40"}},
+	})
+	require.Len(t, integrated, 1, "only counters with the integrate option are expected")
+	for counter, metrics := range integrated {
+		assert.Equal(t, "DCGM_FI_DEV_POWER_USAGE_COUNTER", counter.FieldName)
+		assert.Equal(t, "counter", counter.PromType)
+		for _, m := range metrics {
+			assert.Equal(t, "0", m.Value, "the first sample must only start the clock")
+		}
+	}
+
+	now = start.Add(10 * time.Second)
+	integrated = ci.Integrate(MetricsByCounter{
+		powerCounter: {newMetric("0", "150")},
+	})
+	require.Len(t, integrated, 1)
+	for _, metrics := range integrated {
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "1500", metrics[0].Value)
+	}
+	assert.Len(t, ci.series, 1, "the vanished GPU 1 series is expected to be evicted")
+}
+
+func TestCounterIntegrator_Persistence(t *testing.T) {
+	counter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_GPU_UTIL,
+		FieldName: "DCGM_FI_DEV_GPU_UTIL",
+		PromType:  "gauge",
+		Options:   &CounterOptions{Integrate: true},
+	}
+	metrics := MetricsByCounter{
+		counter: {{Counter: counter, GPU: "0", Value: "50"}},
+	}
+	stateFile := filepath.Join(t.TempDir(), "counters.json")
+
+	start := time.Now()
+	ci := newCounterIntegrator(stateFile)
+	ci.now = func() time.Time { return start }
+	ci.Integrate(metrics)
+	ci.now = func() time.Time { return start.Add(2 * time.Second) }
+	ci.Integrate(metrics)
+
+	restored := newCounterIntegrator(stateFile)
+	restored.now = func() time.Time { return start.Add(time.Hour) }
+	integrated := restored.Integrate(metrics)
+	require.Len(t, integrated, 1)
+	for _, metrics := range integrated {
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "100", metrics[0].Value, "the downtime must not be integrated")
+	}
+}
+
+func TestCounterIntegrator_StateFileVersion(t *testing.T) {
+	counter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_GPU_UTIL,
+		FieldName: "DCGM_FI_DEV_GPU_UTIL",
+		PromType:  "gauge",
+		Options:   &CounterOptions{Integrate: true},
+	}
+	metric := Metric{Counter: counter, GPU: "0", Value: "50"}
+
+	// The state file of another version is ignored
+	file, err := os.CreateTemp(t.TempDir(), "counters-*.json")
+	require.NoError(t, err)
+	_, err = file.WriteString(fmt.Sprintf(`{"version": %d, "series": {%q: 100}}`,
+		counterStateVersion+1, metric.metricFingerprint()))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	ci := newCounterIntegrator(file.Name())
+	assert.Empty(t, ci.series)
+	integrated := ci.Integrate(MetricsByCounter{counter: {metric}})
+	for _, metrics := range integrated {
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "0", metrics[0].Value)
+	}
+
+	// and overwritten with the current version
+	restored := newCounterIntegrator(file.Name())
+	assert.Len(t, restored.series, 1)
+}
@@ -82,6 +82,17 @@ func WatchFieldGroup(
 }
 
 func SetupDcgmFieldsWatch(deviceFields []dcgm.Short, sysInfo SystemInfo, collectIntervalUsec int64) ([]dcgm.GroupHandle, dcgm.FieldHandle, []func(), error) {
+	return SetupDcgmFieldsWatchWithHistory(deviceFields, sysInfo, collectIntervalUsec, 0.0, 1)
+}
+
+// SetupDcgmFieldsWatchWithHistory watches the fields and keeps the samples, that are not older than maxKeepAge seconds,
+// but no more than maxKeepSamples samples (0 means no limit).
+func SetupDcgmFieldsWatchWithHistory(deviceFields []dcgm.Short,
+	sysInfo SystemInfo,
+	updateFreqUsec int64,
+	maxKeepAge float64,
+	maxKeepSamples int32,
+) ([]dcgm.GroupHandle, dcgm.FieldHandle, []func(), error) {
 	var err error
 	var cleanups []func()
 	var cleanup func()
@@ -114,7 +125,7 @@ func SetupDcgmFieldsWatch(deviceFields []dcgm.Short, sysInfo SystemInfo, collect
 
 		cleanups = append(cleanups, cleanup)
 
-		err = WatchFieldGroup(gr, fieldGroup, collectIntervalUsec, 0.0, 1)
+		err = WatchFieldGroup(gr, fieldGroup, updateFreqUsec, maxKeepAge, maxKeepSamples)
 		if err != nil {
 			goto fail
 		}
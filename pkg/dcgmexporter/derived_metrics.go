@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"maps"
+	"math"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+type derivedCounter struct {
+	counter Counter
+	expr    expression
+	inputs  []string
+}
+
+// derivedMetricsMapper evaluates the derived metrics of the exporter configuration.
+// An expression is evaluated per entity, i.e. per GPU or GPU instance, over the counters of that entity.
+// The result is labelled like the first input of the expression. Entities, that miss an input,
+// and non-finite results, e.g. of a division by zero, are skipped.
+type derivedMetricsMapper struct {
+	counters []derivedCounter
+}
+
+func newDerivedMetricsMapper(c *Config) *derivedMetricsMapper {
+	p := &derivedMetricsMapper{}
+
+	for _, dm := range c.DerivedMetrics {
+		expr, err := parseExpression(dm.Expr)
+		if err != nil {
+			logrus.WithError(err).Warnf("Skipping derived metric '%s'", dm.Name)
+			continue
+		}
+
+		inputs := expr.counters(nil)
+		if len(inputs) == 0 {
+			logrus.Warnf("Skipping derived metric '%s', which does not refer to any counter", dm.Name)
+			continue
+		}
+
+		promType := dm.Type
+		if promType == "" {
+			promType = "gauge"
+		}
+
+		p.counters = append(p.counters, derivedCounter{
+			counter: Counter{FieldName: dm.Name, PromType: promType, Help: dm.Help},
+			expr:    expr,
+			inputs:  inputs,
+		})
+	}
+
+	return p
+}
+
+// derivedCounters returns the counters of the derived metrics.
+func derivedCounters(c *Config) []Counter {
+	var counters []Counter
+	for _, dc := range newDerivedMetricsMapper(c).counters {
+		counters = append(counters, dc.counter)
+	}
+	return counters
+}
+
+func (p *derivedMetricsMapper) Name() string {
+	return "derivedMetricsMapper"
+}
+
+// derivedEntity is the key of the entity, which counters are the inputs of an expression.
+type derivedEntity struct {
+	gpu           string
+	uuid          string
+	gpuInstanceID string
+}
+
+func (p *derivedMetricsMapper) Process(metrics MetricsByCounter, _ SystemInfo) error {
+	values := map[derivedEntity]map[string]float64{}
+	templates := map[derivedEntity]map[string]Metric{}
+	var order []derivedEntity
+
+	for counter, metricVals := range metrics {
+		for _, metricVal := range metricVals {
+			val, err := strconv.ParseFloat(metricVal.Value, 64)
+			if err != nil {
+				continue
+			}
+
+			entity := derivedEntity{gpu: metricVal.GPU, uuid: metricVal.GPUUUID, gpuInstanceID: metricVal.GPUInstanceID}
+			if _, exists := values[entity]; !exists {
+				values[entity] = map[string]float64{}
+				templates[entity] = map[string]Metric{}
+				order = append(order, entity)
+			}
+			values[entity][counter.FieldName] = val
+			templates[entity][counter.FieldName] = metricVal
+		}
+	}
+
+	// Derived metrics are evaluated in the order of the configuration,
+	// so that an expression can refer to the derived metrics defined above it.
+	for _, dc := range p.counters {
+		var newMetrics []Metric
+
+		for _, entity := range order {
+			val, err := dc.expr.eval(values[entity])
+			if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+				continue
+			}
+			values[entity][dc.counter.FieldName] = val
+
+			newMetric := templates[entity][dc.inputs[0]]
+			newMetric.Counter = dc.counter
+			newMetric.Value = strconv.FormatFloat(val, 'f', -1, 64)
+			newMetric.Labels = maps.Clone(newMetric.Labels)
+			newMetric.Attributes = maps.Clone(newMetric.Attributes)
+			newMetric.Samples = nil
+			templates[entity][dc.counter.FieldName] = newMetric
+
+			newMetrics = append(newMetrics, newMetric)
+		}
+
+		if len(newMetrics) > 0 {
+			metrics[dc.counter] = newMetrics
+		}
+	}
+
+	return nil
+}
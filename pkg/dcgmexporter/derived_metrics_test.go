@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExporterConfig(t *testing.T) {
+	config, err := parseExporterConfig(strings.NewReader(`
+derivedMetrics:
+  - name: DCGM_EXP_FB_USED_RATIO
+    help: Ratio of the used framebuffer memory.
+    expr: DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []DerivedMetric{
+		{
+			Name: "DCGM_EXP_FB_USED_RATIO",
+			Type: "gauge",
+			Help: "Ratio of the used framebuffer memory.",
+			Expr: "DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)",
+		},
+	}, config.DerivedMetrics)
+
+	config, err = parseExporterConfig(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Empty(t, config.DerivedMetrics)
+
+	for name, content := range map[string]string{
+		"unknown field":  "derivedMetric: []",
+		"invalid name":   "derivedMetrics: [{name: 'fb ratio', expr: DCGM_FI_DEV_FB_USED}]",
+		"invalid type":   "derivedMetrics: [{name: A, type: histogram, expr: DCGM_FI_DEV_FB_USED}]",
+		"invalid expr":   "derivedMetrics: [{name: A, expr: 'DCGM_FI_DEV_FB_USED +'}]",
+		"constant expr":  "derivedMetrics: [{name: A, expr: '1 + 1'}]",
+		"duplicate name": "derivedMetrics: [{name: A, expr: B}, {name: A, expr: C}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseExporterConfig(strings.NewReader(content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDerivedMetricsMapper(t *testing.T) {
+	used := Counter{FieldID: dcgm.DCGM_FI_DEV_FB_USED, FieldName: "DCGM_FI_DEV_FB_USED", PromType: "gauge"}
+	free := Counter{FieldID: dcgm.DCGM_FI_DEV_FB_FREE, FieldName: "DCGM_FI_DEV_FB_FREE", PromType: "gauge"}
+
+	metrics := MetricsByCounter{
+		used: {
+			{Counter: used, GPU: "0", UUID: "UUID", GPUUUID: "GPU-0", Value: "30", Labels: map[string]string{"driver": "550"}},
+			{Counter: used, GPU: "1", UUID: "UUID", GPUUUID: "GPU-1", Value: "0"},
+			{Counter: used, GPU: "2", UUID: "UUID", GPUUUID: "GPU-2", Value: "5"},
+		},
+		free: {
+			{Counter: free, GPU: "0", UUID: "UUID", GPUUUID: "GPU-0", Value: "10"},
+			{Counter: free, GPU: "1", UUID: "UUID", GPUUUID: "GPU-1", Value: "0"},
+		},
+	}
+
+	mapper := newDerivedMetricsMapper(&Config{
+		DerivedMetrics: []DerivedMetric{
+			{Name: "FB_USED_RATIO", Expr: "DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)"},
+			{Name: "FB_USED_PERCENT", Type: "gauge", Expr: "FB_USED_RATIO * 100"},
+		},
+	})
+	require.NoError(t, mapper.Process(metrics, SystemInfo{}))
+
+	ratio := Counter{FieldName: "FB_USED_RATIO", PromType: "gauge"}
+	// GPU 1 divides by zero and GPU 2 has no DCGM_FI_DEV_FB_FREE
+	require.Len(t, metrics[ratio], 1)
+	assert.Equal(t, "0", metrics[ratio][0].GPU)
+	assert.Equal(t, "GPU-0", metrics[ratio][0].GPUUUID)
+	assert.Equal(t, "0.75", metrics[ratio][0].Value)
+	assert.Equal(t, map[string]string{"driver": "550"}, metrics[ratio][0].Labels)
+
+	percent := Counter{FieldName: "FB_USED_PERCENT", PromType: "gauge"}
+	require.Len(t, metrics[percent], 1)
+	assert.Equal(t, "75", metrics[percent][0].Value)
+
+	metrics[ratio][0].Labels["driver"] = "555"
+	assert.Equal(t, "550", metrics[used][0].Labels["driver"], "the labels of the inputs must not change")
+}
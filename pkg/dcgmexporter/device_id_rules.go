@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The named groups of the regexes of the DeviceIDRules.
+const (
+	deviceIDIndexGroup         = "index"
+	deviceIDUUIDGroup          = "uuid"
+	deviceIDPCIBusIDGroup      = "pci_bus_id"
+	deviceIDGPUInstanceIDGroup = "gpu_instance_id"
+)
+
+// DeviceIDRule maps the device IDs of a device plugin, that the built-in rules do not know, to the GPUs.
+// The regex is fully anchored, and its named groups identify the device:
+//   - index, uuid or pci_bus_id: the GPU, by its index, e.g. 0, UUID, e.g. GPU-b8ea3855-276c-c9cb-b366-c6fa655957c5,
+//     or PCI bus ID, e.g. 0000:3b:00.0. At least one of them is required.
+//   - gpu_instance_id: the GPU instance of the MIG device of the GPU, if the device is a MIG device.
+//
+// The rules are evaluated in order, before the built-in rules, and the first matching rule maps the device ID.
+type DeviceIDRule struct {
+	Regex string `yaml:"regex"`
+}
+
+type deviceIDRule struct {
+	regex *regexp.Regexp
+}
+
+func newDeviceIDRule(rule DeviceIDRule) (deviceIDRule, error) {
+	regex, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+	if err != nil {
+		return deviceIDRule{}, fmt.Errorf("invalid regex '%s'; err: %w", rule.Regex, err)
+	}
+
+	gpuGroup := false
+	for _, name := range regex.SubexpNames() {
+		switch name {
+		case "", deviceIDGPUInstanceIDGroup:
+		case deviceIDIndexGroup, deviceIDUUIDGroup, deviceIDPCIBusIDGroup:
+			gpuGroup = true
+		default:
+			return deviceIDRule{}, fmt.Errorf("unknown group '%s' of the regex '%s'", name, rule.Regex)
+		}
+	}
+	if !gpuGroup {
+		return deviceIDRule{}, fmt.Errorf("the regex '%s' has none of the groups '%s', '%s' and '%s'", rule.Regex,
+			deviceIDIndexGroup, deviceIDUUIDGroup, deviceIDPCIBusIDGroup)
+	}
+
+	return deviceIDRule{regex: regex}, nil
+}
+
+func newDeviceIDRules(rules []DeviceIDRule) ([]deviceIDRule, error) {
+	var compiled []deviceIDRule
+	for i, rule := range rules {
+		r, err := newDeviceIDRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device ID rule #%d; err: %w", i, err)
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// keys returns the IDs of the metrics, which belong to the device ID, and whether the rule matches the device ID.
+// A GPU is keyed by all its IDs, its UUID, PCI bus ID, index and device name, so that the rule maps it
+// with every KubernetesGPUIDType. A MIG device is keyed like the metrics of its GPU instance.
+func (r deviceIDRule) keys(deviceID string, sysInfo SystemInfo) ([]string, bool) {
+	match := r.regex.FindStringSubmatch(deviceID)
+	if match == nil {
+		return nil, false
+	}
+	group := func(name string) string {
+		if i := r.regex.SubexpIndex(name); i >= 0 {
+			return match[i]
+		}
+		return ""
+	}
+
+	index, uuid, pciBusID := group(deviceIDIndexGroup), group(deviceIDUUIDGroup), group(deviceIDPCIBusIDGroup)
+	if uuid != "" && !strings.HasPrefix(uuid, "GPU-") {
+		uuid = "GPU-" + uuid
+	}
+	if pciBusID != "" {
+		pciBusID = normalizePCIBusID(pciBusID)
+	}
+
+	// The other IDs of the GPU are the ones of the system
+	for i := uint(0); i < sysInfo.GPUCount; i++ {
+		device := sysInfo.GPUs[i].DeviceInfo
+		if (index != "" && fmt.Sprint(device.GPU) == index) || (uuid != "" && device.UUID == uuid) ||
+			(pciBusID != "" && normalizePCIBusID(device.PCI.BusID) == pciBusID) {
+			index, uuid, pciBusID = fmt.Sprint(device.GPU), device.UUID, normalizePCIBusID(device.PCI.BusID)
+			break
+		}
+	}
+
+	keys := []string{}
+	if gpuInstanceID := group(deviceIDGPUInstanceIDGroup); gpuInstanceID != "" {
+		if index != "" {
+			keys = append(keys, fmt.Sprintf("%s-%s", index, gpuInstanceID))
+		}
+	} else {
+		for _, key := range []string{uuid, pciBusID, index} {
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+		if index != "" {
+			keys = append(keys, "nvidia"+index)
+		}
+	}
+	if !slices.Contains(keys, deviceID) {
+		keys = append(keys, deviceID)
+	}
+
+	return keys, true
+}
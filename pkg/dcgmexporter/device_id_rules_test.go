@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+func deviceIDRulesTestSysInfo() SystemInfo {
+	sysInfo := SystemInfo{GPUCount: 2}
+	sysInfo.GPUs[0] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 0, UUID: "GPU-0", PCI: dcgm.PCIInfo{BusID: "00000000:3B:00.0"}}}
+	sysInfo.GPUs[1] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 1, UUID: "GPU-1", PCI: dcgm.PCIInfo{BusID: "00000000:86:00.0"}}}
+	return sysInfo
+}
+
+func TestDeviceIDRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		regex        string
+		deviceID     string
+		expectedKeys []string
+		expectedOK   bool
+	}{
+		{
+			name:         "index",
+			regex:        `acme-gpu(?P<index>[0-9]+)`,
+			deviceID:     "acme-gpu1",
+			expectedKeys: []string{"GPU-1", "0000:86:00.0", "1", "nvidia1", "acme-gpu1"},
+			expectedOK:   true,
+		},
+		{
+			name:         "UUID without prefix",
+			regex:        `acme/(?P<uuid>[0-9]+)/shared`,
+			deviceID:     "acme/1/shared",
+			expectedKeys: []string{"GPU-1", "0000:86:00.0", "1", "nvidia1", "acme/1/shared"},
+			expectedOK:   true,
+		},
+		{
+			name:         "PCI bus ID",
+			regex:        `pci-(?P<pci_bus_id>.+)`,
+			deviceID:     "pci-0000:3B:00.0",
+			expectedKeys: []string{"GPU-0", "0000:3b:00.0", "0", "nvidia0", "pci-0000:3B:00.0"},
+			expectedOK:   true,
+		},
+		{
+			name:         "MIG device",
+			regex:        `acme-gpu(?P<index>[0-9]+)-mig(?P<gpu_instance_id>[0-9]+)`,
+			deviceID:     "acme-gpu1-mig3",
+			expectedKeys: []string{"1-3", "acme-gpu1-mig3"},
+			expectedOK:   true,
+		},
+		{
+			name:         "MIG device of an unknown GPU",
+			regex:        `pci-(?P<pci_bus_id>.+)-mig(?P<gpu_instance_id>[0-9]+)`,
+			deviceID:     "pci-0000:af:00.0-mig3",
+			expectedKeys: []string{"pci-0000:af:00.0-mig3"},
+			expectedOK:   true,
+		},
+		{
+			name:         "unknown GPU",
+			regex:        `acme-gpu(?P<index>[0-9]+)`,
+			deviceID:     "acme-gpu7",
+			expectedKeys: []string{"7", "nvidia7", "acme-gpu7"},
+			expectedOK:   true,
+		},
+		{
+			name:     "fully anchored",
+			regex:    `acme-gpu(?P<index>[0-9]+)`,
+			deviceID: "acme-gpu1::2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := newDeviceIDRule(DeviceIDRule{Regex: tt.regex})
+			require.NoError(t, err)
+
+			keys, ok := rule.keys(tt.deviceID, deviceIDRulesTestSysInfo())
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedKeys, keys)
+		})
+	}
+}
+
+func TestParseExporterConfigDeviceIDRules(t *testing.T) {
+	config, err := parseExporterConfig(strings.NewReader(`
+deviceIDRules:
+  - regex: 'acme-gpu(?P<index>[0-9]+)'
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []DeviceIDRule{{Regex: "acme-gpu(?P<index>[0-9]+)"}}, config.DeviceIDRules)
+
+	for name, content := range map[string]string{
+		"invalid regex":   "deviceIDRules: [{regex: '('}]",
+		"unknown group":   "deviceIDRules: [{regex: '(?P<gpu>[0-9]+)'}]",
+		"no GPU group":    "deviceIDRules: [{regex: 'mig(?P<gpu_instance_id>[0-9]+)'}]",
+		"no named groups": "deviceIDRules: [{regex: 'acme-gpu[0-9]+'}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseExporterConfig(strings.NewReader(content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPodMapper_DeviceIDRules(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name: "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{
+					// The rule takes precedence over the built-in GKE rule
+					{ResourceName: nvidiaResourceName, DeviceIds: []string{"nvidia0/gi1"}},
+				},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+	metrics := MetricsByCounter{counter: {
+		{Counter: counter, Value: "42", GPU: "0", GPUUUID: "GPU-0", GPUDevice: "nvidia0", Attributes: map[string]string{}},
+		{Counter: counter, Value: "42", GPU: "1", GPUUUID: "GPU-1", GPUDevice: "nvidia1", Attributes: map[string]string{}},
+	}}
+
+	podMapper, err := NewPodMapper(&Config{
+		KubernetesGPUIdType: GPUUID,
+		PodResources:        cache,
+		DeviceIDRules:       []DeviceIDRule{{Regex: `nvidia[0-9]+/gi(?P<index>[0-9]+)`}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, podMapper.Process(metrics, deviceIDRulesTestSysInfo()))
+
+	assert.Empty(t, metrics[counter][0].Attributes[podAttribute])
+	assert.Equal(t, "pod-a", metrics[counter][1].Attributes[podAttribute])
+
+	_, err = NewPodMapper(&Config{DeviceIDRules: []DeviceIDRule{{Regex: "("}}})
+	assert.Error(t, err)
+}
+
+func TestPodMapper_DeviceIDRules_SharedGPU(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"acme-gpu1::0"}}},
+			}}},
+			{Name: "pod-b", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"acme-gpu1::2"}}},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+	metrics := MetricsByCounter{counter: {
+		{Counter: counter, Value: "42", GPU: "0", GPUUUID: "GPU-0", GPUDevice: "nvidia0", Attributes: map[string]string{}},
+		{Counter: counter, Value: "42", GPU: "1", GPUUUID: "GPU-1", GPUDevice: "nvidia1", Attributes: map[string]string{}},
+	}}
+
+	podMapper, err := NewPodMapper(&Config{
+		KubernetesGPUIdType: DeviceName,
+		PodResources:        cache,
+		DeviceIDRules:       []DeviceIDRule{{Regex: `acme-gpu(?P<index>[0-9]+)`}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, podMapper.Process(metrics, deviceIDRulesTestSysInfo()))
+
+	// The replicas of the GPU are mapped by the rule, without their suffix
+	var replicas [][2]string
+	for _, m := range metrics[counter] {
+		if m.GPU == "1" {
+			replicas = append(replicas, [2]string{m.Attributes[podAttribute], m.Attributes[replicaAttribute]})
+		}
+	}
+	assert.ElementsMatch(t, [][2]string{{"pod-a", "0"}, {"pod-b", "2"}}, replicas)
+	assert.Empty(t, metrics[counter][0].Attributes[podAttribute])
+}
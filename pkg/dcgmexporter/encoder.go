@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+)
+
+var (
+	// labelValueEscaper and helpEscaper escape the label values and the help texts, as the text format requires
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// Do not keep the buffers grown by an exceptionally large output
+	if buf.Cap() > 64<<20 {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// entityLabelsKey holds the fields of the Metric, that the fixed labels of an entity are rendered from.
+type entityLabelsKey struct {
+	gpu           string
+	uuid          string
+	gpuUUID       string
+	pciBusID      string
+	device        string
+	modelName     string
+	migProfile    string
+	gpuInstanceID string
+	hostname      string
+}
+
+type entityLabels struct {
+	rendered   []byte
+	generation uint64
+}
+
+// metricsEncoder renders the metrics in the Prometheus text format, with the labels of the entity type,
+// e.g. gpu and UUID for the GPUs or nvswitch for the switches. The fixed labels of every entity,
+// e.g. gpu, UUID, pci_bus_id, device, modelName and Hostname, are rendered once and reused
+// by all counters and collections, as long as the entity is present.
+type metricsEncoder struct {
+	entityType dcgm.Field_Entity_Group
+	labels     map[entityLabelsKey]*entityLabels
+	generation uint64
+
+	counters []Counter
+	keys     []string
+}
+
+func newMetricsEncoder(entityType dcgm.Field_Entity_Group) *metricsEncoder {
+	return &metricsEncoder{
+		entityType: entityType,
+		labels:     make(map[entityLabelsKey]*entityLabels),
+	}
+}
+
+// Encode appends the metrics to the buffer. The counters are ordered by compareCounters.
+func (e *metricsEncoder) Encode(buf *bytes.Buffer, metrics MetricsByCounter) {
+	e.generation++
+
+	e.counters = e.counters[:0]
+	for counter := range metrics {
+		e.counters = append(e.counters, counter)
+	}
+	slices.SortFunc(e.counters, compareCounters)
+
+	for _, counter := range e.counters {
+		buf.WriteString("# HELP ")
+		buf.WriteString(counter.FieldName)
+		buf.WriteByte(' ')
+		_, _ = helpEscaper.WriteString(buf, counter.Help)
+		buf.WriteString("\n# TYPE ")
+		buf.WriteString(counter.FieldName)
+		buf.WriteByte(' ')
+		buf.WriteString(counter.PromType)
+
+		for i := range metrics[counter] {
+			e.encodeMetric(buf, counter, &metrics[counter][i])
+		}
+		buf.WriteByte('\n')
+	}
+
+	for key, l := range e.labels {
+		if l.generation != e.generation {
+			delete(e.labels, key)
+		}
+	}
+}
+
+func (e *metricsEncoder) encodeMetric(buf *bytes.Buffer, counter Counter, m *Metric) {
+	buf.WriteByte('\n')
+	buf.WriteString(counter.FieldName)
+	buf.WriteString(m.NameSuffix)
+	buf.WriteByte('{')
+	buf.Write(e.entityLabels(m))
+
+	e.encodeLabels(buf, m.Labels)
+	// The switch, link and CPU formats do not render the attributes
+	if e.entityType == dcgm.FE_GPU {
+		e.encodeLabels(buf, m.Attributes)
+	}
+
+	buf.WriteString("} ")
+	buf.WriteString(m.Value)
+}
+
+func (e *metricsEncoder) encodeLabels(buf *bytes.Buffer, labels map[string]string) {
+	e.keys = e.keys[:0]
+	for k := range labels {
+		e.keys = append(e.keys, k)
+	}
+	sort.Strings(e.keys)
+
+	for _, k := range e.keys {
+		writeLabel(buf, ",", k, labels[k])
+	}
+}
+
+func (e *metricsEncoder) entityLabels(m *Metric) []byte {
+	key := entityLabelsKey{
+		gpu:           m.GPU,
+		uuid:          m.UUID,
+		gpuUUID:       m.GPUUUID,
+		pciBusID:      m.GPUPCIBusID,
+		device:        m.GPUDevice,
+		modelName:     m.GPUModelName,
+		migProfile:    m.MigProfile,
+		gpuInstanceID: m.GPUInstanceID,
+		hostname:      m.Hostname,
+	}
+
+	l, exists := e.labels[key]
+	if !exists {
+		l = &entityLabels{rendered: e.renderEntityLabels(m)}
+		e.labels[key] = l
+	}
+	l.generation = e.generation
+
+	return l.rendered
+}
+
+func (e *metricsEncoder) renderEntityLabels(m *Metric) []byte {
+	var buf bytes.Buffer
+
+	switch e.entityType {
+	case dcgm.FE_SWITCH:
+		writeLabel(&buf, "", "nvswitch", m.GPU)
+	case dcgm.FE_LINK:
+		writeLabel(&buf, "", "nvlink", m.GPU)
+		writeLabel(&buf, ",", "nvswitch", m.GPUDevice)
+	case dcgm.FE_CPU:
+		writeLabel(&buf, "", "cpu", m.GPU)
+	case dcgm.FE_CPU_CORE:
+		writeLabel(&buf, "", "cpucore", m.GPU)
+		writeLabel(&buf, ",", "cpu", m.GPUDevice)
+	default:
+		writeLabel(&buf, "", "gpu", m.GPU)
+		if m.GPUUUID != "" {
+			writeLabel(&buf, ",", m.UUID, m.GPUUUID)
+		}
+		if m.GPUPCIBusID != "" {
+			writeLabel(&buf, ",", "pci_bus_id", m.GPUPCIBusID)
+		}
+		if m.GPUDevice != "" {
+			writeLabel(&buf, ",", "device", m.GPUDevice)
+		}
+		if m.GPUModelName != "" {
+			writeLabel(&buf, ",", "modelName", m.GPUModelName)
+		}
+		if m.MigProfile != "" {
+			writeLabel(&buf, ",", "GPU_I_PROFILE", m.MigProfile)
+			writeLabel(&buf, ",", "GPU_I_ID", m.GPUInstanceID)
+		}
+	}
+
+	if m.Hostname != "" {
+		writeLabel(&buf, ",", "Hostname", m.Hostname)
+	}
+
+	return buf.Bytes()
+}
+
+func writeLabel(buf *bytes.Buffer, separator, name, value string) {
+	buf.WriteString(separator)
+	buf.WriteString(name)
+	buf.WriteString(`="`)
+	_, _ = labelValueEscaper.WriteString(buf, value)
+	buf.WriteByte('"')
+}
+
+// compareCounters orders the counters by their field IDs, then by their names, types and help.
+func compareCounters(a, b Counter) int {
+	return cmp.Or(
+		cmp.Compare(a.FieldID, b.FieldID),
+		cmp.Compare(a.FieldName, b.FieldName),
+		cmp.Compare(a.PromType, b.PromType),
+		cmp.Compare(a.Help, b.Help),
+	)
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEncoderTestMetrics returns the metrics of gpus entities for the counters, e.g. of a big NVSwitch system.
+func newEncoderTestMetrics(counters, gpus int) MetricsByCounter {
+	metrics := make(MetricsByCounter, counters)
+	for c := 0; c < counters; c++ {
+		counter := Counter{
+			FieldID:   dcgm.Short(100 + c),
+			FieldName: fmt.Sprintf("DCGM_FI_DEV_TEST_%d", c),
+			PromType:  "gauge",
+			Help:      "Test counter.",
+		}
+		for g := 0; g < gpus; g++ {
+			m := Metric{
+				Counter:      counter,
+				Value:        fmt.Sprint(g * c),
+				GPU:          fmt.Sprint(g),
+				UUID:         "UUID",
+				GPUUUID:      fmt.Sprintf("GPU-%08d-0000-0000-0000-000000000000", g),
+				GPUDevice:    fmt.Sprintf("nvidia%d", g),
+				GPUModelName: "NVIDIA H100 80GB HBM3",
+				GPUPCIBusID:  fmt.Sprintf("00000000:%02X:00.0", g),
+				Hostname:     "node-1",
+				Labels:       map[string]string{"DCGM_FI_DRIVER_VERSION": "550.54", "err_code": "0"},
+				Attributes:   map[string]string{"pod": fmt.Sprintf("pod-%d", g), "namespace": "default", "container": "main"},
+			}
+			if g%2 == 1 {
+				m.MigProfile = "1g.10gb"
+				m.GPUInstanceID = "7"
+			}
+			metrics[counter] = append(metrics[counter], m)
+		}
+	}
+	return metrics
+}
+
+// encodeMetrics renders the metrics of the entity type, as the pipeline does.
+func encodeMetrics(entityType dcgm.Field_Entity_Group, metrics MetricsByCounter) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	newMetricsEncoder(entityType).Encode(buf, metrics)
+	return buf.String()
+}
+
+func TestMetricsEncoder_Encode(t *testing.T) {
+	metrics := newEncoderTestMetrics(1, 2)
+
+	const header = "# HELP DCGM_FI_DEV_TEST_0 Test counter.\n# TYPE DCGM_FI_DEV_TEST_0 gauge\n"
+	const labels = `Hostname="node-1",DCGM_FI_DRIVER_VERSION="550.54",err_code="0"`
+
+	tests := []struct {
+		entityType dcgm.Field_Entity_Group
+		want       string
+	}{
+		{dcgm.FE_GPU, header +
+			`DCGM_FI_DEV_TEST_0{gpu="0",UUID="GPU-00000000-0000-0000-0000-000000000000",pci_bus_id="00000000:00:00.0",device="nvidia0",modelName="NVIDIA H100 80GB HBM3",` + labels + `,container="main",namespace="default",pod="pod-0"} 0` + "\n" +
+			`DCGM_FI_DEV_TEST_0{gpu="1",UUID="GPU-00000001-0000-0000-0000-000000000000",pci_bus_id="00000000:01:00.0",device="nvidia1",modelName="NVIDIA H100 80GB HBM3",GPU_I_PROFILE="1g.10gb",GPU_I_ID="7",` + labels + `,container="main",namespace="default",pod="pod-1"} 0` + "\n"},
+		{dcgm.FE_SWITCH, header +
+			`DCGM_FI_DEV_TEST_0{nvswitch="0",` + labels + "} 0\n" +
+			`DCGM_FI_DEV_TEST_0{nvswitch="1",` + labels + "} 0\n"},
+		{dcgm.FE_LINK, header +
+			`DCGM_FI_DEV_TEST_0{nvlink="0",nvswitch="nvidia0",` + labels + "} 0\n" +
+			`DCGM_FI_DEV_TEST_0{nvlink="1",nvswitch="nvidia1",` + labels + "} 0\n"},
+		{dcgm.FE_CPU, header +
+			`DCGM_FI_DEV_TEST_0{cpu="0",` + labels + "} 0\n" +
+			`DCGM_FI_DEV_TEST_0{cpu="1",` + labels + "} 0\n"},
+		{dcgm.FE_CPU_CORE, header +
+			`DCGM_FI_DEV_TEST_0{cpucore="0",cpu="nvidia0",` + labels + "} 0\n" +
+			`DCGM_FI_DEV_TEST_0{cpucore="1",cpu="nvidia1",` + labels + "} 0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entityType.String(), func(t *testing.T) {
+			e := newMetricsEncoder(tt.entityType)
+			// The second run uses the cached labels
+			for i := 0; i < 2; i++ {
+				buf := getBuffer()
+				e.Encode(buf, metrics)
+				assert.Equal(t, tt.want, buf.String())
+				putBuffer(buf)
+			}
+		})
+	}
+}
+
+func TestMetricsEncoder_EscapesLabelValues(t *testing.T) {
+	const annotation = "{\"team\": \"ml\",\n \"path\": \"C:\\jobs\"}"
+
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge", Help: "Temperature\nin \\C."}
+	metrics := MetricsByCounter{counter: {{
+		Counter:      counter,
+		Value:        "42",
+		GPU:          "0",
+		UUID:         "UUID",
+		GPUUUID:      "GPU-0",
+		GPUModelName: `NVIDIA "H100"`,
+		Labels:       map[string]string{"example_com_config": annotation},
+		Attributes:   map[string]string{"pod": "pod-0"},
+	}}}
+
+	out := encodeMetrics(dcgm.FE_GPU, metrics)
+	assert.Contains(t, out, `example_com_config="{\"team\": \"ml\",\n \"path\": \"C:\\jobs\"}"`)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	require.NoError(t, err, out)
+	family := families["DCGM_FI_DEV_GPU_TEMP"]
+	require.NotNil(t, family)
+	assert.Equal(t, counter.Help, family.GetHelp())
+	require.Len(t, family.GetMetric(), 1)
+
+	values := map[string]string{}
+	for _, label := range family.GetMetric()[0].GetLabel() {
+		values[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, annotation, values["example_com_config"])
+	assert.Equal(t, `NVIDIA "H100"`, values["modelName"])
+}
+
+func TestMetricsEncoder_EvictsLabels(t *testing.T) {
+	e := newMetricsEncoder(dcgm.FE_GPU)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	e.Encode(buf, newEncoderTestMetrics(1, 4))
+	assert.Len(t, e.labels, 4)
+
+	e.Encode(buf, newEncoderTestMetrics(1, 2))
+	assert.Len(t, e.labels, 2)
+}
+
+func TestMetricFingerprint(t *testing.T) {
+	metrics := newEncoderTestMetrics(1, 2)
+	for _, metricVals := range metrics {
+		a, b := metricVals[0], metricVals[1]
+		assert.Equal(t, a.metricFingerprint(), a.metricFingerprint())
+		assert.NotEqual(t, a.metricFingerprint(), b.metricFingerprint())
+
+		c := a
+		c.Attributes = map[string]string{"pod": "other"}
+		assert.NotEqual(t, a.metricFingerprint(), c.metricFingerprint())
+	}
+}
+
+func BenchmarkMetricsEncoder(b *testing.B) {
+	metrics := newEncoderTestMetrics(50, 64)
+	e := newMetricsEncoder(dcgm.FE_GPU)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := getBuffer()
+		e.Encode(buf, metrics)
+		_ = buf.String()
+		putBuffer(buf)
+	}
+}
+
+func BenchmarkMetricFingerprint(b *testing.B) {
+	metrics := newEncoderTestMetrics(1, 1)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, metricVals := range metrics {
+			_ = metricVals[0].metricFingerprint()
+		}
+	}
+}
+
+func BenchmarkCopyMetric(b *testing.B) {
+	var metric Metric
+	for _, metricVals := range newEncoderTestMetrics(1, 1) {
+		metric = metricVals[0]
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = copyMetric(metric)
+	}
+}
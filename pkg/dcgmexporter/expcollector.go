@@ -20,47 +20,28 @@ import (
 	"fmt"
 	"io"
 	"maps"
-	"sync"
 	"sync/atomic"
-	"text/template"
 	"time"
 
 	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
 	"github.com/sirupsen/logrus"
 )
 
-var expMetricsFormat = `
-
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{gpu="{{ $metric.GPU }}",{{ $metric.UUID }}="{{ $metric.GPUUUID }}",pci_bus_id="{{ $metric.GPUPCIBusID }}",device="{{ $metric.GPUDevice }}",modelName="{{ $metric.GPUModelName }}"{{if $metric.MigProfile}},GPU_I_PROFILE="{{ $metric.MigProfile }}",GPU_I_ID="{{ $metric.GPUInstanceID }}"{{end}}{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-{{- range $k, $v := $metric.Attributes -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
 // Collector interface
 type Collector interface {
 	GetMetrics() (MetricsByCounter, error)
 	Cleanup()
 }
 
-var getExpMetricTemplate = sync.OnceValue(func() *template.Template {
-	return template.Must(template.New("expMetrics").Parse(expMetricsFormat))
-})
-
+// encodeExpMetrics renders the metrics of the Registry collectors with the labels of the GPUs.
 func encodeExpMetrics(w io.Writer, metrics MetricsByCounter) error {
-	tmpl := getExpMetricTemplate()
-	return tmpl.Execute(w, metrics)
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	newMetricsEncoder(dcgm.FE_GPU).Encode(buf, metrics)
+
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 var expCollectorFieldGroupIdx atomic.Uint32
@@ -207,6 +188,11 @@ func (c *expCollector) getLabelsFromCounters(mi MonitoringInfo, labels map[strin
 	return nil
 }
 
+// Name returns the name of the counter of the collector.
+func (c *expCollector) Name() string {
+	return c.counter.FieldName
+}
+
 func (c *expCollector) Cleanup() {
 	for _, cleanup := range c.cleanups {
 		cleanup()
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// ExporterConfig is the content of the exporter configuration file.
+type ExporterConfig struct {
+	DerivedMetrics       []DerivedMetric   `yaml:"derivedMetrics"`
+	StaticLabels         map[string]string `yaml:"staticLabels"`
+	MetricRelabelConfigs []RelabelConfig   `yaml:"metricRelabelConfigs"`
+	// SeriesLimits are the maximum numbers of series of the named counters.
+	SeriesLimits map[string]int `yaml:"seriesLimits"`
+	// DeviceIDRules map the device IDs of the device plugins to the GPUs.
+	DeviceIDRules []DeviceIDRule `yaml:"deviceIDRules"`
+}
+
+// DerivedMetric defines a counter computed from other counters of the same entity.
+type DerivedMetric struct {
+	Name string `yaml:"name"`
+	// Type is the Prometheus type, "gauge" or "counter". Defaults to "gauge".
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+	// Expr is an arithmetic expression over the names of the counters, e.g.
+	// "DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)".
+	Expr string `yaml:"expr"`
+}
+
+// LoadExporterConfig reads and validates the exporter configuration file.
+func LoadExporterConfig(path string) (*ExporterConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the exporter configuration file '%s'; err: %w", path, err)
+	}
+	defer file.Close()
+
+	return parseExporterConfig(file)
+}
+
+func parseExporterConfig(r io.Reader) (*ExporterConfig, error) {
+	var config ExporterConfig
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	err := decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse the exporter configuration; err: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i := range config.DerivedMetrics {
+		dm := &config.DerivedMetrics[i]
+
+		if !metricNameRegex.MatchString(dm.Name) {
+			return nil, fmt.Errorf("invalid name of the derived metric: '%s'", dm.Name)
+		}
+		if names[dm.Name] {
+			return nil, fmt.Errorf("duplicate derived metric: '%s'", dm.Name)
+		}
+		names[dm.Name] = true
+
+		switch dm.Type {
+		case "":
+			dm.Type = "gauge"
+		case "gauge", "counter":
+		default:
+			return nil, fmt.Errorf("invalid type of the derived metric '%s': '%s'", dm.Name, dm.Type)
+		}
+
+		expr, err := parseExpression(dm.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derived metric '%s'; err: %w", dm.Name, err)
+		}
+		if len(expr.counters(nil)) == 0 {
+			return nil, fmt.Errorf("derived metric '%s' does not refer to any counter", dm.Name)
+		}
+	}
+
+	for name := range config.StaticLabels {
+		if !labelNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid name of the static label: '%s'", name)
+		}
+	}
+
+	for name, limit := range config.SeriesLimits {
+		if limit < 0 {
+			return nil, fmt.Errorf("invalid series limit of '%s': %d", name, limit)
+		}
+	}
+
+	for i, rc := range config.MetricRelabelConfigs {
+		_, err := newRelabelRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel config #%d; err: %w", i, err)
+		}
+	}
+
+	if _, err := newDeviceIDRules(config.DeviceIDRules); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
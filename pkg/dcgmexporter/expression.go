@@ -0,0 +1,313 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// expression is an arithmetic expression over counters, e.g.
+// "DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)".
+// It supports numbers, counter names, the +, -, *, / operators, parentheses,
+// and the min(a, b), max(a, b) and abs(a) functions.
+type expression interface {
+	// eval evaluates the expression. It fails, when the value of a counter is missing.
+	eval(values map[string]float64) (float64, error)
+	// counters appends the names of the counters used by the expression.
+	counters(names []string) []string
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(map[string]float64) (float64, error) { return float64(e), nil }
+
+func (e numberExpr) counters(names []string) []string { return names }
+
+type counterExpr string
+
+func (e counterExpr) eval(values map[string]float64) (float64, error) {
+	v, exists := values[string(e)]
+	if !exists {
+		return 0, fmt.Errorf("no value of '%s'", string(e))
+	}
+	return v, nil
+}
+
+func (e counterExpr) counters(names []string) []string { return append(names, string(e)) }
+
+type unaryExpr struct {
+	operand expression
+}
+
+func (e unaryExpr) eval(values map[string]float64) (float64, error) {
+	v, err := e.operand.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	return -v, nil
+}
+
+func (e unaryExpr) counters(names []string) []string { return e.operand.counters(names) }
+
+type binaryExpr struct {
+	op          byte
+	left, right expression
+}
+
+func (e binaryExpr) eval(values map[string]float64) (float64, error) {
+	l, err := e.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	r, err := e.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	default:
+		return l / r, nil
+	}
+}
+
+func (e binaryExpr) counters(names []string) []string {
+	return e.right.counters(e.left.counters(names))
+}
+
+type callExpr struct {
+	function string
+	args     []expression
+}
+
+var expressionFunctions = map[string]struct {
+	arity int
+	fn    func(args []float64) float64
+}{
+	"min": {2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+	"max": {2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+	"abs": {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+}
+
+func (e callExpr) eval(values map[string]float64) (float64, error) {
+	args := make([]float64, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(values)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return expressionFunctions[e.function].fn(args), nil
+}
+
+func (e callExpr) counters(names []string) []string {
+	for _, arg := range e.args {
+		names = arg.counters(names)
+	}
+	return names
+}
+
+// expressionParser is a recursive descent parser of the grammar:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = unary { ("*" | "/") unary }
+//	unary  = "-" unary | primary
+//	primary = number | name | name "(" expr { "," expr } ")" | "(" expr ")"
+type expressionParser struct {
+	input string
+	pos   int
+}
+
+func parseExpression(input string) (expression, error) {
+	p := &expressionParser{input: input}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s'; err: %w", input, err)
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("invalid expression '%s': unexpected '%c' at position %d", input, p.input[p.pos], p.pos)
+	}
+
+	return e, nil
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes the next character, if it is c.
+func (p *expressionParser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) parseExpr() (expression, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op byte
+		switch {
+		case p.accept('+'):
+			op = '+'
+		case p.accept('-'):
+			op = '-'
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *expressionParser) parseTerm() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op byte
+		switch {
+		case p.accept('*'):
+			op = '*'
+		case p.accept('/'):
+			op = '/'
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.accept('-') {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (expression, error) {
+	if p.accept('(') {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+		return e, nil
+	}
+
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	start := p.pos
+	c := rune(p.input[p.pos])
+
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", p.input[start:p.pos], start)
+		}
+		return numberExpr(v), nil
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.input) && isNameChar(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		name := p.input[start:p.pos]
+		if !p.accept('(') {
+			return counterExpr(name), nil
+		}
+		return p.parseCall(name)
+	default:
+		return nil, fmt.Errorf("unexpected '%c' at position %d", c, p.pos)
+	}
+}
+
+func (p *expressionParser) parseCall(name string) (expression, error) {
+	function, exists := expressionFunctions[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+
+	var args []expression
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.accept(')') {
+			break
+		}
+		if !p.accept(',') {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+	}
+
+	if len(args) != function.arity {
+		return nil, fmt.Errorf("function '%s' expects %d arguments, got %d", name, function.arity, len(args))
+	}
+
+	return callExpr{function: name, args: args}, nil
+}
+
+func isNameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
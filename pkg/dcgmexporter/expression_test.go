@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{
+		"DCGM_FI_DEV_FB_USED":    30,
+		"DCGM_FI_DEV_FB_FREE":    10,
+		"DCGM_FI_PROF_SM_ACTIVE": 0.5,
+	}
+
+	tests := []struct {
+		expr     string
+		want     float64
+		counters []string
+	}{
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 - 4 - 3", want: 3},
+		{expr: "-2 * -.5", want: 1},
+		{
+			expr:     "DCGM_FI_DEV_FB_USED / (DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE)",
+			want:     0.75,
+			counters: []string{"DCGM_FI_DEV_FB_USED", "DCGM_FI_DEV_FB_USED", "DCGM_FI_DEV_FB_FREE"},
+		},
+		{
+			expr:     "max(DCGM_FI_DEV_FB_FREE, 20) * abs(-DCGM_FI_PROF_SM_ACTIVE)",
+			want:     10,
+			counters: []string{"DCGM_FI_DEV_FB_FREE", "DCGM_FI_PROF_SM_ACTIVE"},
+		},
+		{expr: "min(1, 2)", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpression(tt.expr)
+			require.NoError(t, err)
+			got, err := e.eval(values)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.counters, e.counters(nil))
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"1..2",
+		"sqrt(4)",
+		"min(1)",
+		"max(1, 2",
+		"DCGM_FI_DEV_FB_USED % 2",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseExpression(expr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpressionMissingCounter(t *testing.T) {
+	e, err := parseExpression("DCGM_FI_DEV_FB_USED + 1")
+	require.NoError(t, err)
+	_, err = e.eval(map[string]float64{})
+	assert.ErrorContains(t, err, "DCGM_FI_DEV_FB_USED")
+}
@@ -65,6 +65,16 @@ func NewDCGMCollector(
 
 	collector.Cleanups = cleanups
 
+	sampleHistory, cleanups, err := newSampleHistory(c, collector.DeviceFields,
+		fieldEntityGroupTypeSystemInfo.SystemInfo, config)
+	if err != nil {
+		collector.Cleanup()
+		return nil, func() {}, fmt.Errorf("failed to watch sample history; err: %w", err)
+	}
+
+	collector.sampleHistory = sampleHistory
+	collector.Cleanups = append(collector.Cleanups, cleanups...)
+
 	return collector, func() { collector.Cleanup() }, nil
 }
 
@@ -106,6 +116,15 @@ func (c *DCGMCollector) GetMetrics() (MetricsByCounter, error) {
 
 	metrics := make(MetricsByCounter)
 
+	var samples map[entityField][]float64
+	if c.sampleHistory != nil {
+		var err error
+		samples, err = c.sampleHistory.read()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, mi := range monitoringInfo {
 		var vals []dcgm.FieldValue_v1
 		var err error
@@ -124,13 +143,19 @@ func (c *DCGMCollector) GetMetrics() (MetricsByCounter, error) {
 			return nil, err
 		}
 
+		// The statistics series need the metrics of the entity alone
+		entityMetrics := metrics
+		if samples != nil {
+			entityMetrics = make(MetricsByCounter)
+		}
+
 		// InstanceInfo will be nil for GPUs
 		if c.SysInfo.InfoType == dcgm.FE_SWITCH || c.SysInfo.InfoType == dcgm.FE_LINK {
-			ToSwitchMetric(metrics, vals, c.Counters, mi, c.UseOldNamespace, c.Hostname)
+			ToSwitchMetric(entityMetrics, vals, c.Counters, mi, c.UseOldNamespace, c.Hostname)
 		} else if c.SysInfo.InfoType == dcgm.FE_CPU || c.SysInfo.InfoType == dcgm.FE_CPU_CORE {
-			ToCPUMetric(metrics, vals, c.Counters, mi, c.UseOldNamespace, c.Hostname)
+			ToCPUMetric(entityMetrics, vals, c.Counters, mi, c.UseOldNamespace, c.Hostname)
 		} else {
-			ToMetric(metrics,
+			ToMetric(entityMetrics,
 				vals,
 				c.Counters,
 				mi.DeviceInfo,
@@ -139,6 +164,14 @@ func (c *DCGMCollector) GetMetrics() (MetricsByCounter, error) {
 				c.Hostname,
 				c.ReplaceBlanksInModelName)
 		}
+
+		if samples != nil {
+			attachSamples(entityMetrics, mi.Entity, samples)
+			appendSampleStats(entityMetrics, mi.Entity, samples)
+			for counter, metricVals := range entityMetrics {
+				metrics[counter] = append(metrics[counter], metricVals...)
+			}
+		}
 	}
 
 	return metrics, nil
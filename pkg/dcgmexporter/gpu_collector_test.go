@@ -27,27 +27,29 @@ import (
 )
 
 var sampleCounters = []Counter{
-	{dcgm.DCGM_FI_DEV_GPU_TEMP, "DCGM_FI_DEV_GPU_TEMP", "gauge", "Temperature Help info"},
-	{dcgm.DCGM_FI_DEV_TOTAL_ENERGY_CONSUMPTION, "DCGM_FI_DEV_TOTAL_ENERGY_CONSUMPTION", "gauge", "Energy help info"},
-	{dcgm.DCGM_FI_DEV_POWER_USAGE, "DCGM_FI_DEV_POWER_USAGE", "gauge", "Power help info"},
-	{dcgm.DCGM_FI_DRIVER_VERSION, "DCGM_FI_DRIVER_VERSION", "label", "Driver version"},
+	{dcgm.DCGM_FI_DEV_GPU_TEMP, "DCGM_FI_DEV_GPU_TEMP", "gauge", "Temperature Help info", nil},
+	{dcgm.DCGM_FI_DEV_TOTAL_ENERGY_CONSUMPTION, "DCGM_FI_DEV_TOTAL_ENERGY_CONSUMPTION", "gauge", "Energy help info", nil},
+	{dcgm.DCGM_FI_DEV_POWER_USAGE, "DCGM_FI_DEV_POWER_USAGE", "gauge", "Power help info", nil},
+	{dcgm.DCGM_FI_DRIVER_VERSION, "DCGM_FI_DRIVER_VERSION", "label", "Driver version", nil},
 	/* test that switch and link metrics are filtered out automatically when devices are not detected */
 	{
 		dcgm.DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT,
 		"DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT",
 		"gauge",
 		"switch temperature",
+		nil,
 	},
 	{
 		dcgm.DCGM_FI_DEV_NVSWITCH_LINK_FLIT_ERRORS,
 		"DCGM_FI_DEV_NVSWITCH_LINK_FLIT_ERRORS",
 		"gauge",
 		"per-link flit errors",
+		nil,
 	},
 	/* test that vgpu metrics are not filtered out */
-	{dcgm.DCGM_FI_DEV_VGPU_LICENSE_STATUS, "DCGM_FI_DEV_VGPU_LICENSE_STATUS", "gauge", "vgpu license status"},
+	{dcgm.DCGM_FI_DEV_VGPU_LICENSE_STATUS, "DCGM_FI_DEV_VGPU_LICENSE_STATUS", "gauge", "vgpu license status", nil},
 	/* test that cpu and cpu core metrics are filtered out automatically when devices are not detected */
-	{dcgm.DCGM_FI_DEV_CPU_UTIL_TOTAL, "DCGM_FI_DEV_CPU_UTIL_TOTAL", "gauge", "Total CPU utilization"},
+	{dcgm.DCGM_FI_DEV_CPU_UTIL_TOTAL, "DCGM_FI_DEV_CPU_UTIL_TOTAL", "gauge", "Total CPU utilization", nil},
 }
 
 var expectedMetrics = map[string]bool{
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// histogramSeries is the cumulative state of a single histogram or summary series.
+type histogramSeries struct {
+	bucketCounts []uint64 // Observations per bucket, the last one is the +Inf bucket
+	sum          float64
+	count        uint64
+	generation   uint64
+}
+
+// histogramAggregator turns the metrics of histogram and summary counters into the series of the
+// Prometheus exposition format. Every sample since the previous collection is observed, or the latest
+// value when the sample history is not available.
+type histogramAggregator struct {
+	series     map[string]*histogramSeries
+	generation uint64
+}
+
+func newHistogramAggregator() *histogramAggregator {
+	return &histogramAggregator{
+		series: make(map[string]*histogramSeries),
+	}
+}
+
+// Aggregate returns a copy of the metrics, where the metrics of histogram counters are replaced
+// with the _bucket, _sum and _count series, and the metrics of summary counters with the quantile,
+// _sum and _count series. The state of series, that are not present in the metrics, is evicted.
+func (h *histogramAggregator) Aggregate(metrics MetricsByCounter) MetricsByCounter {
+	h.generation++
+
+	aggregated := make(MetricsByCounter, len(metrics))
+
+	for counter, metricVals := range metrics {
+		if counter.PromType != "histogram" && counter.PromType != "summary" {
+			aggregated[counter] = metricVals
+			continue
+		}
+
+		newMetrics := make([]Metric, 0, len(metricVals)*(len(counter.buckets())+3))
+		for _, metricVal := range metricVals {
+			observations := metricVal.Samples
+			if observations == nil {
+				val, err := strconv.ParseFloat(metricVal.Value, 64)
+				if err != nil {
+					logrus.Warnf("Failed to parse metric value %s as float64: %v", metricVal.Value, err)
+					continue
+				}
+				observations = []float64{val}
+			}
+
+			s := h.observe(counter, metricVal.metricFingerprint(), observations)
+
+			if counter.PromType == "histogram" {
+				newMetrics = appendHistogramSeries(newMetrics, counter, metricVal, s)
+			} else {
+				newMetrics = appendSummarySeries(newMetrics, counter, metricVal, s, observations)
+			}
+		}
+
+		aggregated[counter] = newMetrics
+	}
+
+	for fp, s := range h.series {
+		if s.generation != h.generation {
+			delete(h.series, fp)
+		}
+	}
+
+	return aggregated
+}
+
+func (h *histogramAggregator) observe(counter Counter, fp string, observations []float64) *histogramSeries {
+	buckets := counter.buckets()
+
+	s, exists := h.series[fp]
+	if !exists || len(s.bucketCounts) != len(buckets)+1 {
+		s = &histogramSeries{bucketCounts: make([]uint64, len(buckets)+1)}
+		h.series[fp] = s
+	}
+
+	for _, v := range observations {
+		if math.IsNaN(v) {
+			continue
+		}
+		// The first bucket, which upper bound is greater or equal to the value
+		s.bucketCounts[sort.SearchFloat64s(buckets, v)]++
+		s.sum += v
+		s.count++
+	}
+	s.generation = h.generation
+
+	return s
+}
+
+func appendHistogramSeries(metrics []Metric, counter Counter, metricVal Metric, s *histogramSeries) []Metric {
+	var cumulative uint64
+	for i, upperBound := range counter.buckets() {
+		cumulative += s.bucketCounts[i]
+		metrics = append(metrics, newHistogramMetric(metricVal, histogramBucketSuffix, float64(cumulative),
+			bucketLabel, strconv.FormatFloat(upperBound, 'f', -1, 64)))
+	}
+	metrics = append(metrics, newHistogramMetric(metricVal, histogramBucketSuffix, float64(s.count),
+		bucketLabel, "+Inf"))
+
+	return append(metrics,
+		newHistogramMetric(metricVal, histogramSumSuffix, s.sum, "", ""),
+		newHistogramMetric(metricVal, histogramCountSuffix, float64(s.count), "", ""))
+}
+
+// appendSummarySeries appends the summary series. The quantiles are computed from the observations
+// of the current collection only, while _sum and _count are cumulative.
+func appendSummarySeries(metrics []Metric, counter Counter, metricVal Metric, s *histogramSeries, observations []float64) []Metric {
+	quantiles := counter.quantiles()
+
+	values := make([]float64, len(quantiles))
+	if len(observations) > 0 {
+		values = computeSampleStats(observations, quantiles).Quantiles
+	} else {
+		for i := range values {
+			values[i] = math.NaN()
+		}
+	}
+
+	for i, q := range quantiles {
+		metrics = append(metrics, newHistogramMetric(metricVal, "", values[i],
+			quantileLabel, strconv.FormatFloat(q, 'f', -1, 64)))
+	}
+
+	return append(metrics,
+		newHistogramMetric(metricVal, histogramSumSuffix, s.sum, "", ""),
+		newHistogramMetric(metricVal, histogramCountSuffix, float64(s.count), "", ""))
+}
+
+// newHistogramMetric copies the metric with the suffix and the value, and with an extra label, if set.
+func newHistogramMetric(metricVal Metric, suffix string, value float64, label, labelValue string) Metric {
+	m := metricVal
+	m.NameSuffix = suffix
+	m.Value = strconv.FormatFloat(value, 'f', -1, 64)
+	m.Samples = nil
+
+	if label != "" {
+		m.Labels = maps.Clone(m.Labels)
+		if m.Labels == nil {
+			m.Labels = map[string]string{}
+		}
+		m.Labels[label] = labelValue
+	}
+
+	return m
+}
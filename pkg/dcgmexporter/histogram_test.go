@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramAggregator_Histogram(t *testing.T) {
+	counter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_GPU_UTIL,
+		FieldName: "DCGM_FI_DEV_GPU_UTIL",
+		PromType:  "histogram",
+		Help:      "GPU utilization (in %).",
+		Options:   &CounterOptions{Buckets: []float64{50, 100}},
+	}
+	newMetrics := func(samples []float64) MetricsByCounter {
+		return MetricsByCounter{
+			counter: {
+				{
+					Counter:      counter,
+					Value:        "0",
+					GPU:          "0",
+					UUID:         "UUID",
+					GPUUUID:      "GPU-00000000-0000-0000-0000-000000000000",
+					GPUDevice:    "nvidia0",
+					GPUModelName: "NVIDIA A100-SXM4-80GB",
+					Samples:      samples,
+					Labels:       map[string]string{},
+					Attributes:   map[string]string{},
+				},
+			},
+		}
+	}
+
+	h := newHistogramAggregator()
+	h.Aggregate(newMetrics([]float64{10, 60}))
+	aggregated := h.Aggregate(newMetrics([]float64{100, 20}))
+
+	values := map[string]string{}
+	for _, m := range aggregated[counter] {
+		values[m.NameSuffix+"{"+m.Labels[bucketLabel]+"}"] = m.Value
+	}
+	assert.Equal(t, map[string]string{
+		"_bucket{50}":   "2",
+		"_bucket{100}":  "4",
+		"_bucket{+Inf}": "4",
+		"_sum{}":        "190",
+		"_count{}":      "4",
+	}, values)
+
+	out := encodeMetrics(dcgm.FE_GPU, aggregated)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	require.NoError(t, err, out)
+	require.Contains(t, families, "DCGM_FI_DEV_GPU_UTIL")
+	family := families["DCGM_FI_DEV_GPU_UTIL"]
+	require.Len(t, family.GetMetric(), 1)
+	assert.Equal(t, uint64(4), family.GetMetric()[0].GetHistogram().GetSampleCount())
+	assert.Equal(t, float64(190), family.GetMetric()[0].GetHistogram().GetSampleSum())
+}
+
+func TestHistogramAggregator_Summary(t *testing.T) {
+	counter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_POWER_USAGE,
+		FieldName: "DCGM_FI_DEV_POWER_USAGE",
+		PromType:  "summary",
+		Options:   &CounterOptions{Quantiles: []float64{0.5}},
+	}
+	metrics := MetricsByCounter{
+		counter: {{Counter: counter, GPU: "0", Value: "200"}},
+	}
+
+	h := newHistogramAggregator()
+	h.Aggregate(metrics)
+	aggregated := h.Aggregate(metrics)
+
+	require.Len(t, aggregated[counter], 3)
+	assert.Equal(t, "0.5", aggregated[counter][0].Labels[quantileLabel])
+	assert.Equal(t, "200", aggregated[counter][0].Value)
+	assert.Equal(t, "_sum", aggregated[counter][1].NameSuffix)
+	assert.Equal(t, "400", aggregated[counter][1].Value)
+	assert.Equal(t, "_count", aggregated[counter][2].NameSuffix)
+	assert.Equal(t, "2", aggregated[counter][2].Value)
+
+	h.Aggregate(MetricsByCounter{})
+	assert.Empty(t, h.series, "the state of vanished series is expected to be evicted")
+}
@@ -18,6 +18,7 @@ package dcgmexporter
 
 import (
 	"bufio"
+	"maps"
 	sysOS "os"
 	"path"
 	"strconv"
@@ -69,6 +70,7 @@ func (p *hpcMapper) Process(metrics MetricsByCounter, sysInfo SystemInfo) error
 	}
 
 	logrus.Debugf("GPU to job mapping: %+v", gpuToJobMap)
+	p.Config.Assignments.setJobs(gpuToJobMap)
 
 	for counter := range metrics {
 		var modifiedMetrics []Metric
@@ -76,11 +78,7 @@ func (p *hpcMapper) Process(metrics MetricsByCounter, sysInfo SystemInfo) error
 			jobs, exists := gpuToJobMap[metric.GPU]
 			if exists {
 				for _, job := range jobs {
-					modifiedMetric, err := deepCopy(metric)
-					if err != nil {
-						logrus.WithError(err).Errorf("Can not create deepCopy for the value: %v", metric)
-						continue
-					}
+					modifiedMetric := copyMetric(metric)
 					modifiedMetric.Attributes[hpcJobAttribute] = job
 					modifiedMetrics = append(modifiedMetrics, modifiedMetric)
 				}
@@ -156,3 +154,13 @@ func getGPUFiles(dirPath string) ([]string, error) {
 
 	return mappingFiles, nil
 }
+
+// copyMetric returns a copy of the metric, that does not share the labels and the attributes with it.
+// Only the maps need to be copied, the other fields of the Metric are values.
+func copyMetric(m Metric) Metric {
+	m.Labels = maps.Clone(m.Labels)
+	attributes := make(map[string]string, len(m.Attributes)+1)
+	maps.Copy(attributes, m.Attributes)
+	m.Attributes = attributes
+	return m
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+)
+
+// Inventory describes the devices discovered by the exporter, which of them are watched
+// under the device options, and the counters collected for every entity type.
+// It is served as JSON on /api/v1/devices.
+type Inventory struct {
+	GPUs     []GPUInventory                `json:"gpus"`
+	Switches []SwitchInventory             `json:"switches"`
+	CPUs     []CPUInventory                `json:"cpus"`
+	Counters map[string][]CounterInventory `json:"counters"`
+}
+
+type GPUInventory struct {
+	GPU           uint                   `json:"gpu"`
+	UUID          string                 `json:"uuid"`
+	PCIBusID      string                 `json:"pciBusId"`
+	Model         string                 `json:"model"`
+	Brand         string                 `json:"brand"`
+	DriverVersion string                 `json:"driverVersion"`
+	MemoryMiB     uint                   `json:"memoryMiB"`
+	MigEnabled    bool                   `json:"migEnabled"`
+	Watched       bool                   `json:"watched"`
+	GPUInstances  []GPUInstanceInventory `json:"gpuInstances,omitempty"`
+}
+
+type GPUInstanceInventory struct {
+	EntityID         uint                       `json:"entityId"`
+	InstanceID       uint                       `json:"instanceId"`
+	Profile          string                     `json:"profile"`
+	Watched          bool                       `json:"watched"`
+	ComputeInstances []ComputeInstanceInventory `json:"computeInstances,omitempty"`
+}
+
+type ComputeInstanceInventory struct {
+	EntityID          uint   `json:"entityId"`
+	ComputeInstanceID uint   `json:"computeInstanceId"`
+	Profile           string `json:"profile"`
+}
+
+type SwitchInventory struct {
+	EntityID uint            `json:"entityId"`
+	Watched  bool            `json:"watched"`
+	Links    []LinkInventory `json:"links,omitempty"`
+}
+
+type LinkInventory struct {
+	Index   uint   `json:"index"`
+	State   string `json:"state"`
+	Watched bool   `json:"watched"`
+}
+
+type CPUInventory struct {
+	EntityID uint               `json:"entityId"`
+	Watched  bool               `json:"watched"`
+	Cores    []CPUCoreInventory `json:"cores,omitempty"`
+}
+
+type CPUCoreInventory struct {
+	ID      uint `json:"id"`
+	Watched bool `json:"watched"`
+}
+
+type CounterInventory struct {
+	Name     string `json:"name"`
+	FieldID  uint16 `json:"fieldId"`
+	PromType string `json:"type"`
+}
+
+// inventoryEntityTypes are the keys of the counters in the inventory.
+var inventoryEntityTypes = map[dcgm.Field_Entity_Group]string{
+	dcgm.FE_GPU:      "gpu",
+	dcgm.FE_SWITCH:   "switch",
+	dcgm.FE_LINK:     "link",
+	dcgm.FE_CPU:      "cpu",
+	dcgm.FE_CPU_CORE: "cpu_core",
+}
+
+func linkStateName(state dcgm.Link_State) string {
+	switch state {
+	case dcgm.LS_UP:
+		return "up"
+	case dcgm.LS_DOWN:
+		return "down"
+	case dcgm.LS_NOT_SUPPORTED:
+		return "not_supported"
+	default:
+		return "disabled"
+	}
+}
+
+// NewInventory returns the inventory of the system info loaded for the entity types.
+// The system info does not change, until the exporter is restarted, and so neither does the inventory.
+func NewInventory(f *FieldEntityGroupTypeSystemInfo) *Inventory {
+	inventory := &Inventory{
+		GPUs:     []GPUInventory{},
+		Switches: []SwitchInventory{},
+		CPUs:     []CPUInventory{},
+		Counters: map[string][]CounterInventory{},
+	}
+
+	for entityType, name := range inventoryEntityTypes {
+		item, exists := f.Get(entityType)
+		if !exists {
+			continue
+		}
+		inventory.Counters[name] = counterInventory(f.counters, item.DeviceFields)
+	}
+
+	if item, exists := f.Get(dcgm.FE_GPU); exists {
+		inventory.GPUs = gpuInventory(item.SystemInfo)
+	}
+
+	// The switches and the CPUs are loaded with their links and cores, whichever of the types has counters
+	for _, entityType := range []dcgm.Field_Entity_Group{dcgm.FE_LINK, dcgm.FE_SWITCH} {
+		if item, exists := f.Get(entityType); exists {
+			inventory.Switches = switchInventory(item.SystemInfo)
+		}
+	}
+	for _, entityType := range []dcgm.Field_Entity_Group{dcgm.FE_CPU_CORE, dcgm.FE_CPU} {
+		if item, exists := f.Get(entityType); exists {
+			inventory.CPUs = cpuInventory(item.SystemInfo)
+		}
+	}
+
+	return inventory
+}
+
+func counterInventory(counters []Counter, deviceFields []dcgm.Short) []CounterInventory {
+	inventory := []CounterInventory{}
+	for _, counter := range counters {
+		for _, fieldID := range deviceFields {
+			if counter.FieldID == fieldID {
+				inventory = append(inventory, CounterInventory{
+					Name:     counter.FieldName,
+					FieldID:  uint16(counter.FieldID),
+					PromType: counter.PromType,
+				})
+				break
+			}
+		}
+	}
+	return inventory
+}
+
+func gpuInventory(sysInfo SystemInfo) []GPUInventory {
+	watched := map[dcgm.GroupEntityPair]bool{}
+	for _, mi := range GetMonitoredEntities(sysInfo) {
+		watched[mi.Entity] = true
+	}
+
+	inventory := make([]GPUInventory, 0, sysInfo.GPUCount)
+	for i := uint(0); i < sysInfo.GPUCount; i++ {
+		gpu := sysInfo.GPUs[i]
+		gi := GPUInventory{
+			GPU:           gpu.DeviceInfo.GPU,
+			UUID:          gpu.DeviceInfo.UUID,
+			PCIBusID:      gpu.DeviceInfo.PCI.BusID,
+			Model:         gpu.DeviceInfo.Identifiers.Model,
+			Brand:         gpu.DeviceInfo.Identifiers.Brand,
+			DriverVersion: gpu.DeviceInfo.Identifiers.DriverVersion,
+			MemoryMiB:     gpu.DeviceInfo.PCI.FBTotal,
+			MigEnabled:    gpu.MigEnabled,
+			Watched:       watched[dcgm.GroupEntityPair{EntityGroupId: dcgm.FE_GPU, EntityId: gpu.DeviceInfo.GPU}],
+		}
+
+		for _, instance := range gpu.GPUInstances {
+			ii := GPUInstanceInventory{
+				EntityID:   instance.EntityId,
+				InstanceID: instance.Info.NvmlInstanceId,
+				Profile:    instance.ProfileName,
+				Watched:    watched[dcgm.GroupEntityPair{EntityGroupId: dcgm.FE_GPU_I, EntityId: instance.EntityId}],
+			}
+			for _, ci := range instance.ComputeInstances {
+				ii.ComputeInstances = append(ii.ComputeInstances, ComputeInstanceInventory{
+					EntityID:          ci.EntityId,
+					ComputeInstanceID: ci.InstanceInfo.NvmlComputeInstanceId,
+					Profile:           ci.ProfileName,
+				})
+			}
+			gi.GPUInstances = append(gi.GPUInstances, ii)
+		}
+
+		inventory = append(inventory, gi)
+	}
+
+	return inventory
+}
+
+func switchInventory(sysInfo SystemInfo) []SwitchInventory {
+	inventory := make([]SwitchInventory, 0, len(sysInfo.Switches))
+	for _, sw := range sysInfo.Switches {
+		si := SwitchInventory{
+			EntityID: sw.EntityId,
+			Watched:  IsSwitchWatched(sw.EntityId, sysInfo),
+		}
+		for _, link := range sw.NvLinks {
+			si.Links = append(si.Links, LinkInventory{
+				Index: link.Index,
+				State: linkStateName(link.State),
+				// As in AddAllLinks, only the links, that are up, are watched
+				Watched: link.State == dcgm.LS_UP && si.Watched && IsLinkWatched(link.Index, sw.EntityId, sysInfo),
+			})
+		}
+		inventory = append(inventory, si)
+	}
+	return inventory
+}
+
+func cpuInventory(sysInfo SystemInfo) []CPUInventory {
+	inventory := make([]CPUInventory, 0, len(sysInfo.CPUs))
+	for _, cpu := range sysInfo.CPUs {
+		ci := CPUInventory{
+			EntityID: cpu.EntityId,
+			Watched:  IsCPUWatched(cpu.EntityId, sysInfo),
+		}
+		for _, core := range cpu.Cores {
+			ci.Cores = append(ci.Cores, CPUCoreInventory{
+				ID:      core,
+				Watched: ci.Watched && IsCoreWatched(core, cpu.EntityId, sysInfo),
+			})
+		}
+		inventory = append(inventory, ci)
+	}
+	return inventory
+}
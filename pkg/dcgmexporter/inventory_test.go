@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInventoryTestSystemInfo() *FieldEntityGroupTypeSystemInfo {
+	counters := []Counter{
+		{FieldID: dcgm.DCGM_FI_DEV_GPU_TEMP, FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"},
+		{FieldID: dcgm.DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT, FieldName: "DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT", PromType: "gauge"},
+		{FieldID: dcgm.DCGM_FI_DEV_CPU_UTIL_TOTAL, FieldName: "DCGM_FI_DEV_CPU_UTIL_TOTAL", PromType: "gauge"},
+	}
+
+	gpuSysInfo := SystemInfo{
+		GPUCount: 2,
+		gOpt:     DeviceOptions{MajorRange: []int{0}, MinorRange: []int{11}},
+		InfoType: dcgm.FE_GPU,
+	}
+	gpuSysInfo.GPUs[0] = GPUInfo{
+		DeviceInfo: dcgm.Device{
+			GPU:         0,
+			UUID:        "GPU-00000000-0000-0000-0000-000000000000",
+			PCI:         dcgm.PCIInfo{BusID: "00000000:07:00.0", FBTotal: 81559},
+			Identifiers: dcgm.DeviceIdentifiers{Model: "NVIDIA H100 80GB HBM3", Brand: "NVIDIA", DriverVersion: "550.54.15"},
+		},
+	}
+	gpuSysInfo.GPUs[1] = GPUInfo{
+		DeviceInfo: dcgm.Device{GPU: 1, UUID: "GPU-11111111-1111-1111-1111-111111111111"},
+		MigEnabled: true,
+		GPUInstances: []GPUInstanceInfo{
+			{
+				EntityId:    11,
+				ProfileName: "3g.40gb",
+				Info:        dcgm.MigEntityInfo{NvmlInstanceId: 1},
+				ComputeInstances: []ComputeInstanceInfo{
+					{EntityId: 21, ProfileName: "3c.3g.40gb", InstanceInfo: dcgm.MigEntityInfo{NvmlComputeInstanceId: 0}},
+				},
+			},
+			{EntityId: 12, ProfileName: "3g.40gb", Info: dcgm.MigEntityInfo{NvmlInstanceId: 2}},
+		},
+	}
+
+	switchSysInfo := SystemInfo{
+		sOpt:     DeviceOptions{MajorRange: []int{-1}, MinorRange: []int{-1}},
+		InfoType: dcgm.FE_SWITCH,
+		Switches: []SwitchInfo{
+			{EntityId: 0, NvLinks: []dcgm.NvLinkStatus{{Index: 0, State: dcgm.LS_UP}, {Index: 1, State: dcgm.LS_DOWN}}},
+		},
+	}
+
+	cpuSysInfo := SystemInfo{
+		cOpt:     DeviceOptions{MajorRange: []int{0}, MinorRange: []int{-1}},
+		InfoType: dcgm.FE_CPU,
+		CPUs:     []CPUInfo{{EntityId: 0, Cores: []uint{0, 1}}, {EntityId: 1, Cores: []uint{2}}},
+	}
+
+	f := NewEntityGroupTypeSystemInfo(counters, &Config{})
+	f.items[dcgm.FE_GPU] = FieldEntityGroupTypeSystemInfoItem{SystemInfo: gpuSysInfo, DeviceFields: []dcgm.Short{dcgm.DCGM_FI_DEV_GPU_TEMP}}
+	f.items[dcgm.FE_SWITCH] = FieldEntityGroupTypeSystemInfoItem{SystemInfo: switchSysInfo, DeviceFields: []dcgm.Short{dcgm.DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT}}
+	f.items[dcgm.FE_CPU] = FieldEntityGroupTypeSystemInfoItem{SystemInfo: cpuSysInfo, DeviceFields: []dcgm.Short{dcgm.DCGM_FI_DEV_CPU_UTIL_TOTAL}}
+
+	return f
+}
+
+func TestNewInventory(t *testing.T) {
+	inventory := NewInventory(newInventoryTestSystemInfo())
+
+	require.Len(t, inventory.GPUs, 2)
+	assert.Equal(t, GPUInventory{
+		GPU:           0,
+		UUID:          "GPU-00000000-0000-0000-0000-000000000000",
+		PCIBusID:      "00000000:07:00.0",
+		Model:         "NVIDIA H100 80GB HBM3",
+		Brand:         "NVIDIA",
+		DriverVersion: "550.54.15",
+		MemoryMiB:     81559,
+		Watched:       true,
+	}, inventory.GPUs[0])
+
+	gpu := inventory.GPUs[1]
+	assert.True(t, gpu.MigEnabled)
+	assert.False(t, gpu.Watched)
+	assert.Equal(t, []GPUInstanceInventory{
+		{
+			EntityID:         11,
+			InstanceID:       1,
+			Profile:          "3g.40gb",
+			Watched:          true,
+			ComputeInstances: []ComputeInstanceInventory{{EntityID: 21, ComputeInstanceID: 0, Profile: "3c.3g.40gb"}},
+		},
+		{EntityID: 12, InstanceID: 2, Profile: "3g.40gb", Watched: false},
+	}, gpu.GPUInstances)
+
+	assert.Equal(t, []SwitchInventory{{
+		EntityID: 0,
+		Watched:  true,
+		Links: []LinkInventory{
+			{Index: 0, State: "up", Watched: true},
+			{Index: 1, State: "down", Watched: false},
+		},
+	}}, inventory.Switches)
+
+	assert.Equal(t, []CPUInventory{
+		{EntityID: 0, Watched: true, Cores: []CPUCoreInventory{{ID: 0, Watched: true}, {ID: 1, Watched: true}}},
+		{EntityID: 1, Watched: false, Cores: []CPUCoreInventory{{ID: 2, Watched: false}}},
+	}, inventory.CPUs)
+
+	assert.Equal(t, map[string][]CounterInventory{
+		"gpu":    {{Name: "DCGM_FI_DEV_GPU_TEMP", FieldID: uint16(dcgm.DCGM_FI_DEV_GPU_TEMP), PromType: "gauge"}},
+		"switch": {{Name: "DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT", FieldID: uint16(dcgm.DCGM_FI_DEV_NVSWITCH_TEMPERATURE_CURRENT), PromType: "gauge"}},
+		"cpu":    {{Name: "DCGM_FI_DEV_CPU_UTIL_TOTAL", FieldID: uint16(dcgm.DCGM_FI_DEV_CPU_UTIL_TOTAL), PromType: "gauge"}},
+	}, inventory.Counters)
+}
+
+func TestMetricsServer_Devices(t *testing.T) {
+	s := &MetricsServer{registry: NewRegistry()}
+
+	rec := httptest.NewRecorder()
+	s.Devices(rec, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	s.SetInventory(NewInventory(newInventoryTestSystemInfo()))
+
+	rec = httptest.NewRecorder()
+	s.Devices(rec, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got["gpus"], 2)
+	assert.Equal(t, "GPU-00000000-0000-0000-0000-000000000000", got["gpus"].([]interface{})[0].(map[string]interface{})["uuid"])
+	assert.Contains(t, got["counters"], "gpu")
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kubeauth authenticates the HTTP requests with the bearer tokens of Kubernetes, as kube-rbac-proxy does.
+// The token is validated with the TokenReview API, and the user is authorized with a SubjectAccessReview
+// of a non-resource URL, e.g. a ClusterRole granting "get" on the "/metrics" nonResourceURL.
+package kubeauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	DefaultVerb     = "get"
+	DefaultCacheTTL = 2 * time.Minute
+
+	// deniedCacheTTL caps how long a denial is cached, so that the granted permissions take effect quickly.
+	deniedCacheTTL = 10 * time.Second
+	// maxCacheEntries bounds the cache, which is keyed by the tokens the clients send.
+	maxCacheEntries = 4096
+	// reviewTimeout is the timeout of a TokenReview or SubjectAccessReview call.
+	reviewTimeout = 10 * time.Second
+)
+
+type Config struct {
+	// Verb is the verb of the SubjectAccessReview, e.g. "get".
+	Verb string
+	// Path is the non-resource URL of the SubjectAccessReview. If empty, it is the path of the request.
+	Path string
+	// Audiences are the audiences the tokens must be issued for. If empty, the API server audiences are accepted.
+	Audiences []string
+	// CacheTTL is how long the decisions are cached; 0 disables the cache. The denials are cached for at most 10 seconds.
+	CacheTTL time.Duration
+}
+
+// decision is the cached result of the reviews of a token.
+type decision struct {
+	status    int // http.StatusOK, http.StatusUnauthorized or http.StatusForbidden
+	user      string
+	expiresAt time.Time
+}
+
+type Authenticator struct {
+	client kubernetes.Interface
+	config Config
+	now    func() time.Time
+
+	mtx   sync.Mutex
+	cache map[[sha256.Size]byte]decision
+}
+
+func New(client kubernetes.Interface, config Config) *Authenticator {
+	if config.Verb == "" {
+		config.Verb = DefaultVerb
+	}
+
+	return &Authenticator{
+		client: client,
+		config: config,
+		now:    time.Now,
+		cache:  make(map[[sha256.Size]byte]decision),
+	}
+}
+
+// NewInCluster returns the Authenticator using the service account of the pod.
+func NewInCluster(config Config) (*Authenticator, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get in-cluster config; err: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client; err: %w", err)
+	}
+
+	return New(client, config), nil
+}
+
+// Middleware serves the requests with an authorized bearer token, and rejects the rest
+// with 401 Unauthorized or 403 Forbidden.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dcgm-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		status, err := a.authorize(r.Context(), token, a.path(r))
+		if err != nil {
+			logrus.WithError(err).Error("Failed to authorize request.")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		switch status {
+		case http.StatusOK:
+			next.ServeHTTP(w, r)
+		case http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dcgm-exporter"`)
+			http.Error(w, http.StatusText(status), status)
+		default:
+			http.Error(w, http.StatusText(status), status)
+		}
+	})
+}
+
+func (a *Authenticator) path(r *http.Request) string {
+	if a.config.Path != "" {
+		return a.config.Path
+	}
+	return r.URL.Path
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authorize returns the HTTP status of the token for the path. The errors of the API are not cached.
+func (a *Authenticator) authorize(ctx context.Context, token, path string) (int, error) {
+	// The tokens are not kept in memory, only their hashes
+	key := sha256.Sum256([]byte(path + "\x00" + token))
+
+	if d, ok := a.cached(key); ok {
+		return d.status, nil
+	}
+
+	d, err := a.review(ctx, token, path)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := a.config.CacheTTL
+	if ttl <= 0 {
+		return d.status, nil
+	}
+	if d.status != http.StatusOK {
+		ttl = min(ttl, deniedCacheTTL)
+		logrus.Debugf("Denied request to '%s' of user '%s' with status %d", path, d.user, d.status)
+	}
+	d.expiresAt = a.now().Add(ttl)
+	a.store(key, d)
+
+	return d.status, nil
+}
+
+func (a *Authenticator) review(ctx context.Context, token, path string) (decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, reviewTimeout)
+	defer cancel()
+
+	tr, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.config.Audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return decision{}, fmt.Errorf("failed to create TokenReview; err: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		return decision{status: http.StatusUnauthorized}, nil
+	}
+
+	user := tr.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	sar, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: a.config.Verb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return decision{}, fmt.Errorf("failed to create SubjectAccessReview; err: %w", err)
+	}
+	if !sar.Status.Allowed {
+		return decision{status: http.StatusForbidden, user: user.Username}, nil
+	}
+
+	return decision{status: http.StatusOK, user: user.Username}, nil
+}
+
+func (a *Authenticator) cached(key [sha256.Size]byte) (decision, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	d, exists := a.cache[key]
+	if !exists || !a.now().Before(d.expiresAt) {
+		return decision{}, false
+	}
+	return d, true
+}
+
+func (a *Authenticator) store(key [sha256.Size]byte, d decision) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.cache) >= maxCacheEntries {
+		now := a.now()
+		for k, v := range a.cache {
+			if !now.Before(v.expiresAt) {
+				delete(a.cache, k)
+			}
+		}
+		// Too many distinct tokens within the TTL; start over rather than grow without bounds
+		if len(a.cache) >= maxCacheEntries {
+			clear(a.cache)
+		}
+	}
+
+	a.cache[key] = d
+}
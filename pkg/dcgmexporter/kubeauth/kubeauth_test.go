@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubeauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	prometheusToken = "prometheus-token"
+	otherToken      = "other-token"
+)
+
+// newFakeClient returns a clientset, that authenticates the test tokens and allows only Prometheus
+// to get /metrics. It counts the reviews.
+func newFakeClient(t *testing.T, reviews *int) *fake.Clientset {
+	t.Helper()
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*reviews++
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch tr.Spec.Token {
+		case prometheusToken:
+			tr.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User: authenticationv1.UserInfo{
+					Username: "system:serviceaccount:monitoring:prometheus",
+					Groups:   []string{"system:serviceaccounts"},
+					Extra:    map[string]authenticationv1.ExtraValue{"pod": {"prometheus-0"}},
+				},
+			}
+		case otherToken:
+			tr.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:serviceaccount:default:default"},
+			}
+		}
+		return true, tr, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		require.NotNil(t, sar.Spec.NonResourceAttributes)
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:monitoring:prometheus" &&
+			sar.Spec.NonResourceAttributes.Path == "/metrics" &&
+			sar.Spec.NonResourceAttributes.Verb == "get" &&
+			sar.Spec.Extra["pod"][0] == "prometheus-0"
+		return true, sar, nil
+	})
+
+	return client
+}
+
+func request(handler http.Handler, path, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("OK"))
+})
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	var reviews int
+	handler := New(newFakeClient(t, &reviews), Config{}).Middleware(okHandler)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"authorized", "/metrics", "Bearer " + prometheusToken, http.StatusOK},
+		{"scheme is case insensitive", "/metrics", "bearer " + prometheusToken, http.StatusOK},
+		{"no token", "/metrics", "", http.StatusUnauthorized},
+		{"basic auth", "/metrics", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"invalid token", "/metrics", "Bearer invalid", http.StatusUnauthorized},
+		{"not allowed", "/metrics", "Bearer " + otherToken, http.StatusForbidden},
+		{"other path", "/other", "Bearer " + prometheusToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(handler, tt.path, tt.authorization)
+			assert.Equal(t, tt.want, rec.Code)
+			if tt.want == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+			if tt.want == http.StatusOK {
+				assert.Equal(t, "OK", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthenticator_ConfiguredPathAndVerb(t *testing.T) {
+	var reviews int
+	client := newFakeClient(t, &reviews)
+
+	// The SubjectAccessReview of the fake client allows only "get" on "/metrics"
+	handler := New(client, Config{Path: "/metrics"}).Middleware(okHandler)
+	assert.Equal(t, http.StatusOK, request(handler, "/", "Bearer "+prometheusToken).Code)
+
+	handler = New(client, Config{Path: "/metrics", Verb: "list"}).Middleware(okHandler)
+	assert.Equal(t, http.StatusForbidden, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+}
+
+func TestAuthenticator_Cache(t *testing.T) {
+	var reviews int
+	a := New(newFakeClient(t, &reviews), Config{CacheTTL: time.Minute})
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	handler := a.Middleware(okHandler)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+		assert.Equal(t, http.StatusForbidden, request(handler, "/metrics", "Bearer "+otherToken).Code)
+	}
+	assert.Equal(t, 2, reviews)
+
+	// The denials expire first
+	now = now.Add(deniedCacheTTL)
+	assert.Equal(t, http.StatusOK, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+	assert.Equal(t, http.StatusForbidden, request(handler, "/metrics", "Bearer "+otherToken).Code)
+	assert.Equal(t, 3, reviews)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+	assert.Equal(t, 4, reviews)
+
+	// The tokens are not kept
+	for key := range a.cache {
+		assert.NotContains(t, string(key[:]), prometheusToken)
+	}
+}
+
+func TestAuthenticator_APIError(t *testing.T) {
+	var reviews int
+	client := newFakeClient(t, &reviews)
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		return true, nil, errors.New("connection refused")
+	})
+	handler := New(client, Config{}).Middleware(okHandler)
+
+	assert.Equal(t, http.StatusInternalServerError, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+	assert.Equal(t, http.StatusInternalServerError, request(handler, "/metrics", "Bearer "+prometheusToken).Code)
+	// The errors are not cached
+	assert.Equal(t, 2, reviews)
+}
@@ -19,16 +19,22 @@ package dcgmexporter
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1alpha1"
+	"google.golang.org/grpc/status"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+	podresourcesv1alpha1 "k8s.io/kubelet/pkg/apis/podresources/v1alpha1"
 
 	"github.com/NVIDIA/dcgm-exporter/internal/pkg/nvmlprovider"
 )
@@ -39,13 +45,36 @@ var (
 	gkeMigDeviceIDRegex            = regexp.MustCompile(`^nvidia([0-9]+)/gi([0-9]+)$`)
 	gkeVirtualGPUDeviceIDSeparator = "/vgpu"
 	nvmlGetMIGDeviceInfoByIDHook   = nvmlprovider.GetMIGDeviceInfoByID
+
+	nvmlGetGPUInstanceByPlacementHook = nvmlprovider.GetGPUInstanceByPlacement
+
+	// pciBusIDRegex matches the PCI bus IDs, with a domain of 4 or 8 digits, e.g. 0000:3b:00.0
+	pciBusIDRegex = regexp.MustCompile(`^(?:[0-9a-fA-F]{4}|[0-9a-fA-F]{8}):[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+	// gpuIndexRegex matches the indexes, and the device names, of the GPUs, e.g. 0 and nvidia0
+	gpuIndexRegex = regexp.MustCompile(`^(?:nvidia)?([0-9]+)$`)
+	// draDeviceUUIDRegex matches the UUIDs of the GPUs, and MIG devices, in the names of the CDI devices
+	draDeviceUUIDRegex = regexp.MustCompile(`(?:GPU|MIG)-[0-9a-fA-F]{8}(?:-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}`)
+	// draClaimDeviceRegex matches the names of the CDI devices of the claims of the NVIDIA DRA driver,
+	// <claim UID>-<device>, with the canonical name of the allocated device, e.g. gpu-0
+	draClaimDeviceRegex = regexp.MustCompile(`^[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12}-(.+)$`)
+	// draGPUNameRegex matches the canonical names of the GPUs, gpu-<index>
+	draGPUNameRegex = regexp.MustCompile(`^gpu-([0-9]+)$`)
+	// draMIGNameRegex matches the canonical names of the MIG devices, gpu-<minor>-mig-<profile ID>-<start>-<size>,
+	// with the placement of their GPU instances
+	draMIGNameRegex = regexp.MustCompile(`^gpu-([0-9]+)-mig-([0-9]+)-([0-9]+)-([0-9]+)$`)
 )
 
 func NewPodMapper(c *Config) (*PodMapper, error) {
 	logrus.Infof("Kubernetes metrics collection enabled!")
 
+	deviceIDRules, err := newDeviceIDRules(c.DeviceIDRules)
+	if err != nil {
+		return nil, err
+	}
+
 	return &PodMapper{
-		Config: c,
+		Config:        c,
+		deviceIDRules: deviceIDRules,
 	}, nil
 }
 
@@ -54,70 +83,162 @@ func (p *PodMapper) Name() string {
 }
 
 func (p *PodMapper) Process(metrics MetricsByCounter, sysInfo SystemInfo) error {
-	socketPath := p.Config.PodResourcesKubeletSocket
-	_, err := os.Stat(socketPath)
-	if os.IsNotExist(err) {
-		logrus.Info("No Kubelet socket, ignoring")
-		return nil
-	}
-
-	// TODO: This needs to be moved out of the critical path.
-	c, cleanup, err := connectToServer(socketPath)
-	if err != nil {
+	pods, err := p.podResources()
+	if err != nil || pods == nil {
 		return err
 	}
-	defer cleanup()
 
-	pods, err := p.listPods(c)
-	if err != nil {
-		return err
-	}
+	deviceToPods := p.toDeviceToPod(pods, sysInfo)
 
-	deviceToPod := p.toDeviceToPod(pods, sysInfo)
-
-	logrus.Debugf("Device to pod mapping: %+v", deviceToPod)
+	logrus.Debugf("Device to pod mapping: %+v", deviceToPods)
+	p.Config.Assignments.setPods(deviceToPods)
 
 	// Note: for loop are copies the value, if we want to change the value
 	// and not the copy, we need to use the indexes
 	for counter := range metrics {
-		for j, val := range metrics[counter] {
-			deviceID, err := val.getIDOfType(p.Config.KubernetesGPUIdType)
+		// The metrics of the shared devices are appended for the other pods, which must not be mapped again
+		n := len(metrics[counter])
+		for j := 0; j < n; j++ {
+			deviceID, err := metrics[counter][j].getIDOfType(p.Config.KubernetesGPUIdType)
 			if err != nil {
 				return err
 			}
 
-			podInfo, exists := deviceToPod[deviceID]
-			if exists {
-				if !p.Config.UseOldNamespace {
-					metrics[counter][j].Attributes[podAttribute] = podInfo.Name
-					metrics[counter][j].Attributes[namespaceAttribute] = podInfo.Namespace
-					metrics[counter][j].Attributes[containerAttribute] = podInfo.Container
-				} else {
-					metrics[counter][j].Attributes[oldPodAttribute] = podInfo.Name
-					metrics[counter][j].Attributes[oldNamespaceAttribute] = podInfo.Namespace
-					metrics[counter][j].Attributes[oldContainerAttribute] = podInfo.Container
-				}
+			podInfos := deviceToPods[deviceID]
+			if len(podInfos) == 0 {
+				continue
 			}
 
-			podMeta := p.Config.PodWatcher.GetObjectMeta(podInfo.Namespace, podInfo.Name)
-			if podMeta != nil {
-				for _, lbl := range p.Config.OtelInheritPodLabels {
-					if val, exists := podMeta.Labels[lbl]; exists {
-						metrics[counter][j].Labels[lbl] = val
-					}
-				}
-				for _, ann := range p.Config.OtelInheritPodAnnotations {
-					if val, exists := podMeta.Annotations[ann]; exists {
-						metrics[counter][j].Attributes[ann] = val
-					}
-				}
+			if len(podInfos) > 1 && slices.Contains(p.Config.GPUSplitCounters, counter.FieldName) {
+				metrics[counter][j].Value = splitValue(metrics[counter][j].Value, len(podInfos))
+			}
+
+			for _, podInfo := range podInfos[1:] {
+				shared := metrics[counter][j]
+				shared.Labels = maps.Clone(shared.Labels)
+				shared.Attributes = maps.Clone(shared.Attributes)
+				p.addPodInfo(&shared, podInfo)
+				metrics[counter] = append(metrics[counter], shared)
 			}
+			p.addPodInfo(&metrics[counter][j], podInfos[0])
 		}
 	}
 
 	return nil
 }
 
+// addPodInfo labels the metric with the pod, its workload, and with the labels and annotations of the pod,
+// and the labels of its namespace, to inherit.
+func (p *PodMapper) addPodInfo(metric *Metric, podInfo PodInfo) {
+	if !p.Config.UseOldNamespace {
+		metric.Attributes[podAttribute] = podInfo.Name
+		metric.Attributes[namespaceAttribute] = podInfo.Namespace
+		metric.Attributes[containerAttribute] = podInfo.Container
+	} else {
+		metric.Attributes[oldPodAttribute] = podInfo.Name
+		metric.Attributes[oldNamespaceAttribute] = podInfo.Namespace
+		metric.Attributes[oldContainerAttribute] = podInfo.Container
+	}
+	if podInfo.Replica != "" {
+		metric.Attributes[sharingStrategyAttribute] = podInfo.SharingStrategy
+		metric.Attributes[replicaAttribute] = podInfo.Replica
+	}
+	if podInfo.ClaimName != "" {
+		metric.Attributes[claimAttribute] = podInfo.ClaimName
+		metric.Attributes[claimNamespaceAttribute] = podInfo.ClaimNamespace
+	}
+
+	// The pods are mapped without their metadata, when they cannot be watched
+	if p.Config.PodWatcher == nil {
+		return
+	}
+
+	if p.Config.WorkloadLabels {
+		if kind, name, ok := p.Config.PodWatcher.Workload(podInfo.Namespace, podInfo.Name); ok {
+			metric.Attributes[workloadKindAttribute] = kind
+			metric.Attributes[workloadNameAttribute] = name
+		}
+	}
+
+	// The values are free text, e.g. JSON annotations, they are escaped by the encoder of the metrics
+	namespaceLabels := p.Config.PodWatcher.GetNamespaceLabels(podInfo.Namespace)
+	for _, lbl := range p.Config.NamespaceLabels {
+		if val, exists := namespaceLabels[lbl]; exists {
+			metric.Labels[sanitizeLabelName(lbl)] = val
+		}
+	}
+
+	podMeta := p.Config.PodWatcher.GetObjectMeta(podInfo.Namespace, podInfo.Name)
+	if podMeta != nil {
+		for _, lbl := range p.Config.PodLabels {
+			if val, exists := podMeta.Labels[lbl]; exists {
+				metric.Labels[sanitizeLabelName(lbl)] = val
+			}
+		}
+		for _, ann := range p.Config.PodAnnotations {
+			if val, exists := podMeta.Annotations[ann]; exists {
+				metric.Attributes[sanitizeLabelName(ann)] = val
+			}
+		}
+	}
+}
+
+// sanitizeLabelName turns the name of a Kubernetes label or annotation, e.g. "app.kubernetes.io/name",
+// into a Prometheus label name, e.g. "app_kubernetes_io_name".
+func sanitizeLabelName(name string) string {
+	if labelNameRegex.MatchString(name) {
+		return name
+	}
+
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized == "" || ('0' <= sanitized[0] && sanitized[0] <= '9') {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// splitValue returns the share of one of the pods sharing a device. Values, that are not numbers, are not split.
+func splitValue(value string, pods int) string {
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatFloat(val/float64(pods), 'f', -1, 64)
+}
+
+// podResources returns the pod resources from the PodResourcesCache, or lists them, when there is no cache.
+// It returns nil, when there are none to map the metrics with.
+func (p *PodMapper) podResources() (*podresourcesapi.ListPodResourcesResponse, error) {
+	if cache := p.Config.PodResources; cache != nil {
+		pods, ok := cache.get()
+		if !ok {
+			logrus.Debug("No pod resources refreshed within the TTL, ignoring")
+			return nil, nil
+		}
+		return pods, nil
+	}
+
+	socketPath := p.Config.PodResourcesKubeletSocket
+	_, err := os.Stat(socketPath)
+	if os.IsNotExist(err) {
+		logrus.Info("No Kubelet socket, ignoring")
+		return nil, nil
+	}
+
+	c, cleanup, err := connectToServer(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	return listPodResources(context.Background(), c)
+}
+
 func connectToServer(socket string) (*grpc.ClientConn, func(), error) {
 	ctx, cancel := context.WithTimeout(context.Background(), connectionTimeout)
 	defer cancel()
@@ -138,13 +259,21 @@ func connectToServer(socket string) (*grpc.ClientConn, func(), error) {
 	return conn, func() { conn.Close() }, nil
 }
 
-func (p *PodMapper) listPods(conn *grpc.ClientConn) (*podresourcesapi.ListPodResourcesResponse, error) {
-	client := podresourcesapi.NewPodResourcesListerClient(conn)
-
-	ctx, cancel := context.WithTimeout(context.Background(), connectionTimeout)
+// listPodResources lists the pod resources with the v1 API of kubelet,
+// or with the v1alpha1 API, when kubelet does not serve the v1 API.
+func listPodResources(ctx context.Context, conn *grpc.ClientConn) (*podresourcesapi.ListPodResourcesResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectionTimeout)
 	defer cancel()
 
-	resp, err := client.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	resp, err := podresourcesapi.NewPodResourcesListerClient(conn).List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		logrus.Debug("No v1 pod resources API, falling back to v1alpha1")
+
+		var alphaResp *podresourcesv1alpha1.ListPodResourcesResponse
+		alphaResp, err = podresourcesv1alpha1.NewPodResourcesListerClient(conn).List(ctx,
+			&podresourcesv1alpha1.ListPodResourcesRequest{})
+		resp = fromV1alpha1(alphaResp)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failure getting pod resources; err: %w", err)
 	}
@@ -152,60 +281,301 @@ func (p *PodMapper) listPods(conn *grpc.ClientConn) (*podresourcesapi.ListPodRes
 	return resp, nil
 }
 
+// fromV1alpha1 converts the v1alpha1 pod resources into the v1 ones, which are a superset of them.
+func fromV1alpha1(alphaResp *podresourcesv1alpha1.ListPodResourcesResponse) *podresourcesapi.ListPodResourcesResponse {
+	if alphaResp == nil {
+		return nil
+	}
+
+	resp := &podresourcesapi.ListPodResourcesResponse{}
+	for _, pod := range alphaResp.GetPodResources() {
+		podResources := &podresourcesapi.PodResources{
+			Name:      pod.GetName(),
+			Namespace: pod.GetNamespace(),
+		}
+		for _, container := range pod.GetContainers() {
+			containerResources := &podresourcesapi.ContainerResources{Name: container.GetName()}
+			for _, device := range container.GetDevices() {
+				containerResources.Devices = append(containerResources.Devices, &podresourcesapi.ContainerDevices{
+					ResourceName: device.GetResourceName(),
+					DeviceIds:    device.GetDeviceIds(),
+				})
+			}
+			podResources.Containers = append(podResources.Containers, containerResources)
+		}
+		resp.PodResources = append(resp.PodResources, podResources)
+	}
+
+	return resp
+}
+
+// getAllocatableResources returns the devices kubelet can allocate to pods. It requires the v1 API of kubelet
+// and, before Kubernetes 1.28, the KubeletPodResourcesGetAllocatable feature gate.
+func getAllocatableResources(
+	ctx context.Context, conn *grpc.ClientConn,
+) (*podresourcesapi.AllocatableResourcesResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectionTimeout)
+	defer cancel()
+
+	resp, err := podresourcesapi.NewPodResourcesListerClient(conn).GetAllocatableResources(ctx,
+		&podresourcesapi.AllocatableResourcesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failure getting allocatable resources; err: %w", err)
+	}
+
+	return resp, nil
+}
+
+// isNvidiaResource reports whether the resource is a GPU, or a MIG device, of the NVIDIA device plugin.
+func (p *PodMapper) isNvidiaResource(resourceName string) bool {
+	if resourceName == nvidiaResourceName || slices.Contains(p.Config.NvidiaResourceNames, resourceName) {
+		return true
+	}
+	// Mig resources appear differently than GPU resources
+	return strings.HasPrefix(resourceName, nvidiaMigResourcePrefix)
+}
+
+// deviceKeys returns the IDs of the metrics, which belong to the device ID of the device plugin.
+func deviceKeys(deviceID string, sysInfo SystemInfo) []string {
+	// The replicas of a shared device are mapped like the device itself
+	if device, _, found := strings.Cut(deviceID, "::"); found {
+		return append(deviceKeys(device, sysInfo), deviceID)
+	}
+
+	var keys []string
+
+	if strings.HasPrefix(deviceID, MIG_UUID_PREFIX) {
+		migDevice, err := nvmlGetMIGDeviceInfoByIDHook(deviceID)
+		if err == nil {
+			giIdentifier := GetGPUInstanceIdentifier(sysInfo, migDevice.ParentUUID,
+				uint(migDevice.GPUInstanceID))
+			keys = append(keys, giIdentifier)
+		}
+		gpuUUID := deviceID[len(MIG_UUID_PREFIX):]
+		keys = append(keys, gpuUUID)
+	} else if gkeMigDeviceIDMatches := gkeMigDeviceIDRegex.FindStringSubmatch(deviceID); gkeMigDeviceIDMatches != nil {
+		var gpuIndex string
+		var gpuInstanceID string
+		for groupIdx, group := range gkeMigDeviceIDMatches {
+			switch groupIdx {
+			case 1:
+				gpuIndex = group
+			case 2:
+				gpuInstanceID = group
+			}
+		}
+		giIdentifier := fmt.Sprintf("%s-%s", gpuIndex, gpuInstanceID)
+		keys = append(keys, giIdentifier)
+	} else if strings.Contains(deviceID, gkeVirtualGPUDeviceIDSeparator) {
+		keys = append(keys, strings.Split(deviceID, gkeVirtualGPUDeviceIDSeparator)[0])
+	} else if pciBusIDRegex.MatchString(deviceID) && normalizePCIBusID(deviceID) != deviceID {
+		keys = append(keys, normalizePCIBusID(deviceID))
+	}
+	// Default mapping between deviceID and pod information
+	return append(keys, deviceID)
+}
+
+// deviceKeys returns the IDs of the metrics, which belong to the device ID, according to the first matching
+// DeviceIDRule, or else to the built-in rules. The rules match the replicas of the shared devices without their
+// "::N" suffix. With the auto GPU ID type, the GPUs are keyed by UUID as well, whatever the format of the device
+// ID, see detectGPUUUID.
+func (p *PodMapper) deviceKeys(deviceID string, sysInfo SystemInfo) []string {
+	var keys []string
+	matched := false
+	device, _, _ := strings.Cut(deviceID, "::")
+	for _, rule := range p.deviceIDRules {
+		if keys, matched = rule.keys(device, sysInfo); matched {
+			if !slices.Contains(keys, deviceID) {
+				keys = append(keys, deviceID)
+			}
+			break
+		}
+	}
+	if !matched {
+		keys = deviceKeys(deviceID, sysInfo)
+	}
+
+	if p.Config.KubernetesGPUIdType != AutoGPUID {
+		return keys
+	}
+
+	// The keys of the replicas, and of the virtual GPUs of GKE, include the IDs of their GPUs
+	for _, key := range keys {
+		if uuid := detectGPUUUID(key, sysInfo); uuid != "" && !slices.Contains(keys, uuid) {
+			keys = append(keys, uuid)
+		}
+	}
+	return keys
+}
+
+// detectGPUUUID returns the UUID of the GPU of the device ID, detecting whether the device ID is a UUID,
+// a PCI bus ID, an index or a device name. It is empty for the other device IDs, e.g. of MIG devices.
+func detectGPUUUID(deviceID string, sysInfo SystemInfo) string {
+	if strings.HasPrefix(deviceID, "GPU-") {
+		return deviceID
+	}
+
+	pciBusID := pciBusIDRegex.MatchString(deviceID)
+	index := gpuIndexRegex.FindStringSubmatch(deviceID)
+	if !pciBusID && index == nil {
+		return ""
+	}
+
+	for i := uint(0); i < sysInfo.GPUCount; i++ {
+		device := sysInfo.GPUs[i].DeviceInfo
+		if pciBusID && normalizePCIBusID(device.PCI.BusID) == normalizePCIBusID(deviceID) {
+			return device.UUID
+		}
+		if index != nil && fmt.Sprint(device.GPU) == index[1] {
+			return device.UUID
+		}
+	}
+	return ""
+}
+
+// normalizePCIBusID returns the PCI bus ID in lower case with a domain of 4 digits, e.g. 0000:3b:00.0
+// for the 00000000:3B:00.0 of DCGM, as the device plugins use it.
+func normalizePCIBusID(busID string) string {
+	busID = strings.ToLower(busID)
+	if domain, rest, found := strings.Cut(busID, ":"); found && len(domain) == 8 && strings.HasPrefix(domain, "0000") {
+		return domain[4:] + ":" + rest
+	}
+	return busID
+}
+
+// draDeviceKeys returns the IDs of the metrics of the GPU, or MIG device, of a CDI device of a resource claim.
+// The NVIDIA DRA driver names the CDI devices of the claims with the canonical names of the devices,
+// e.g. "k8s.gpu.nvidia.com/claim=<claim UID>-gpu-0" for a GPU, looked up by index, and "...-gpu-0-mig-19-0-1"
+// for a MIG device, looked up by the placement of its GPU instance; the other nvidia.com CDI devices are named
+// by the UUIDs, e.g. "nvidia.com/gpu=GPU-<UUID>". The GPUs are keyed by all of their IDs, whatever the
+// KubernetesGPUIDType, as the claims do not follow it. It is empty for the CDI devices of other vendors than
+// NVIDIA, and for the devices, that are not found.
+func draDeviceKeys(cdiDevice string, sysInfo SystemInfo) []string {
+	kind, device, found := strings.Cut(cdiDevice, "=")
+	if !found {
+		return nil
+	}
+	vendor, _, _ := strings.Cut(kind, "/")
+	if vendor != nvidiaCDIVendor && !strings.HasSuffix(vendor, "."+nvidiaCDIVendor) {
+		return nil
+	}
+	if uuids := draDeviceUUIDRegex.FindAllString(device, -1); len(uuids) > 0 {
+		var keys []string
+		for _, uuid := range uuids {
+			if strings.HasPrefix(uuid, MIG_UUID_PREFIX) {
+				keys = append(keys, deviceKeys(uuid, sysInfo)...)
+			} else {
+				keys = append(keys, gpuKeys(sysInfo, func(d dcgm.Device) bool { return d.UUID == uuid }, uuid)...)
+			}
+		}
+		return keys
+	}
+
+	if claimDevice := draClaimDeviceRegex.FindStringSubmatch(device); claimDevice != nil {
+		device = claimDevice[1]
+	}
+	if index := draGPUNameRegex.FindStringSubmatch(device); index != nil {
+		if keys := gpuKeys(sysInfo, func(d dcgm.Device) bool { return fmt.Sprint(d.GPU) == index[1] }); keys != nil {
+			return keys
+		}
+	}
+	if mig := draMIGNameRegex.FindStringSubmatch(device); mig != nil {
+		var placement [4]int
+		for i := range placement {
+			placement[i], _ = strconv.Atoi(mig[i+1])
+		}
+		gpuInstance, err := nvmlGetGPUInstanceByPlacementHook(placement[0], placement[1], placement[2], placement[3])
+		if err != nil {
+			logrus.Debugf("No GPU instance found for the CDI device %s; err: %v", cdiDevice, err)
+			return nil
+		}
+		if key := GetGPUInstanceIdentifier(sysInfo, gpuInstance.ParentUUID,
+			uint(gpuInstance.GPUInstanceID)); key != "" {
+			return []string{key}
+		}
+	}
+
+	logrus.Debugf("No GPU, or MIG device, found for the CDI device %s", cdiDevice)
+	return nil
+}
+
+// gpuKeys returns the IDs of the metrics of the first GPU of the system, that matches: its UUID, PCI bus ID,
+// index and device name. It is the default, when no GPU matches.
+func gpuKeys(sysInfo SystemInfo, match func(device dcgm.Device) bool, defaultKeys ...string) []string {
+	for i := uint(0); i < sysInfo.GPUCount; i++ {
+		device := sysInfo.GPUs[i].DeviceInfo
+		if match(device) {
+			index := fmt.Sprint(device.GPU)
+			return []string{device.UUID, normalizePCIBusID(device.PCI.BusID), index, "nvidia" + index}
+		}
+	}
+	return defaultKeys
+}
+
+// deviceReplica returns the replica of a device shared by several pods, i.e. the N of the "<device>::N" IDs
+// of the NVIDIA device plugin, and of the "nvidia<index>/vgpuN" IDs of GKE. It is empty for other IDs.
+func deviceReplica(deviceID string) string {
+	if _, replica, found := strings.Cut(deviceID, "::"); found {
+		return replica
+	}
+	if _, replica, found := strings.Cut(deviceID, gkeVirtualGPUDeviceIDSeparator); found {
+		return replica
+	}
+	return ""
+}
+
+// toDeviceToPod returns the pods, that got the devices. A device is shared, when it has several pods.
 func (p *PodMapper) toDeviceToPod(
 	devicePods *podresourcesapi.ListPodResourcesResponse, sysInfo SystemInfo,
-) map[string]PodInfo {
-	deviceToPodMap := make(map[string]PodInfo)
+) map[string][]PodInfo {
+	deviceToPodMap := make(map[string][]PodInfo)
+
+	sharingStrategy := p.Config.GPUSharingStrategy
+	if sharingStrategy == "" {
+		sharingStrategy = DefaultGPUSharingStrategy
+	}
+
+	addPod := func(keys []string, podInfo PodInfo) {
+		for _, key := range keys {
+			if !slices.Contains(deviceToPodMap[key], podInfo) {
+				deviceToPodMap[key] = append(deviceToPodMap[key], podInfo)
+			}
+		}
+	}
 
 	for _, pod := range devicePods.GetPodResources() {
 		for _, container := range pod.GetContainers() {
 			for _, device := range container.GetDevices() {
-
-				resourceName := device.GetResourceName()
-				if resourceName != nvidiaResourceName && !slices.Contains(p.Config.NvidiaResourceNames, resourceName) {
-					// Mig resources appear differently than GPU resources
-					if !strings.HasPrefix(resourceName, nvidiaMigResourcePrefix) {
-						continue
-					}
+				if !p.isNvidiaResource(device.GetResourceName()) {
+					continue
 				}
 
-				podInfo := PodInfo{
-					Name:      pod.GetName(),
-					Namespace: pod.GetNamespace(),
-					Container: container.GetName(),
+				for _, deviceID := range device.GetDeviceIds() {
+					podInfo := PodInfo{
+						Name:      pod.GetName(),
+						Namespace: pod.GetNamespace(),
+						Container: container.GetName(),
+					}
+					if replica := deviceReplica(deviceID); replica != "" {
+						podInfo.SharingStrategy = sharingStrategy
+						podInfo.Replica = replica
+					}
+					addPod(p.deviceKeys(deviceID, sysInfo), podInfo)
 				}
+			}
 
-				for _, deviceID := range device.GetDeviceIds() {
-					if strings.HasPrefix(deviceID, MIG_UUID_PREFIX) {
-						migDevice, err := nvmlGetMIGDeviceInfoByIDHook(deviceID)
-						if err == nil {
-							giIdentifier := GetGPUInstanceIdentifier(sysInfo, migDevice.ParentUUID,
-								uint(migDevice.GPUInstanceID))
-							deviceToPodMap[giIdentifier] = podInfo
-						}
-						gpuUUID := deviceID[len(MIG_UUID_PREFIX):]
-						deviceToPodMap[gpuUUID] = podInfo
-					} else if gkeMigDeviceIDMatches := gkeMigDeviceIDRegex.FindStringSubmatch(deviceID); gkeMigDeviceIDMatches != nil {
-						var gpuIndex string
-						var gpuInstanceID string
-						for groupIdx, group := range gkeMigDeviceIDMatches {
-							switch groupIdx {
-							case 1:
-								gpuIndex = group
-							case 2:
-								gpuInstanceID = group
-							}
-						}
-						giIdentifier := fmt.Sprintf("%s-%s", gpuIndex, gpuInstanceID)
-						deviceToPodMap[giIdentifier] = podInfo
-					} else if strings.Contains(deviceID, gkeVirtualGPUDeviceIDSeparator) {
-						deviceToPodMap[strings.Split(deviceID, gkeVirtualGPUDeviceIDSeparator)[0]] = podInfo
-					} else if strings.Contains(deviceID, "::") {
-						gpuInstanceID := strings.Split(deviceID, "::")[0]
-						deviceToPodMap[gpuInstanceID] = podInfo
+			// The devices allocated by DRA drivers through resource claims
+			for _, claim := range container.GetDynamicResources() {
+				for _, claimResource := range claim.GetClaimResources() {
+					for _, cdiDevice := range claimResource.GetCDIDevices() {
+						addPod(draDeviceKeys(cdiDevice.GetName(), sysInfo), PodInfo{
+							Name:           pod.GetName(),
+							Namespace:      pod.GetNamespace(),
+							Container:      container.GetName(),
+							ClaimName:      claim.GetClaimName(),
+							ClaimNamespace: claim.GetClaimNamespace(),
+						})
 					}
-					// Default mapping between deviceID and pod information
-					deviceToPodMap[deviceID] = podInfo
 				}
 			}
 		}
@@ -213,3 +583,24 @@ func (p *PodMapper) toDeviceToPod(
 
 	return deviceToPodMap
 }
+
+// toAllocatable returns the IDs of the metrics of the devices kubelet can allocate to pods.
+func (p *PodMapper) toAllocatable(
+	allocatable *podresourcesapi.AllocatableResourcesResponse, sysInfo SystemInfo,
+) map[string]bool {
+	allocatableMap := make(map[string]bool)
+
+	for _, device := range allocatable.GetDevices() {
+		if !p.isNvidiaResource(device.GetResourceName()) {
+			continue
+		}
+
+		for _, deviceID := range device.GetDeviceIds() {
+			for _, key := range p.deviceKeys(deviceID, sysInfo) {
+				allocatableMap[key] = true
+			}
+		}
+	}
+
+	return allocatableMap
+}
@@ -18,20 +18,29 @@ package dcgmexporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
-	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+	podresourcesv1alpha1 "k8s.io/kubelet/pkg/apis/podresources/v1alpha1"
+	"k8s.io/utils/ptr"
 
 	"github.com/NVIDIA/dcgm-exporter/internal/pkg/nvmlprovider"
 	"github.com/NVIDIA/dcgm-exporter/internal/pkg/testutils"
+	"github.com/NVIDIA/dcgm-exporter/pkg/dcgmexporter/podwatcher"
 )
 
 func TestProcessPodMapper(t *testing.T) {
@@ -57,7 +66,7 @@ func TestProcessPodMapper(t *testing.T) {
 	socketPath := tmpDir + "/kubelet.sock"
 	server := grpc.NewServer()
 	gpus := GetGPUUUIDs(arbirtaryMetric)
-	podresourcesapi.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(nvidiaResourceName, gpus))
+	podresourcesv1alpha1.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(nvidiaResourceName, gpus))
 
 	cleanup = StartMockServer(t, server, socketPath)
 	defer cleanup()
@@ -136,18 +145,18 @@ func NewPodResourcesMockServer(resourceName string, gpus []string) *PodResources
 }
 
 func (s *PodResourcesMockServer) List(
-	ctx context.Context, req *podresourcesapi.ListPodResourcesRequest,
-) (*podresourcesapi.ListPodResourcesResponse, error) {
-	podResources := make([]*podresourcesapi.PodResources, len(s.gpus))
+	ctx context.Context, req *podresourcesv1alpha1.ListPodResourcesRequest,
+) (*podresourcesv1alpha1.ListPodResourcesResponse, error) {
+	podResources := make([]*podresourcesv1alpha1.PodResources, len(s.gpus))
 
 	for i, gpu := range s.gpus {
-		podResources[i] = &podresourcesapi.PodResources{
+		podResources[i] = &podresourcesv1alpha1.PodResources{
 			Name:      fmt.Sprintf("gpu-pod-%d", i),
 			Namespace: "default",
-			Containers: []*podresourcesapi.ContainerResources{
+			Containers: []*podresourcesv1alpha1.ContainerResources{
 				{
 					Name: "default",
-					Devices: []*podresourcesapi.ContainerDevices{
+					Devices: []*podresourcesv1alpha1.ContainerDevices{
 						{
 							ResourceName: s.resourceName,
 							DeviceIds:    []string{gpu},
@@ -158,11 +167,46 @@ func (s *PodResourcesMockServer) List(
 		}
 	}
 
-	return &podresourcesapi.ListPodResourcesResponse{
+	return &podresourcesv1alpha1.ListPodResourcesResponse{
 		PodResources: podResources,
 	}, nil
 }
 
+// PodResourcesV1MockServer serves the pods of the PodResourcesMockServer, and the allocatable devices, with the v1 API
+type PodResourcesV1MockServer struct {
+	podresourcesapi.UnimplementedPodResourcesListerServer
+
+	pods        *PodResourcesMockServer
+	allocatable []string
+}
+
+func NewPodResourcesV1MockServer(resourceName string, gpus, allocatable []string) *PodResourcesV1MockServer {
+	return &PodResourcesV1MockServer{
+		pods:        NewPodResourcesMockServer(resourceName, gpus),
+		allocatable: allocatable,
+	}
+}
+
+func (s *PodResourcesV1MockServer) List(
+	ctx context.Context, req *podresourcesapi.ListPodResourcesRequest,
+) (*podresourcesapi.ListPodResourcesResponse, error) {
+	pods, err := s.pods.List(ctx, &podresourcesv1alpha1.ListPodResourcesRequest{})
+	return fromV1alpha1(pods), err
+}
+
+func (s *PodResourcesV1MockServer) GetAllocatableResources(
+	ctx context.Context, req *podresourcesapi.AllocatableResourcesRequest,
+) (*podresourcesapi.AllocatableResourcesResponse, error) {
+	return &podresourcesapi.AllocatableResourcesResponse{
+		Devices: []*podresourcesapi.ContainerDevices{
+			{
+				ResourceName: s.pods.resourceName,
+				DeviceIds:    s.allocatable,
+			},
+		},
+	}, nil
+}
+
 func TestProcessPodMapper_WithD_Different_Format_Of_DeviceID(t *testing.T) {
 	testutils.RequireLinux(t)
 
@@ -260,7 +304,7 @@ func TestProcessPodMapper_WithD_Different_Format_Of_DeviceID(t *testing.T) {
 				defer cleanup()
 
 				gpus := []string{tc.PODGPUID}
-				podresourcesapi.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(tc.ResourceName, gpus))
+				podresourcesv1alpha1.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(tc.ResourceName, gpus))
 
 				cleanup = StartMockServer(t, server, socketPath)
 				defer cleanup()
@@ -335,3 +379,497 @@ func TestProcessPodMapper_WithD_Different_Format_Of_DeviceID(t *testing.T) {
 			})
 	}
 }
+
+func TestPodMapper_SharedGPU(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0::0"}}},
+			}}},
+			{Name: "pod-b", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0::1"}}},
+			}}},
+			{Name: "pod-c", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-1"}}},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	power := Counter{FieldName: "DCGM_FI_DEV_POWER_USAGE", PromType: "gauge"}
+	temp := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+	newMetrics := func() MetricsByCounter {
+		metrics := MetricsByCounter{}
+		for _, counter := range []Counter{power, temp} {
+			for _, gpu := range []string{"0", "1"} {
+				metrics[counter] = append(metrics[counter], Metric{
+					Counter:    counter,
+					Value:      "100",
+					GPU:        gpu,
+					GPUUUID:    "GPU-" + gpu,
+					Labels:     map[string]string{},
+					Attributes: map[string]string{},
+				})
+			}
+		}
+		return metrics
+	}
+
+	type series struct {
+		pod, strategy, replica, value string
+	}
+	seriesOf := func(metrics []Metric, gpu string) []series {
+		var got []series
+		for _, m := range metrics {
+			if m.GPU == gpu {
+				got = append(got, series{
+					m.Attributes[podAttribute], m.Attributes[sharingStrategyAttribute], m.Attributes[replicaAttribute], m.Value,
+				})
+			}
+		}
+		return got
+	}
+
+	// Every pod sharing the GPU gets the series in full
+	podMapper, err := NewPodMapper(&Config{KubernetesGPUIdType: GPUUID, PodResources: cache})
+	require.NoError(t, err)
+	metrics := newMetrics()
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+
+	assert.ElementsMatch(t, []series{
+		{"pod-a", "time-slicing", "0", "100"},
+		{"pod-b", "time-slicing", "1", "100"},
+	}, seriesOf(metrics[power], "0"))
+	assert.Equal(t, []series{{"pod-c", "", "", "100"}}, seriesOf(metrics[power], "1"))
+	assert.Len(t, metrics[temp], 3)
+
+	// The values of the split counters are shared evenly
+	podMapper, err = NewPodMapper(&Config{
+		KubernetesGPUIdType: GPUUID,
+		PodResources:        cache,
+		GPUSharingStrategy:  "mps",
+		GPUSplitCounters:    []string{power.FieldName},
+	})
+	require.NoError(t, err)
+	metrics = newMetrics()
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+
+	assert.ElementsMatch(t, []series{
+		{"pod-a", "mps", "0", "50"},
+		{"pod-b", "mps", "1", "50"},
+	}, seriesOf(metrics[power], "0"))
+	assert.Equal(t, []series{{"pod-c", "", "", "100"}}, seriesOf(metrics[power], "1"))
+	assert.ElementsMatch(t, []series{
+		{"pod-a", "mps", "0", "100"},
+		{"pod-b", "mps", "1", "100"},
+	}, seriesOf(metrics[temp], "0"))
+}
+
+func TestDeviceReplica(t *testing.T) {
+	assert.Equal(t, "1", deviceReplica("GPU-b8ea3855-276c-c9cb-b366-c6fa655957c5::1"))
+	assert.Equal(t, "2", deviceReplica("nvidia0/vgpu2"))
+	assert.Equal(t, "", deviceReplica("GPU-b8ea3855-276c-c9cb-b366-c6fa655957c5"))
+	assert.Equal(t, "", deviceReplica("nvidia0/gi1"))
+
+	assert.Equal(t, []string{"GPU-0", "GPU-0::1"}, deviceKeys("GPU-0::1", SystemInfo{}))
+}
+
+func TestPodMapper_GPUIDTypes(t *testing.T) {
+	sysInfo := SystemInfo{GPUCount: 2}
+	sysInfo.GPUs[0] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 0, UUID: "GPU-0", PCI: dcgm.PCIInfo{BusID: "00000000:3B:00.0"}}}
+	sysInfo.GPUs[1] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 1, UUID: "GPU-1", PCI: dcgm.PCIInfo{BusID: "00000000:86:00.0"}}}
+
+	tests := []struct {
+		idType   KubernetesGPUIDType
+		deviceID string
+	}{
+		{idType: GPUUID, deviceID: "GPU-1"},
+		{idType: DeviceName, deviceID: "nvidia1"},
+		{idType: PCIBusID, deviceID: "0000:86:00.0"},
+		{idType: PCIBusID, deviceID: "00000000:86:00.0"},
+		{idType: GPUIndex, deviceID: "1"},
+		{idType: AutoGPUID, deviceID: "GPU-1"},
+		{idType: AutoGPUID, deviceID: "GPU-1::2"},
+		{idType: AutoGPUID, deviceID: "nvidia1"},
+		{idType: AutoGPUID, deviceID: "nvidia1/vgpu2"},
+		{idType: AutoGPUID, deviceID: "0000:86:00.0"},
+		{idType: AutoGPUID, deviceID: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.idType, tt.deviceID), func(t *testing.T) {
+			cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+			cache.pods = &podresourcesapi.ListPodResourcesResponse{
+				PodResources: []*podresourcesapi.PodResources{
+					{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+						Name: "cuda",
+						Devices: []*podresourcesapi.ContainerDevices{
+							{ResourceName: nvidiaResourceName, DeviceIds: []string{tt.deviceID}},
+						},
+					}}},
+				},
+			}
+			cache.updatedAt = time.Now()
+
+			counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+			metrics := MetricsByCounter{}
+			for i := uint(0); i < sysInfo.GPUCount; i++ {
+				device := sysInfo.GPUs[i].DeviceInfo
+				metrics[counter] = append(metrics[counter], Metric{
+					Counter:     counter,
+					Value:       "42",
+					GPU:         fmt.Sprint(device.GPU),
+					GPUUUID:     device.UUID,
+					GPUDevice:   fmt.Sprintf("nvidia%d", device.GPU),
+					GPUPCIBusID: device.PCI.BusID,
+					Attributes:  map[string]string{},
+				})
+			}
+
+			podMapper, err := NewPodMapper(&Config{KubernetesGPUIdType: tt.idType, PodResources: cache})
+			require.NoError(t, err)
+			require.NoError(t, podMapper.Process(metrics, sysInfo))
+
+			assert.Empty(t, metrics[counter][0].Attributes[podAttribute])
+			assert.Equal(t, "pod-a", metrics[counter][1].Attributes[podAttribute])
+		})
+	}
+}
+
+func TestNormalizePCIBusID(t *testing.T) {
+	assert.Equal(t, "0000:3b:00.0", normalizePCIBusID("00000000:3B:00.0"))
+	assert.Equal(t, "0000:3b:00.0", normalizePCIBusID("0000:3b:00.0"))
+	assert.Equal(t, "00010000:3b:00.0", normalizePCIBusID("00010000:3B:00.0"))
+}
+
+func TestDRADeviceKeys(t *testing.T) {
+	const (
+		gpuUUID  = "GPU-b8ea3855-276c-c9cb-b366-c6fa655957c5"
+		migUUID  = "MIG-0c9e3f2a-5b77-5a0b-9a4b-8b1d6f1e2c3d"
+		claimUID = "4f9a2c51-1d2e-4a5b-8c7d-0e1f2a3b4c5d"
+	)
+
+	sysInfo := SystemInfo{GPUCount: 2}
+	sysInfo.GPUs[0].DeviceInfo.GPU = 0
+	sysInfo.GPUs[0].DeviceInfo.UUID = "GPU-0"
+	sysInfo.GPUs[0].DeviceInfo.PCI.BusID = "00000000:3B:00.0"
+	sysInfo.GPUs[1].DeviceInfo.GPU = 1
+	sysInfo.GPUs[1].DeviceInfo.UUID = gpuUUID
+	sysInfo.GPUs[1].DeviceInfo.PCI.BusID = "00000000:86:00.0"
+
+	nvmlGetMIGDeviceInfoByIDHook = func(uuid string) (*nvmlprovider.MIGDeviceInfo, error) {
+		return &nvmlprovider.MIGDeviceInfo{ParentUUID: gpuUUID, GPUInstanceID: 3}, nil
+	}
+	nvmlGetGPUInstanceByPlacementHook = func(minor, profileID, start, size int) (*nvmlprovider.GPUInstanceInfo, error) {
+		if minor == 1 && profileID == 19 && start == 6 && size == 1 {
+			return &nvmlprovider.GPUInstanceInfo{ParentUUID: gpuUUID, GPUInstanceID: 13}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	defer func() {
+		nvmlGetMIGDeviceInfoByIDHook = nvmlprovider.GetMIGDeviceInfoByID
+		nvmlGetGPUInstanceByPlacementHook = nvmlprovider.GetGPUInstanceByPlacement
+	}()
+
+	gpu0 := []string{"GPU-0", "0000:3b:00.0", "0", "nvidia0"}
+	gpu1 := []string{gpuUUID, "0000:86:00.0", "1", "nvidia1"}
+
+	// The canonical names of the devices of the claims of the NVIDIA DRA driver
+	assert.Equal(t, gpu1, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID+"-gpu-1", sysInfo))
+	assert.Equal(t, gpu0, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID+"-gpu-0", sysInfo))
+	assert.Empty(t, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID+"-gpu-2", sysInfo))
+	assert.Equal(t, []string{"1-13"}, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID+"-gpu-1-mig-19-6-1", sysInfo))
+	assert.Empty(t, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID+"-gpu-1-mig-19-0-1", sysInfo))
+	assert.Empty(t, draDeviceKeys("k8s.gpu.nvidia.com/claim="+claimUID, sysInfo))
+
+	assert.Equal(t, gpu1, draDeviceKeys("nvidia.com/gpu="+gpuUUID, sysInfo))
+	assert.Equal(t, []string{gpuUUID}, draDeviceKeys("nvidia.com/gpu="+gpuUUID, SystemInfo{}))
+	assert.Contains(t, draDeviceKeys("nvidia.com/gpu="+migUUID, sysInfo), "1-3")
+	assert.Equal(t, gpu0, draDeviceKeys("nvidia.com/gpu=gpu-0", sysInfo))
+	assert.Empty(t, draDeviceKeys("nvidia.com/gpu=all", sysInfo))
+	assert.Empty(t, draDeviceKeys("example.com/gpu="+gpuUUID, sysInfo))
+	assert.Empty(t, draDeviceKeys("example.com/gpu=gpu-0", sysInfo))
+	assert.Empty(t, draDeviceKeys(gpuUUID, sysInfo))
+}
+
+func TestPodMapper_DynamicResources(t *testing.T) {
+	const gpuUUID = "GPU-b8ea3855-276c-c9cb-b366-c6fa655957c5"
+
+	nvmlGetGPUInstanceByPlacementHook = func(minor, profileID, start, size int) (*nvmlprovider.GPUInstanceInfo, error) {
+		return &nvmlprovider.GPUInstanceInfo{ParentUUID: "GPU-1", GPUInstanceID: 13}, nil
+	}
+	defer func() {
+		nvmlGetGPUInstanceByPlacementHook = nvmlprovider.GetGPUInstanceByPlacement
+	}()
+
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name: "cuda",
+				DynamicResources: []*podresourcesapi.DynamicResource{{
+					ClassName:      "gpu.nvidia.com",
+					ClaimName:      "pod-a-gpu-7xk2p",
+					ClaimNamespace: "default",
+					ClaimResources: []*podresourcesapi.ClaimResource{{
+						CDIDevices: []*podresourcesapi.CDIDevice{
+							{Name: "k8s.gpu.nvidia.com/claim=4f9a2c51-1d2e-4a5b-8c7d-0e1f2a3b4c5d"},
+							{Name: "k8s.gpu.nvidia.com/claim=4f9a2c51-1d2e-4a5b-8c7d-0e1f2a3b4c5d-gpu-0"},
+							{Name: "example.com/nic=eth1"},
+						},
+					}},
+				}},
+			}}},
+			{Name: "pod-b", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name: "cuda",
+				DynamicResources: []*podresourcesapi.DynamicResource{{
+					ClassName:      "mig.nvidia.com",
+					ClaimName:      "pod-b-mig-9qz4d",
+					ClaimNamespace: "default",
+					ClaimResources: []*podresourcesapi.ClaimResource{{
+						CDIDevices: []*podresourcesapi.CDIDevice{
+							{Name: "k8s.gpu.nvidia.com/claim=0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e-gpu-1-mig-19-6-1"},
+						},
+					}},
+				}},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	sysInfo := SystemInfo{GPUCount: 2}
+	sysInfo.GPUs[0].DeviceInfo.GPU = 0
+	sysInfo.GPUs[0].DeviceInfo.UUID = gpuUUID
+	sysInfo.GPUs[0].DeviceInfo.PCI.BusID = "00000000:3B:00.0"
+	sysInfo.GPUs[1].DeviceInfo.GPU = 1
+	sysInfo.GPUs[1].DeviceInfo.UUID = "GPU-1"
+	sysInfo.GPUs[1].DeviceInfo.PCI.BusID = "00000000:86:00.0"
+
+	// The claims are mapped, whatever the type of the IDs of the device plugin
+	for _, idType := range []KubernetesGPUIDType{GPUUID, DeviceName, GPUIndex, PCIBusID, AutoGPUID} {
+		t.Run(string(idType), func(t *testing.T) {
+			counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+			metrics := MetricsByCounter{counter: {
+				{
+					Counter: counter, Value: "42", GPU: "0", GPUUUID: gpuUUID, GPUDevice: "nvidia0",
+					GPUPCIBusID: "00000000:3B:00.0", Attributes: map[string]string{},
+				},
+				{
+					Counter: counter, Value: "42", GPU: "1", GPUUUID: "GPU-1", GPUDevice: "nvidia1",
+					GPUPCIBusID: "00000000:86:00.0", Attributes: map[string]string{},
+				},
+				{
+					Counter: counter, Value: "42", GPU: "1", GPUUUID: "GPU-1", GPUDevice: "nvidia1",
+					GPUPCIBusID: "00000000:86:00.0", MigProfile: "1g.10gb", GPUInstanceID: "13",
+					Attributes: map[string]string{},
+				},
+			}}
+
+			podMapper, err := NewPodMapper(&Config{KubernetesGPUIdType: idType, PodResources: cache})
+			require.NoError(t, err)
+			require.NoError(t, podMapper.Process(metrics, sysInfo))
+
+			assert.Equal(t, map[string]string{
+				podAttribute:            "pod-a",
+				namespaceAttribute:      "default",
+				containerAttribute:      "cuda",
+				claimAttribute:          "pod-a-gpu-7xk2p",
+				claimNamespaceAttribute: "default",
+			}, metrics[counter][0].Attributes)
+			assert.Empty(t, metrics[counter][1].Attributes)
+			assert.Equal(t, map[string]string{
+				podAttribute:            "pod-b",
+				namespaceAttribute:      "default",
+				containerAttribute:      "cuda",
+				claimAttribute:          "pod-b-mig-9qz4d",
+				claimNamespaceAttribute: "default",
+			}, metrics[counter][2].Attributes)
+		})
+	}
+}
+
+// podConfigAnnotation is a JSON annotation, with the characters, that the text format escapes.
+const podConfigAnnotation = "{\"image\": \"C:\\\\train\",\n \"args\": [\"--epochs\", \"3\"]}"
+
+func TestPodMapper_PodLabelsAndAnnotations(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "pod-a", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0"}}},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	newMetrics := func() MetricsByCounter {
+		counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+		return MetricsByCounter{counter: {{
+			Counter:    counter,
+			Value:      "42",
+			GPU:        "0",
+			UUID:       "UUID",
+			GPUUUID:    "GPU-0",
+			Labels:     map[string]string{},
+			Attributes: map[string]string{},
+		}}}
+	}
+
+	config := &Config{
+		KubernetesGPUIdType: GPUUID,
+		PodResources:        cache,
+		PodLabels:           []string{"team", "app.kubernetes.io/name", "missing"},
+		PodAnnotations:      []string{"example.com/cost-center", "example.com/config"},
+		NamespaceLabels:     []string{"team", "cost-center"},
+	}
+	podMapper, err := NewPodMapper(config)
+	require.NoError(t, err)
+
+	// Without a PodWatcher, the metrics are mapped to the pods only
+	metrics := newMetrics()
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+	for _, metricVals := range metrics {
+		assert.Equal(t, "pod-a", metricVals[0].Attributes[podAttribute])
+		assert.Empty(t, metricVals[0].Labels)
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod-a",
+			Namespace:   "default",
+			Labels:      map[string]string{"team": "ml", "app.kubernetes.io/name": "trainer", "other": "ignored"},
+			Annotations: map[string]string{"example.com/cost-center": "cc-42", "example.com/config": podConfigAnnotation},
+		},
+	}, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "default",
+			Labels: map[string]string{"team": "platform", "cost-center": "cc-7"},
+		},
+	})
+	config.PodWatcher = podwatcher.NewWithClient(client, podwatcher.Options{
+		Labels:          config.PodLabels,
+		Annotations:     config.PodAnnotations,
+		NamespaceLabels: config.NamespaceLabels,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, config.PodWatcher.Run(ctx))
+	}()
+	require.Eventually(t, func() bool {
+		return config.PodWatcher.GetObjectMeta("default", "pod-a") != nil &&
+			config.PodWatcher.GetNamespaceLabels("default") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The labels of the pod take precedence over the ones of its namespace
+	metrics = newMetrics()
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+	for _, metricVals := range metrics {
+		assert.Equal(t, map[string]string{"team": "ml", "app_kubernetes_io_name": "trainer", "cost_center": "cc-7"},
+			metricVals[0].Labels)
+		assert.Equal(t, "cc-42", metricVals[0].Attributes["example_com_cost_center"])
+		assert.Equal(t, podConfigAnnotation, metricVals[0].Attributes["example_com_config"])
+	}
+
+	// The annotations are free text, which the metrics page escapes
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(encodeMetrics(dcgm.FE_GPU, metrics)))
+	require.NoError(t, err)
+	require.Len(t, families["DCGM_FI_DEV_GPU_TEMP"].GetMetric(), 1)
+	var annotation string
+	for _, label := range families["DCGM_FI_DEV_GPU_TEMP"].GetMetric()[0].GetLabel() {
+		if label.GetName() == "example_com_config" {
+			annotation = label.GetValue()
+		}
+	}
+	assert.Equal(t, podConfigAnnotation, annotation)
+}
+
+func TestPodMapper_WorkloadLabels(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{Name: "web-5d4f8-abcde", Namespace: "default", Containers: []*podresourcesapi.ContainerResources{{
+				Name:    "cuda",
+				Devices: []*podresourcesapi.ContainerDevices{{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0"}}},
+			}}},
+		},
+	}
+	cache.updatedAt = time.Now()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-5d4f8-abcde",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d4f8", Controller: ptr.To(true)},
+				},
+			},
+		},
+		&metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-5d4f8",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: ptr.To(true)},
+				},
+			},
+		},
+	)
+
+	config := &Config{
+		KubernetesGPUIdType: GPUUID,
+		PodResources:        cache,
+		WorkloadLabels:      true,
+		PodWatcher:          podwatcher.NewWithClient(client, podwatcher.Options{Workloads: true}),
+	}
+	podMapper, err := NewPodMapper(config)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, config.PodWatcher.Run(ctx))
+	}()
+	require.Eventually(t, func() bool {
+		kind, _, _ := config.PodWatcher.Workload("default", "web-5d4f8-abcde")
+		return kind == "Deployment"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+	metrics := MetricsByCounter{counter: {{
+		Counter:    counter,
+		Value:      "42",
+		GPU:        "0",
+		GPUUUID:    "GPU-0",
+		Labels:     map[string]string{},
+		Attributes: map[string]string{},
+	}}}
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+
+	attributes := metrics[counter][0].Attributes
+	assert.Equal(t, "web-5d4f8-abcde", attributes[podAttribute])
+	assert.Equal(t, "Deployment", attributes[workloadKindAttribute])
+	assert.Equal(t, "web", attributes[workloadNameAttribute])
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "team", sanitizeLabelName("team"))
+	assert.Equal(t, "app_kubernetes_io_name", sanitizeLabelName("app.kubernetes.io/name"))
+	assert.Equal(t, "_1password", sanitizeLabelName("1password"))
+	assert.Equal(t, "_", sanitizeLabelName(""))
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
@@ -86,6 +87,7 @@ func ReadCSVFile(filename string) ([][]string, error) {
 
 	r := csv.NewReader(file)
 	r.Comment = '#'
+	r.FieldsPerRecord = -1
 	records, err := r.ReadAll()
 
 	return records, err
@@ -104,12 +106,26 @@ func extractCounters(records [][]string, c *Config) (*CounterSet, error) {
 			record[j] = strings.Trim(r, " ")
 		}
 
-		if len(record) != 3 {
+		if len(record) != 3 && len(record) != 4 {
 			return nil, fmt.Errorf("malformed CSV record; err: failed to parse line %d (`%v`), "+
-				"expected 3 fields", i,
+				"expected 3 or 4 fields", i,
 				record)
 		}
 
+		var options *CounterOptions
+		if len(record) == 4 {
+			var err error
+			options, err = parseCounterOptions(record[3])
+			if err != nil {
+				return nil, fmt.Errorf("malformed CSV record; err: failed to parse options on line %d (`%v`): %w",
+					i, record, err)
+			}
+			// The quantiles of the other types than summaries imply the sample stats, as before the stats option
+			if options.Quantiles != nil && record[1] != "summary" {
+				options.SampleStats = true
+			}
+		}
+
 		fieldID, ok := dcgm.DCGM_FI[record[0]]
 		oldFieldID, oldOk := dcgm.OLD_DCGM_FI[record[0]]
 		if !ok && !oldOk {
@@ -118,7 +134,7 @@ func extractCounters(records [][]string, c *Config) (*CounterSet, error) {
 			if err != nil {
 				return nil, fmt.Errorf("could not find DCGM field; err: %w", err)
 			} else if expField != DCGMFIUnknown {
-				res.ExporterCounters = append(res.ExporterCounters, Counter{dcgm.Short(expField), record[0], record[1], record[2]})
+				res.ExporterCounters = append(res.ExporterCounters, Counter{dcgm.Short(expField), record[0], record[1], record[2], options})
 				continue
 			}
 		}
@@ -137,7 +153,7 @@ func extractCounters(records [][]string, c *Config) (*CounterSet, error) {
 				return nil, fmt.Errorf("could not find Prometheus metric type '%s'", record[1])
 			}
 
-			res.DCGMCounters = append(res.DCGMCounters, Counter{fieldID, record[0], record[1], record[2]})
+			res.DCGMCounters = append(res.DCGMCounters, Counter{fieldID, record[0], record[1], record[2], options})
 		} else {
 			if !fieldIsSupported(uint(oldFieldID), c) {
 				logrus.Warnf("Skipping line %d ('%s'): metric not enabled", i, record[0])
@@ -148,13 +164,72 @@ func extractCounters(records [][]string, c *Config) (*CounterSet, error) {
 				return nil, fmt.Errorf("could not find Prometheus metric type '%s'", record[1])
 			}
 
-			res.DCGMCounters = append(res.DCGMCounters, Counter{oldFieldID, record[0], record[1], record[2]})
+			res.DCGMCounters = append(res.DCGMCounters, Counter{oldFieldID, record[0], record[1], record[2], options})
 		}
 	}
 
 	return &res, nil
 }
 
+// parseCounterOptions parses the optional fourth column of the counters file.
+// The column contains a whitespace separated list of options, for example: "stats quantiles=0.5|0.99".
+func parseCounterOptions(s string) (*CounterOptions, error) {
+	options := &CounterOptions{}
+
+	for _, option := range strings.Fields(s) {
+		name, value, _ := strings.Cut(option, "=")
+		switch name {
+		case counterOptionIntegrate:
+			options.Integrate = true
+		case counterOptionSampleStats:
+			options.SampleStats = true
+		case counterOptionQuantiles:
+			quantiles, err := parseFloatList(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of the '%s' option: %w", name, err)
+			}
+			for _, q := range quantiles {
+				if q < 0 || q > 1 {
+					return nil, fmt.Errorf("invalid value of the '%s' option: quantile %v is not in [0, 1]", name, q)
+				}
+			}
+			options.Quantiles = quantiles
+		case counterOptionBuckets:
+			buckets, err := parseFloatList(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of the '%s' option: %w", name, err)
+			}
+			for i := 1; i < len(buckets); i++ {
+				if buckets[i] <= buckets[i-1] {
+					return nil, fmt.Errorf("invalid value of the '%s' option: buckets are not in increasing order", name)
+				}
+			}
+			options.Buckets = buckets
+		default:
+			return nil, fmt.Errorf("unknown counter option '%s'", option)
+		}
+	}
+
+	return options, nil
+}
+
+func parseFloatList(s string) ([]float64, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+
+	var res []float64
+	for _, item := range strings.Split(s, counterOptionListSeparator) {
+		v, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+
+	return res, nil
+}
+
 func fieldIsSupported(fieldID uint, c *Config) bool {
 	if fieldID < dcpFieldsStart || fieldID >= cpuFieldsStart {
 		return true
@@ -193,6 +268,7 @@ func readConfigMap(kubeClient kubernetes.Interface, c *Config) ([][]string, erro
 
 	r := csv.NewReader(strings.NewReader(cm.Data["metrics"]))
 	r.Comment = '#'
+	r.FieldsPerRecord = -1
 	records, err := r.ReadAll()
 
 	if len(records) == 0 {
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -109,6 +110,36 @@ func TestExtractCounters(t *testing.T) {
 			field: "DCGM_FI_DEV_GPU_TEMP, gauge, temperature\n",
 			valid: true,
 		},
+		{
+			name:  "Valid Input DCGM_FI_DEV_POWER_USAGE with options",
+			field: "DCGM_FI_DEV_POWER_USAGE, gauge, power, integrate\n",
+			valid: true,
+		},
+		{
+			name:  "Valid Input DCGM_FI_DEV_POWER_USAGE with quantiles",
+			field: "DCGM_FI_DEV_POWER_USAGE, gauge, power, stats quantiles=0.5|0.99\n",
+			valid: true,
+		},
+		{
+			name:  "Invalid Input DCGM_FI_DEV_POWER_USAGE with out of range quantile",
+			field: "DCGM_FI_DEV_POWER_USAGE, gauge, power, quantiles=0.5|99\n",
+			valid: false,
+		},
+		{
+			name:  "Valid Input DCGM_FI_DEV_GPU_UTIL histogram with buckets",
+			field: "DCGM_FI_DEV_GPU_UTIL, histogram, utilization, buckets=25|50|75|100\n",
+			valid: true,
+		},
+		{
+			name:  "Invalid Input DCGM_FI_DEV_GPU_UTIL histogram with unordered buckets",
+			field: "DCGM_FI_DEV_GPU_UTIL, histogram, utilization, buckets=50|25\n",
+			valid: false,
+		},
+		{
+			name:  "Invalid Input DCGM_FI_DEV_POWER_USAGE with unknown option",
+			field: "DCGM_FI_DEV_POWER_USAGE, gauge, power, unknown\n",
+			valid: false,
+		},
 		{
 			name:  "Invalid Input DCGM_EXP_XID_ERRORS_COUNTXXX",
 			field: "DCGM_EXP_XID_ERRORS_COUNTXXX, gauge, temperature\n",
@@ -123,6 +154,20 @@ func TestExtractCounters(t *testing.T) {
 	}
 }
 
+func TestExtractCounters_QuantilesImplySampleStats(t *testing.T) {
+	cs, err := extractCounters([][]string{
+		{"DCGM_FI_DEV_POWER_USAGE", "gauge", "power", "quantiles=0.5|0.99"},
+		{"DCGM_FI_DEV_GPU_UTIL", "summary", "utilization", "quantiles=0.5|0.99"},
+	}, &Config{})
+	require.NoError(t, err)
+	require.Len(t, cs.DCGMCounters, 2)
+
+	assert.True(t, cs.DCGMCounters[0].sampleStats())
+	assert.Equal(t, []float64{0.5, 0.99}, cs.DCGMCounters[0].quantiles())
+	// The quantiles of a summary are the ones of the summary
+	assert.False(t, cs.DCGMCounters[1].sampleStats())
+}
+
 func extractCountersHelper(t *testing.T, input string, valid bool) {
 	tmpFile, err := os.CreateTemp(os.TempDir(), "prefix-")
 	if err != nil {
@@ -21,10 +21,10 @@ import (
 	"context"
 	"fmt"
 	"maps"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
 	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
@@ -98,6 +98,15 @@ func NewMetricsPipeline(config *Config,
 	}
 
 	transformations := getTransformations(config)
+	if config.PodResources != nil {
+		// Before the pod mapper, which labels the allocated devices with their pods
+		for _, transform := range transformations {
+			if podMapper, ok := transform.(*PodMapper); ok {
+				transformations = append([]Transform{newAllocationMapper(podMapper)}, transformations...)
+				break
+			}
+		}
+	}
 
 	var otelMeters *OtelMeters
 	if config.OtelMeter != nil {
@@ -109,7 +118,15 @@ func NewMetricsPipeline(config *Config,
 
 		onErrCleanupFunc := func() {}
 
+		otelCounters := slices.Clone(counters)
 		for _, counter := range counters {
+			if counter.sampleStats() {
+				otelCounters = append(otelCounters, sampleStatsCounters(counter)...)
+			}
+		}
+		otelCounters = append(otelCounters, derivedCounters(config)...)
+
+		for _, counter := range otelCounters {
 			fieldName := strings.ToLower(counter.FieldName)
 			switch counter.PromType {
 			case "gauge":
@@ -122,8 +139,11 @@ func NewMetricsPipeline(config *Config,
 				if err != nil {
 					return nil, onErrCleanupFunc, fmt.Errorf("failed to create counter metric %s: %v", counter.FieldName, err)
 				}
-			case "histogram":
-				otelMeters.Histogram[fieldName], err = config.OtelMeter.Float64Histogram(fieldName, metric.WithDescription(counter.Help))
+			case "histogram", "summary":
+				// OTLP has no summaries, the samples of summary counters are recorded into histograms
+				otelMeters.Histogram[fieldName], err = config.OtelMeter.Float64Histogram(fieldName,
+					metric.WithDescription(counter.Help),
+					metric.WithExplicitBucketBoundaries(counter.buckets()...))
 				if err != nil {
 					return nil, onErrCleanupFunc, fmt.Errorf("failed to create histogram metric %s: %v", counter.FieldName, err)
 				}
@@ -134,12 +154,6 @@ func NewMetricsPipeline(config *Config,
 	return &MetricsPipeline{
 			config: config,
 
-			migMetricsFormat:     template.Must(template.New("migMetrics").Parse(migMetricsFormat)),
-			switchMetricsFormat:  template.Must(template.New("switchMetrics").Parse(switchMetricsFormat)),
-			linkMetricsFormat:    template.Must(template.New("switchMetrics").Parse(linkMetricsFormat)),
-			cpuMetricsFormat:     template.Must(template.New("cpuMetrics").Parse(cpuMetricsFormat)),
-			cpuCoreMetricsFormat: template.Must(template.New("cpuMetrics").Parse(cpuCoreMetricsFormat)),
-
 			counters:        counters,
 			gpuCollector:    gpuCollector,
 			switchCollector: switchCollector,
@@ -148,7 +162,10 @@ func NewMetricsPipeline(config *Config,
 			cpuCollector:    cpuCollector,
 			coreCollector:   coreCollector,
 			otelMeters:      otelMeters,
-			gpuCounters:     make(map[string]float64),
+			relabeler:       getRelabeler(config),
+			limiter:         newSeriesLimiter(config),
+			integrator:      newCounterIntegrator(config.CounterStateFile),
+			status:          newCollectorStatusTracker(),
 		}, func() {
 			for _, cleanup := range cleanups {
 				cleanup()
@@ -158,6 +175,11 @@ func NewMetricsPipeline(config *Config,
 
 func getTransformations(c *Config) []Transform {
 	transformations := []Transform{}
+	// Derived metrics go first, so that the mappers below label them like any other counter
+	if len(c.DerivedMetrics) > 0 {
+		transformations = append(transformations, newDerivedMetricsMapper(c))
+	}
+
 	if c.Kubernetes {
 		podMapper, err := NewPodMapper(c)
 		if err != nil {
@@ -172,6 +194,11 @@ func getTransformations(c *Config) []Transform {
 		transformations = append(transformations, hpcMapper)
 	}
 
+	// Relabeling goes last, so that it sees the labels added by the mappers above
+	if r := getRelabeler(c); r != nil {
+		transformations = append(transformations, r)
+	}
+
 	return transformations
 }
 
@@ -180,14 +207,10 @@ func NewMetricsPipelineWithGPUCollector(c *Config, collector *DCGMCollector) (*M
 	return &MetricsPipeline{
 		config: c,
 
-		migMetricsFormat:     template.Must(template.New("migMetrics").Parse(migMetricsFormat)),
-		switchMetricsFormat:  template.Must(template.New("switchMetrics").Parse(switchMetricsFormat)),
-		linkMetricsFormat:    template.Must(template.New("switchMetrics").Parse(linkMetricsFormat)),
-		cpuMetricsFormat:     template.Must(template.New("cpuMetrics").Parse(cpuMetricsFormat)),
-		cpuCoreMetricsFormat: template.Must(template.New("cpuMetrics").Parse(cpuCoreMetricsFormat)),
-
 		counters:     collector.Counters,
 		gpuCollector: collector,
+		integrator:   newCounterIntegrator(c.CounterStateFile),
+		status:       newCollectorStatusTracker(),
 	}, func() {}, nil
 }
 
@@ -224,15 +247,39 @@ func (m *MetricsPipeline) Run(out chan string, stop chan interface{}, wg *sync.W
 	}
 }
 
+// Collect collects the metrics on demand, as an alternative to Run. The result is reused
+// for the MinCollectInterval, and the concurrent calls share a single collection, which is identified
+// by the returned time.
+func (m *MetricsPipeline) Collect() (string, time.Time, error) {
+	m.scrapeOnce.Do(func() {
+		m.scrapeCache = newScrapeCache(time.Millisecond*time.Duration(m.config.MinCollectInterval), m.run)
+	})
+
+	return m.scrapeCache.Get()
+}
+
+// CollectorStatus returns the outcome of the last collections of the GPU, switch, link, CPU and CPU core collectors.
+func (m *MetricsPipeline) CollectorStatus() []CollectorStatus {
+	return m.status.get()
+}
+
 func (m *MetricsPipeline) run() (string, error) {
 	var metrics map[Counter][]Metric
 	var err error
-	var formatted string
+
+	buf := getBuffer()
+	defer putBuffer(buf)
 
 	ctx := context.TODO()
+	if m.limiter != nil {
+		m.limiter.begin()
+	}
+
 	if m.gpuCollector != nil {
 		/* Collect GPU Metrics */
+		start := time.Now()
 		metrics, err = m.gpuCollector.GetMetrics()
+		m.status.observe("gpu", start, metrics, err)
 		if err != nil {
 			return "", fmt.Errorf("failed to collect gpu metrics; err: %w", err)
 		}
@@ -244,123 +291,172 @@ func (m *MetricsPipeline) run() (string, error) {
 			}
 		}
 
+		m.limit(metrics)
+
 		if m.config.OtelMeter != nil {
 			m.OtelObserveGpuMetrics(ctx, metrics)
 		}
 
-		extended := maps.Clone(metrics)
-		for counter, metricVals := range metrics {
-			newCounter := counter
-			newCounter.FieldName += "_COUNTER"
-			newCounter.PromType = "counter"
-			newMetrics := make([]Metric, 0, len(metricVals))
-			for _, metricVal := range metricVals {
-				fp := metricVal.metricFingerprint()
-				val, err := strconv.ParseFloat(metricVal.Value, 64)
-				if err != nil {
-					logrus.Warnf("Failed to parse metric value %s as uint64: %v", metricVal.Value, err)
-					continue
-				}
-				m.gpuCounters[fp] += val
-				newMetricVal := metricVal
-				newMetricVal.Counter = newCounter
-				newMetricVal.Value = strconv.FormatFloat(m.gpuCounters[fp], 'f', -1, 64)
-				newMetrics = append(newMetrics, newMetricVal)
-			}
-			extended[newCounter] = newMetrics
-		}
+		extended := m.aggregateHistograms(dcgm.FE_GPU, metrics)
+		maps.Copy(extended, m.integrator.Integrate(metrics))
 
-		formatted, err = FormatMetrics(m.migMetricsFormat, extended)
-		if err != nil {
-			return "", fmt.Errorf("failed to format metrics; err: %w", err)
-		}
+		m.encode(buf, dcgm.FE_GPU, extended)
 	}
 
 	if m.switchCollector != nil {
 		/* Collect Switch Metrics */
+		start := time.Now()
 		metrics, err = m.switchCollector.GetMetrics()
+		m.status.observe("switch", start, metrics, err)
 		if err != nil {
 			return "", fmt.Errorf("failed to collect switch metrics; err: %w", err)
 		}
 
+		m.relabel(metrics, m.switchCollector.SysInfo)
+		m.limit(metrics)
+
 		if m.config.OtelMeter != nil {
 			m.OtelObserveSwitchMetrics(ctx, metrics)
 		}
 
 		if len(metrics) > 0 {
-			switchFormatted, err := FormatMetrics(m.switchMetricsFormat, metrics)
-			if err != nil {
-				logrus.Warnf("Failed to format switch metrics with error: %v", err)
-			}
-
-			formatted = formatted + switchFormatted
+			m.encode(buf, dcgm.FE_SWITCH, m.aggregateHistograms(dcgm.FE_SWITCH, metrics))
 		}
 	}
 
 	if m.linkCollector != nil {
 		/* Collect Link Metrics */
+		start := time.Now()
 		metrics, err = m.linkCollector.GetMetrics()
+		m.status.observe("link", start, metrics, err)
 		if err != nil {
 			return "", fmt.Errorf("failed to collect link metrics; err: %w", err)
 		}
 
+		m.relabel(metrics, m.linkCollector.SysInfo)
+		m.limit(metrics)
+
 		if m.config.OtelMeter != nil {
 			m.OtelObserveLinkMetrics(ctx, metrics)
 		}
 
 		if len(metrics) > 0 {
-			switchFormatted, err := FormatMetrics(m.linkMetricsFormat, metrics)
-			if err != nil {
-				logrus.Warnf("failed to format link metrics; err: %v", err)
-			}
-
-			formatted = formatted + switchFormatted
+			m.encode(buf, dcgm.FE_LINK, m.aggregateHistograms(dcgm.FE_LINK, metrics))
 		}
 	}
 
 	if m.cpuCollector != nil {
 		/* Collect CPU Metrics */
+		start := time.Now()
 		metrics, err = m.cpuCollector.GetMetrics()
+		m.status.observe("cpu", start, metrics, err)
 		if err != nil {
 			return "", fmt.Errorf("failed to collect CPU metrics; err: %w", err)
 		}
 
+		m.relabel(metrics, m.cpuCollector.SysInfo)
+		m.limit(metrics)
+
 		if m.config.OtelMeter != nil {
 			m.OtelObserveCpuMetrics(ctx, metrics)
 		}
 
 		if len(metrics) > 0 {
-			cpuFormatted, err := FormatMetrics(m.cpuMetricsFormat, metrics)
-			if err != nil {
-				logrus.Warnf("Failed to format cpu metrics with error: %v", err)
-			}
-
-			formatted = formatted + cpuFormatted
+			m.encode(buf, dcgm.FE_CPU, m.aggregateHistograms(dcgm.FE_CPU, metrics))
 		}
 	}
 
 	if m.coreCollector != nil {
 		/* Collect cpu core Metrics */
+		start := time.Now()
 		metrics, err = m.coreCollector.GetMetrics()
+		m.status.observe("cpu_core", start, metrics, err)
 		if err != nil {
 			return "", fmt.Errorf("failed to collect CPU core metrics; err: %w", err)
 		}
 
+		m.relabel(metrics, m.coreCollector.SysInfo)
+		m.limit(metrics)
+
 		if m.config.OtelMeter != nil {
 			m.OtelObserveCpuCoreMetrics(ctx, metrics)
 		}
 
 		if len(metrics) > 0 {
-			coreFormatted, err := FormatMetrics(m.cpuCoreMetricsFormat, metrics)
-			if err != nil {
-				logrus.Warnf("failed to format cpu core metrics; err: %v", err)
-			}
-
-			formatted = formatted + coreFormatted
+			m.encode(buf, dcgm.FE_CPU_CORE, m.aggregateHistograms(dcgm.FE_CPU_CORE, metrics))
 		}
 	}
 
-	return formatted, nil
+	if m.limiter != nil {
+		m.limiter.end()
+		buf.WriteString(m.limiter.format())
+	}
+
+	if m.config.PodResources != nil {
+		buf.WriteString(m.config.PodResources.format())
+	}
+
+	return buf.String(), nil
+}
+
+// relabel applies the relabeling to the metrics of the entity types, that do not go through the transformations.
+func (m *MetricsPipeline) relabel(metrics MetricsByCounter, sysInfo SystemInfo) {
+	if m.relabeler == nil {
+		return
+	}
+	_ = m.relabeler.Process(metrics, sysInfo)
+}
+
+// LimitRegistry makes the series limits, if configured, apply to the metrics of the Registry collectors too.
+func (m *MetricsPipeline) LimitRegistry(r *Registry) {
+	if m.limiter == nil {
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.limiter = m.limiter
+}
+
+// limit enforces the series limits, if configured.
+func (m *MetricsPipeline) limit(metrics MetricsByCounter) {
+	if m.limiter == nil {
+		return
+	}
+	m.limiter.Limit(metrics)
+}
+
+// encode renders the metrics of the entity type into the buffer.
+// Every entity type has its own encoder, because they render different labels.
+func (m *MetricsPipeline) encode(buf *bytes.Buffer, entityType dcgm.Field_Entity_Group, metrics MetricsByCounter) {
+	if m.encoders == nil {
+		m.encoders = make(map[dcgm.Field_Entity_Group]*metricsEncoder)
+	}
+
+	e, exists := m.encoders[entityType]
+	if !exists {
+		e = newMetricsEncoder(entityType)
+		m.encoders[entityType] = e
+	}
+
+	e.Encode(buf, metrics)
+}
+
+// aggregateHistograms aggregates the histogram and summary counters of the entity type.
+// Every entity type has its own aggregator, because the metrics of different entity types can share fingerprints.
+func (m *MetricsPipeline) aggregateHistograms(entityType dcgm.Field_Entity_Group, metrics MetricsByCounter) MetricsByCounter {
+	if m.histograms == nil {
+		m.histograms = make(map[dcgm.Field_Entity_Group]*histogramAggregator)
+	}
+
+	h, exists := m.histograms[entityType]
+	if !exists {
+		h = newHistogramAggregator()
+		m.histograms[entityType] = h
+	}
+
+	return h.Aggregate(metrics)
 }
 
 func (m *MetricsPipeline) OtelObserveGpuMetrics(ctx context.Context, metrics map[Counter][]Metric) {
@@ -375,10 +471,19 @@ func (m *MetricsPipeline) OtelObserveGpuMetrics(ctx context.Context, metrics map
 		attrs := make([]attribute.KeyValue, 0, n)
 		for _, metricVal := range metricVals {
 			attrs = append(attrs, attribute.String("gpu", metricVal.GPU))
-			attrs = append(attrs, attribute.String(metricVal.UUID, metricVal.GPUUUID))
-			attrs = append(attrs, attribute.String("pci_bus_id", metricVal.GPUPCIBusID))
-			attrs = append(attrs, attribute.String("device", metricVal.GPUDevice))
-			attrs = append(attrs, attribute.String("modelName", metricVal.GPUModelName))
+			// The labels can be dropped by the relabeling
+			if metricVal.GPUUUID != "" {
+				attrs = append(attrs, attribute.String(metricVal.UUID, metricVal.GPUUUID))
+			}
+			if metricVal.GPUPCIBusID != "" {
+				attrs = append(attrs, attribute.String("pci_bus_id", metricVal.GPUPCIBusID))
+			}
+			if metricVal.GPUDevice != "" {
+				attrs = append(attrs, attribute.String("device", metricVal.GPUDevice))
+			}
+			if metricVal.GPUModelName != "" {
+				attrs = append(attrs, attribute.String("modelName", metricVal.GPUModelName))
+			}
 			if metricVal.MigProfile != "" {
 				attrs = append(attrs, attribute.String("GPU_I_PROFILE", metricVal.MigProfile))
 				attrs = append(attrs, attribute.String("GPU_I_ID", metricVal.GPUInstanceID))
@@ -502,7 +607,14 @@ func (m *MetricsPipeline) OtelObserve(ctx context.Context, counter Counter, metr
 	case "counter":
 		c, ok := m.otelMeters.Counter[fieldName]
 		if !ok {
-			panic(fmt.Sprintf("Counter %q not found in otelMeters", fieldName))
+			// The metric was renamed by the relabeling
+			var err error
+			c, err = m.config.OtelMeter.Float64Counter(fieldName, metric.WithDescription(counter.Help))
+			if err != nil {
+				logrus.Warnf("Failed to create counter metric %s: %v", counter.FieldName, err)
+				return
+			}
+			m.otelMeters.Counter[fieldName] = c
 		}
 		val, err := strconv.ParseFloat(metricVal.Value, 64)
 		if err != nil {
@@ -512,17 +624,39 @@ func (m *MetricsPipeline) OtelObserve(ctx context.Context, counter Counter, metr
 	case "gauge":
 		g, ok := m.otelMeters.Gauge[fieldName]
 		if !ok {
-			panic(fmt.Sprintf("Gauge %q not found in otelMeters", fieldName))
+			// The metric was renamed by the relabeling
+			var err error
+			g, err = m.config.OtelMeter.Float64Gauge(fieldName, metric.WithDescription(counter.Help))
+			if err != nil {
+				logrus.Warnf("Failed to create gauge metric %s: %v", counter.FieldName, err)
+				return
+			}
+			m.otelMeters.Gauge[fieldName] = g
 		}
 		val, err := strconv.ParseFloat(metricVal.Value, 64)
 		if err != nil {
 			panic(fmt.Sprintf("Failed to parse metric value %s as float64: %v", metricVal.Value, err))
 		}
 		g.Record(ctx, val, metric.WithAttributes(attrs...))
-	case "histogram":
+	case "histogram", "summary":
 		h, ok := m.otelMeters.Histogram[fieldName]
 		if !ok {
-			panic(fmt.Sprintf("Histogram %q not found in otelMeters", fieldName))
+			// The metric was renamed by the relabeling
+			var err error
+			h, err = m.config.OtelMeter.Float64Histogram(fieldName,
+				metric.WithDescription(counter.Help),
+				metric.WithExplicitBucketBoundaries(counter.buckets()...))
+			if err != nil {
+				logrus.Warnf("Failed to create histogram metric %s: %v", counter.FieldName, err)
+				return
+			}
+			m.otelMeters.Histogram[fieldName] = h
+		}
+		if metricVal.Samples != nil {
+			for _, val := range metricVal.Samples {
+				h.Record(ctx, val, metric.WithAttributes(attrs...))
+			}
+			return
 		}
 		val, err := strconv.ParseFloat(metricVal.Value, 64)
 		if err != nil {
@@ -531,99 +665,3 @@ func (m *MetricsPipeline) OtelObserve(ctx context.Context, counter Counter, metr
 		h.Record(ctx, val, metric.WithAttributes(attrs...))
 	}
 }
-
-/*
-* The goal here is to get to the following format:
-* ```
-* # HELP FIELD_ID HELP_MSG
-* # TYPE FIELD_ID PROM_TYPE
-* FIELD_ID{gpu="GPU_INDEX_0",uuid="GPU_UUID", attr...} VALUE
-* FIELD_ID{gpu="GPU_INDEX_N",uuid="GPU_UUID", attr...} VALUE
-* ...
-* ```
- */
-
-var migMetricsFormat = `
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{gpu="{{ $metric.GPU }}",{{ $metric.UUID }}="{{ $metric.GPUUUID }}",pci_bus_id="{{ $metric.GPUPCIBusID }}",device="{{ $metric.GPUDevice }}",modelName="{{ $metric.GPUModelName }}"{{if $metric.MigProfile}},GPU_I_PROFILE="{{ $metric.MigProfile }}",GPU_I_ID="{{ $metric.GPUInstanceID }}"{{end}}{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-{{- range $k, $v := $metric.Attributes -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
-var switchMetricsFormat = `
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{nvswitch="{{ $metric.GPU }}"{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
-var linkMetricsFormat = `
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{nvlink="{{ $metric.GPU }}",nvswitch="{{ $metric.GPUDevice }}"{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
-var cpuMetricsFormat = `
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{cpu="{{ $metric.GPU }}"{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
-var cpuCoreMetricsFormat = `
-{{- range $counter, $metrics := . -}}
-# HELP {{ $counter.FieldName }} {{ $counter.Help }}
-# TYPE {{ $counter.FieldName }} {{ $counter.PromType }}
-{{- range $metric := $metrics }}
-{{ $counter.FieldName }}{cpucore="{{ $metric.GPU }}",cpu="{{ $metric.GPUDevice }}"{{if $metric.Hostname }},Hostname="{{ $metric.Hostname }}"{{end}}
-
-{{- range $k, $v := $metric.Labels -}}
-	,{{ $k }}="{{ $v }}"
-{{- end -}}
-} {{ $metric.Value -}}
-{{- end }}
-{{ end }}`
-
-// FormatMetrics Template is passed here so that it isn't recompiled at each iteration
-func FormatMetrics(t *template.Template, groupedMetrics MetricsByCounter) (string, error) {
-	// Format metrics
-	var res bytes.Buffer
-	if err := t.Execute(&res, groupedMetrics); err != nil {
-		return "", err
-	}
-
-	return res.String(), nil
-}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"maps"
+)
+
+var (
+	gpuAllocatableCounter = Counter{
+		FieldName: "DCGM_EXP_GPU_ALLOCATABLE",
+		PromType:  "gauge",
+		Help:      "Whether kubelet can allocate the GPU, or MIG device, to pods (1 if allocatable).",
+	}
+	gpuAllocatedCounter = Counter{
+		FieldName: "DCGM_EXP_GPU_ALLOCATED",
+		PromType:  "gauge",
+		Help:      "Whether the GPU, or MIG device, is allocated to a pod (1 if allocated).",
+	}
+)
+
+// allocationMapper adds, per GPU, or GPU instance of the GPUs in MIG mode, whether kubelet can allocate it
+// to pods and whether it is allocated, according to the PodResourcesCache. The pod mapper labels the allocated
+// devices with their pods, like any other counter. The allocatable devices are only known to kubelets,
+// which serve the v1 pod resources API, otherwise only the allocated devices are reported.
+//
+// It runs in the pipeline only, the Registry collectors would report the devices once per collector.
+type allocationMapper struct {
+	config    *Config
+	podMapper *PodMapper
+}
+
+// newAllocationMapper returns an allocationMapper, that keys the devices like the pod mapper,
+// with its device ID rules.
+func newAllocationMapper(podMapper *PodMapper) *allocationMapper {
+	return &allocationMapper{
+		config:    podMapper.Config,
+		podMapper: podMapper,
+	}
+}
+
+func (p *allocationMapper) Name() string {
+	return "allocationMapper"
+}
+
+// allocationEntity is the key of a GPU, or a GPU instance, of the collected metrics.
+type allocationEntity struct {
+	gpu           string
+	gpuInstanceID string
+}
+
+func (p *allocationMapper) Process(metrics MetricsByCounter, sysInfo SystemInfo) error {
+	pods, ok := p.config.PodResources.get()
+	if !ok {
+		return nil
+	}
+
+	deviceToPod := p.podMapper.toDeviceToPod(pods, sysInfo)
+
+	var allocatable map[string]bool
+	if resp, ok := p.config.PodResources.getAllocatable(); ok {
+		allocatable = p.podMapper.toAllocatable(resp, sysInfo)
+	}
+
+	// The devices are labelled like their first metric
+	templates := map[allocationEntity]Metric{}
+	for _, metricVals := range metrics {
+		for _, metricVal := range metricVals {
+			entity := allocationEntity{gpu: metricVal.GPU, gpuInstanceID: metricVal.GPUInstanceID}
+			if _, exists := templates[entity]; !exists {
+				templates[entity] = metricVal
+			}
+		}
+	}
+
+	var devices []Metric
+	for i := uint(0); i < sysInfo.GPUCount; i++ {
+		gpu := sysInfo.GPUs[i]
+		gpuIndex := fmt.Sprintf("%d", gpu.DeviceInfo.GPU)
+
+		if !gpu.MigEnabled {
+			if template, exists := templates[allocationEntity{gpu: gpuIndex}]; exists {
+				devices = append(devices, template)
+			}
+			continue
+		}
+
+		// The GPU instances are allocated, rather than the GPUs in MIG mode
+		for _, instance := range gpu.GPUInstances {
+			entity := allocationEntity{gpu: gpuIndex, gpuInstanceID: fmt.Sprintf("%d", instance.Info.NvmlInstanceId)}
+			if template, exists := templates[entity]; exists {
+				devices = append(devices, template)
+			}
+		}
+	}
+
+	for _, device := range devices {
+		deviceID, err := device.getIDOfType(p.config.KubernetesGPUIdType)
+		if err != nil {
+			return err
+		}
+
+		_, allocated := deviceToPod[deviceID]
+		metrics[gpuAllocatedCounter] = append(metrics[gpuAllocatedCounter],
+			allocationMetric(device, gpuAllocatedCounter, allocated))
+
+		if allocatable != nil {
+			metrics[gpuAllocatableCounter] = append(metrics[gpuAllocatableCounter],
+				allocationMetric(device, gpuAllocatableCounter, allocatable[deviceID]))
+		}
+	}
+
+	return nil
+}
+
+func allocationMetric(device Metric, counter Counter, value bool) Metric {
+	m := Metric{
+		Counter: counter,
+		Value:   "0",
+
+		UUID:          device.UUID,
+		GPU:           device.GPU,
+		GPUUUID:       device.GPUUUID,
+		GPUDevice:     device.GPUDevice,
+		GPUModelName:  device.GPUModelName,
+		GPUPCIBusID:   device.GPUPCIBusID,
+		MigProfile:    device.MigProfile,
+		GPUInstanceID: device.GPUInstanceID,
+		Hostname:      device.Hostname,
+
+		Labels:     maps.Clone(device.Labels),
+		Attributes: map[string]string{},
+	}
+	if m.Labels == nil {
+		m.Labels = map[string]string{}
+	}
+	if value {
+		m.Value = "1"
+	}
+	return m
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+func allocationTestSysInfo() SystemInfo {
+	sysInfo := SystemInfo{GPUCount: 3}
+	sysInfo.GPUs[0] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 0, UUID: "GPU-0"}}
+	sysInfo.GPUs[1] = GPUInfo{DeviceInfo: dcgm.Device{GPU: 1, UUID: "GPU-1"}}
+	sysInfo.GPUs[2] = GPUInfo{
+		DeviceInfo: dcgm.Device{GPU: 2, UUID: "GPU-2"},
+		MigEnabled: true,
+		GPUInstances: []GPUInstanceInfo{
+			{Info: dcgm.MigEntityInfo{NvmlInstanceId: 3}, ProfileName: "1g.5gb"},
+			{Info: dcgm.MigEntityInfo{NvmlInstanceId: 5}, ProfileName: "1g.5gb"},
+		},
+	}
+	return sysInfo
+}
+
+func allocationTestMetrics() MetricsByCounter {
+	counter := Counter{FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "gauge"}
+	metric := func(gpu, uuid, gpuInstanceID string) Metric {
+		m := Metric{
+			Counter:      counter,
+			Value:        "0",
+			UUID:         "UUID",
+			GPU:          gpu,
+			GPUUUID:      uuid,
+			GPUDevice:    "nvidia" + gpu,
+			GPUModelName: "NVIDIA A100",
+			Hostname:     "node-0",
+			Labels:       map[string]string{"DCGM_FI_DRIVER_VERSION": "550.54.15"},
+			Attributes:   map[string]string{},
+		}
+		if gpuInstanceID != "" {
+			m.MigProfile, m.GPUInstanceID = "1g.5gb", gpuInstanceID
+		}
+		return m
+	}
+
+	return MetricsByCounter{counter: {
+		metric("0", "GPU-0", ""),
+		metric("1", "GPU-1", ""),
+		metric("2", "GPU-2", ""),
+		metric("2", "GPU-2", "3"),
+		metric("2", "GPU-2", "5"),
+	}}
+}
+
+func allocationTestCache(allocatable []string) *PodResourcesCache {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{
+			{
+				Name:      "train-0",
+				Namespace: "ml",
+				Containers: []*podresourcesapi.ContainerResources{{
+					Name: "trainer",
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0"}},
+						{ResourceName: "nvidia.com/mig-1g.5gb", DeviceIds: []string{"nvidia2/gi5"}},
+					},
+				}},
+			},
+		},
+	}
+	if allocatable != nil {
+		cache.allocatable = &podresourcesapi.AllocatableResourcesResponse{
+			Devices: []*podresourcesapi.ContainerDevices{
+				{ResourceName: nvidiaResourceName, DeviceIds: []string{"GPU-0", "GPU-1"}},
+				{ResourceName: "nvidia.com/mig-1g.5gb", DeviceIds: allocatable},
+			},
+		}
+	}
+	cache.updatedAt = time.Now()
+	return cache
+}
+
+func allocationValues(metrics []Metric) map[string]string {
+	values := map[string]string{}
+	for _, m := range metrics {
+		values[m.GPU+"/"+m.GPUInstanceID] = m.Value
+	}
+	return values
+}
+
+func newTestAllocationMapper(t *testing.T, config *Config) *allocationMapper {
+	podMapper, err := NewPodMapper(config)
+	require.NoError(t, err)
+	return newAllocationMapper(podMapper)
+}
+
+func TestAllocationMapper(t *testing.T) {
+	config := &Config{KubernetesGPUIdType: GPUUID, PodResources: allocationTestCache([]string{"nvidia2/gi3", "nvidia2/gi5"})}
+	metrics := allocationTestMetrics()
+
+	mapper := newTestAllocationMapper(t, config)
+	require.NoError(t, mapper.Process(metrics, allocationTestSysInfo()))
+
+	// The GPU in MIG mode is reported by its GPU instances
+	assert.Equal(t, map[string]string{"0/": "1", "1/": "0", "2/3": "0", "2/5": "1"},
+		allocationValues(metrics[gpuAllocatedCounter]))
+	assert.Equal(t, map[string]string{"0/": "1", "1/": "1", "2/3": "1", "2/5": "1"},
+		allocationValues(metrics[gpuAllocatableCounter]))
+
+	allocated := metrics[gpuAllocatedCounter][0]
+	assert.Equal(t, "GPU-0", allocated.GPUUUID)
+	assert.Equal(t, "nvidia0", allocated.GPUDevice)
+	assert.Equal(t, "NVIDIA A100", allocated.GPUModelName)
+	assert.Equal(t, "node-0", allocated.Hostname)
+	assert.Equal(t, "550.54.15", allocated.Labels["DCGM_FI_DRIVER_VERSION"])
+	assert.Empty(t, allocated.Attributes)
+}
+
+func TestAllocationMapper_NoAllocatable(t *testing.T) {
+	// Kubelet only serves the v1alpha1 API
+	config := &Config{KubernetesGPUIdType: GPUUID, PodResources: allocationTestCache(nil)}
+	metrics := allocationTestMetrics()
+
+	require.NoError(t, newTestAllocationMapper(t, config).Process(metrics, allocationTestSysInfo()))
+	assert.Len(t, metrics[gpuAllocatedCounter], 4)
+	assert.NotContains(t, metrics, gpuAllocatableCounter)
+}
+
+func TestAllocationMapper_NoPods(t *testing.T) {
+	config := &Config{
+		KubernetesGPUIdType: GPUUID,
+		PodResources:        NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute),
+	}
+	metrics := allocationTestMetrics()
+
+	require.NoError(t, newTestAllocationMapper(t, config).Process(metrics, allocationTestSysInfo()))
+	assert.Len(t, metrics, 1)
+}
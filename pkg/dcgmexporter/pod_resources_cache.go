@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	podResourcesRefreshDurationMetricName = "dcgm_exporter_pod_resources_refresh_duration_seconds"
+	podResourcesRefreshesMetricName       = "dcgm_exporter_pod_resources_refreshes_total"
+	podResourcesRefreshFailuresMetricName = "dcgm_exporter_pod_resources_refresh_failures_total"
+	podResourcesLastSuccessMetricName     = "dcgm_exporter_pod_resources_last_success_timestamp_seconds"
+
+	DefaultPodResourcesTTL = time.Minute
+)
+
+// PodResourcesCache lists the pod resources of kubelet in the background, over a long-lived connection,
+// so that neither the pipeline nor the Registry collectors wait for kubelet. The pod mappers of all
+// the collectors share it. The last list is used for the TTL after the last successful refresh,
+// so that the mapping survives a kubelet restart. The allocatable devices are listed along with the pods,
+// when kubelet serves them.
+type PodResourcesCache struct {
+	socket   string
+	interval time.Duration
+	ttl      time.Duration
+	now      func() time.Time
+
+	mtx         sync.RWMutex
+	conn        *grpc.ClientConn
+	closeConn   func()
+	pods        *podresourcesapi.ListPodResourcesResponse
+	allocatable *podresourcesapi.AllocatableResourcesResponse // nil, unless kubelet serves them
+	updatedAt   time.Time                                     // Time of the last successful refresh
+
+	refreshes    uint64
+	failures     uint64
+	lastDuration time.Duration
+}
+
+func NewPodResourcesCache(socket string, interval, ttl time.Duration) *PodResourcesCache {
+	return &PodResourcesCache{
+		socket:    socket,
+		interval:  interval,
+		ttl:       ttl,
+		now:       time.Now,
+		closeConn: func() {},
+	}
+}
+
+// Run refreshes the cache every interval, until the context is done.
+func (c *PodResourcesCache) Run(ctx context.Context) {
+	logrus.Infof("Refreshing pod resources of '%s' every %s", c.socket, c.interval)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	defer c.close()
+
+	for {
+		if err := c.refresh(ctx); err != nil {
+			logrus.WithError(err).Warn("Failed to refresh pod resources.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (c *PodResourcesCache) refresh(ctx context.Context) error {
+	start := c.now()
+	pods, allocatable, err := c.list(ctx)
+	duration := c.now().Sub(start)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.refreshes++
+	c.lastDuration = duration
+	if err != nil {
+		c.failures++
+		return err
+	}
+
+	c.pods = pods
+	c.allocatable = allocatable
+	c.updatedAt = c.now()
+
+	return nil
+}
+
+func (c *PodResourcesCache) list(
+	ctx context.Context,
+) (*podresourcesapi.ListPodResourcesResponse, *podresourcesapi.AllocatableResourcesResponse, error) {
+	if _, err := os.Stat(c.socket); err != nil {
+		// Kubelet is restarting, dial the new socket, once it is there
+		c.close()
+		return nil, nil, fmt.Errorf("no kubelet socket '%s'; err: %w", c.socket, err)
+	}
+
+	c.mtx.RLock()
+	conn := c.conn
+	c.mtx.RUnlock()
+
+	if conn == nil {
+		var (
+			closeConn func()
+			err       error
+		)
+		conn, closeConn, err = connectToServer(c.socket)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c.mtx.Lock()
+		c.conn, c.closeConn = conn, closeConn
+		c.mtx.Unlock()
+	}
+
+	pods, err := listPodResources(ctx, conn)
+	if err != nil {
+		// Dial again on the next refresh rather than wait for the reconnection backoff
+		c.close()
+		return nil, nil, err
+	}
+
+	allocatable, err := getAllocatableResources(ctx, conn)
+	if err != nil {
+		// Older kubelets do not serve them, the pods are mapped nonetheless
+		logrus.WithError(err).Debug("No allocatable resources, ignoring")
+	}
+
+	return pods, allocatable, nil
+}
+
+func (c *PodResourcesCache) close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.closeConn()
+	c.conn, c.closeConn = nil, func() {}
+}
+
+// get returns the pod resources of the last successful refresh, unless it is older than the TTL.
+func (c *PodResourcesCache) get() (*podresourcesapi.ListPodResourcesResponse, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.pods == nil || c.now().Sub(c.updatedAt) > c.ttl {
+		return nil, false
+	}
+	return c.pods, true
+}
+
+// getAllocatable returns the allocatable resources of the last successful refresh, unless it is older than the TTL,
+// or kubelet does not serve them.
+func (c *PodResourcesCache) getAllocatable() (*podresourcesapi.AllocatableResourcesResponse, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.allocatable == nil || c.now().Sub(c.updatedAt) > c.ttl {
+		return nil, false
+	}
+	return c.allocatable, true
+}
+
+// format renders the metrics of the refreshes.
+func (c *PodResourcesCache) format() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# HELP %s Duration of the last refresh of the pod resources.\n", podResourcesRefreshDurationMetricName)
+	fmt.Fprintf(&sb, "# TYPE %s gauge\n", podResourcesRefreshDurationMetricName)
+	fmt.Fprintf(&sb, "%s %g\n", podResourcesRefreshDurationMetricName, c.lastDuration.Seconds())
+
+	fmt.Fprintf(&sb, "# HELP %s Number of refreshes of the pod resources.\n", podResourcesRefreshesMetricName)
+	fmt.Fprintf(&sb, "# TYPE %s counter\n", podResourcesRefreshesMetricName)
+	fmt.Fprintf(&sb, "%s %d\n", podResourcesRefreshesMetricName, c.refreshes)
+
+	fmt.Fprintf(&sb, "# HELP %s Number of failed refreshes of the pod resources.\n", podResourcesRefreshFailuresMetricName)
+	fmt.Fprintf(&sb, "# TYPE %s counter\n", podResourcesRefreshFailuresMetricName)
+	fmt.Fprintf(&sb, "%s %d\n", podResourcesRefreshFailuresMetricName, c.failures)
+
+	if !c.updatedAt.IsZero() {
+		fmt.Fprintf(&sb, "# HELP %s Time of the last successful refresh of the pod resources.\n", podResourcesLastSuccessMetricName)
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", podResourcesLastSuccessMetricName)
+		fmt.Fprintf(&sb, "%s %d\n", podResourcesLastSuccessMetricName, c.updatedAt.Unix())
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+	podresourcesv1alpha1 "k8s.io/kubelet/pkg/apis/podresources/v1alpha1"
+
+	"github.com/NVIDIA/dcgm-exporter/internal/pkg/testutils"
+)
+
+func TestPodResourcesCache(t *testing.T) {
+	testutils.RequireLinux(t)
+
+	tmpDir, cleanup := CreateTmpDir(t)
+	defer cleanup()
+	socketPath := tmpDir + "/kubelet.sock"
+
+	now := time.Unix(1700000000, 0)
+	cache := NewPodResourcesCache(socketPath, time.Second, time.Minute)
+	cache.now = func() time.Time { return now }
+	defer cache.close()
+
+	// No kubelet yet
+	require.Error(t, cache.refresh(context.Background()))
+	_, ok := cache.get()
+	assert.False(t, ok)
+
+	server := grpc.NewServer()
+	podresourcesv1alpha1.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(nvidiaResourceName, []string{"GPU-0"}))
+	stop := StartMockServer(t, server, socketPath)
+
+	require.NoError(t, cache.refresh(context.Background()))
+	pods, ok := cache.get()
+	require.True(t, ok)
+	require.Len(t, pods.GetPodResources(), 1)
+	assert.Equal(t, "gpu-pod-0", pods.GetPodResources()[0].GetName())
+	assert.Equal(t, []string{"GPU-0"}, pods.GetPodResources()[0].GetContainers()[0].GetDevices()[0].GetDeviceIds())
+
+	// Kubelet only serves the v1alpha1 API, which has no allocatable resources
+	_, ok = cache.getAllocatable()
+	assert.False(t, ok)
+
+	// Kubelet is restarting; the last pods are used for the TTL
+	stop()
+	require.Error(t, cache.refresh(context.Background()))
+	now = now.Add(30 * time.Second)
+	_, ok = cache.get()
+	assert.True(t, ok)
+	now = now.Add(31 * time.Second)
+	_, ok = cache.get()
+	assert.False(t, ok)
+
+	// Kubelet is back on a new socket
+	server = grpc.NewServer()
+	podresourcesv1alpha1.RegisterPodResourcesListerServer(server, NewPodResourcesMockServer(nvidiaResourceName, []string{"GPU-0", "GPU-1"}))
+	stop = StartMockServer(t, server, socketPath)
+	defer stop()
+
+	require.NoError(t, cache.refresh(context.Background()))
+	pods, ok = cache.get()
+	require.True(t, ok)
+	assert.Len(t, pods.GetPodResources(), 2)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(cache.format()))
+	require.NoError(t, err)
+	assert.Equal(t, float64(4), families[podResourcesRefreshesMetricName].GetMetric()[0].GetCounter().GetValue())
+	assert.Equal(t, float64(2), families[podResourcesRefreshFailuresMetricName].GetMetric()[0].GetCounter().GetValue())
+	assert.Equal(t, float64(now.Unix()), families[podResourcesLastSuccessMetricName].GetMetric()[0].GetGauge().GetValue())
+	assert.Contains(t, families, podResourcesRefreshDurationMetricName)
+}
+
+func TestPodResourcesCache_V1(t *testing.T) {
+	testutils.RequireLinux(t)
+
+	tmpDir, cleanup := CreateTmpDir(t)
+	defer cleanup()
+	socketPath := tmpDir + "/kubelet.sock"
+
+	server := grpc.NewServer()
+	podresourcesapi.RegisterPodResourcesListerServer(server,
+		NewPodResourcesV1MockServer(nvidiaResourceName, []string{"GPU-0"}, []string{"GPU-0", "GPU-1"}))
+	stop := StartMockServer(t, server, socketPath)
+	defer stop()
+
+	cache := NewPodResourcesCache(socketPath, time.Second, time.Minute)
+	defer cache.close()
+
+	require.NoError(t, cache.refresh(context.Background()))
+	pods, ok := cache.get()
+	require.True(t, ok)
+	require.Len(t, pods.GetPodResources(), 1)
+	assert.Equal(t, "gpu-pod-0", pods.GetPodResources()[0].GetName())
+
+	allocatable, ok := cache.getAllocatable()
+	require.True(t, ok)
+	require.Len(t, allocatable.GetDevices(), 1)
+	assert.Equal(t, []string{"GPU-0", "GPU-1"}, allocatable.GetDevices()[0].GetDeviceIds())
+}
+
+func TestPodResourcesCache_Format_NoRefresh(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+
+	out := cache.format()
+	assert.Contains(t, out, podResourcesRefreshesMetricName+" 0\n")
+	assert.NotContains(t, out, podResourcesLastSuccessMetricName)
+}
+
+func TestPodMapper_PodResources_Cache(t *testing.T) {
+	cache := NewPodResourcesCache("/nonexistent/kubelet.sock", time.Second, time.Minute)
+	podMapper, err := NewPodMapper(&Config{PodResources: cache, PodResourcesKubeletSocket: "/nonexistent/kubelet.sock"})
+	require.NoError(t, err)
+
+	// The mapper never dials kubelet itself, when there is a cache
+	pods, err := podMapper.podResources()
+	require.NoError(t, err)
+	assert.Nil(t, pods)
+
+	cache.pods = &podresourcesapi.ListPodResourcesResponse{
+		PodResources: []*podresourcesapi.PodResources{{Name: "gpu-pod-0", Namespace: "default"}},
+	}
+	cache.updatedAt = time.Now()
+
+	pods, err = podMapper.podResources()
+	require.NoError(t, err)
+	assert.Same(t, cache.pods, pods)
+
+	// Without pods, the metrics are left as they are
+	metrics := MetricsByCounter{Counter{FieldName: "DCGM_FI_DEV_GPU_TEMP"}: {{GPU: "0", GPUUUID: "GPU-0"}}}
+	cache.pods = nil
+	require.NoError(t, podMapper.Process(metrics, SystemInfo{}))
+}
+
+func TestPodResourcesCache_Run_Reload(t *testing.T) {
+	refreshes := func(cache *PodResourcesCache) uint64 {
+		cache.mtx.RLock()
+		defer cache.mtx.RUnlock()
+		return cache.refreshes
+	}
+
+	// Every run of the exporter refreshes its cache with its own context, which is cancelled on SIGHUP
+	parent := context.Background()
+	for run := 0; run < 2; run++ {
+		ctx, cancel := context.WithCancel(parent)
+		cache := NewPodResourcesCache("/nonexistent/kubelet.sock", 10*time.Millisecond, time.Minute)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cache.Run(ctx)
+		}()
+
+		// The cache keeps refreshing, after the reload too
+		require.Eventually(t, func() bool { return refreshes(cache) >= 3 }, time.Second, 5*time.Millisecond, "run %d", run)
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d did not stop", run)
+		}
+	}
+}
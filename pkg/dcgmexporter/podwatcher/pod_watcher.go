@@ -2,86 +2,288 @@ package podwatcher
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
 )
 
+// Options select the pods to watch and the metadata to keep of them.
+type Options struct {
+	// Kubeconfig is the kubeconfig file for out-of-cluster runs. If empty, the in-cluster config is used.
+	Kubeconfig string
+	// NodeName restricts the watch to the pods of the node. If empty, the pods of the whole cluster are watched.
+	NodeName string
+	// Labels and Annotations are the ones kept of the pods, the others are dropped.
+	Labels      []string
+	Annotations []string
+	// Workloads resolves the workloads of the pods through their controllers, see Workload.
+	Workloads bool
+	// WorkloadKinds are the custom resources, e.g. kubeflow.org/v1 PyTorchJob, which controllers
+	// are followed in addition to the ones of the ReplicaSets and the Jobs.
+	WorkloadKinds []schema.GroupVersionKind
+	// NamespaceLabels are the labels kept of the namespaces, see GetNamespaceLabels.
+	// If empty, the namespaces are not watched.
+	NamespaceLabels []string
+}
+
+// PodWatcher keeps the labels and annotations, and the controllers, of the pods, and the labels of the namespaces
+// with metadata-only informers,
+// so that neither the specs nor the statuses of the pods are transferred and cached.
 type PodWatcher struct {
 	mu     sync.Mutex
 	m      map[string]*metav1.ObjectMeta
-	client kubernetes.Interface
+	client metadata.Interface
+	opts   Options
+
+	informers   metadatainformer.SharedInformerFactory
+	podInformer cache.SharedIndexInformer
+	// clusterInformers watch the namespaces in the whole cluster
+	clusterInformers  metadatainformer.SharedInformerFactory
+	namespaceInformer cache.SharedIndexInformer
+
+	// owners are the resources of the followed controllers by group and kind, ownerCache keeps the controllers
+	// of the controllers of the pods, see getController. The pods are queued to resolve their workloads.
+	owners        map[schema.GroupKind]schema.GroupVersionResource
+	ownerCache    map[ownerKey]ownerEntry
+	ownerErrors   map[schema.GroupKind]bool
+	ownerTTL      time.Duration
+	ownerErrorTTL time.Duration
+	queue         workqueue.Interface
+	workloads     map[string]workloadRef
 }
 
-func New() (*PodWatcher, error) {
-	config, err := rest.InClusterConfig()
+// RESTConfig returns the config of the kubeconfig file, or the in-cluster config, when it is empty.
+func RESTConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+func New(opts Options) (*PodWatcher, error) {
+	config, err := RESTConfig(opts.Kubeconfig)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get Kubernetes config; err: %w", err)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	client, err := metadata.NewForConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create Kubernetes client; err: %w", err)
 	}
 
-	return &PodWatcher{
+	return NewWithClient(client, opts), nil
+}
+
+// NewWithClient returns a PodWatcher, that watches the pods with the client.
+func NewWithClient(client metadata.Interface, opts Options) *PodWatcher {
+	pw := &PodWatcher{
 		m:      make(map[string]*metav1.ObjectMeta),
-		client: clientset,
-	}, nil
+		client: client,
+		opts:   opts,
+	}
+
+	pw.informers = metadatainformer.NewFilteredSharedInformerFactory(client, 10*time.Minute, metav1.NamespaceAll,
+		pw.tweakListOptions)
+	pw.podInformer = pw.informers.ForResource(corev1.SchemeGroupVersion.WithResource("pods")).Informer()
+
+	// The controllers of the pods are got on demand, for the pods of the node only
+	if opts.Workloads {
+		pw.owners = make(map[schema.GroupKind]schema.GroupVersionResource)
+		for _, kind := range append(builtinWorkloadKinds(), opts.WorkloadKinds...) {
+			pw.owners[kind.GroupKind()], _ = meta.UnsafeGuessKindToResource(kind)
+		}
+		pw.ownerCache = make(map[ownerKey]ownerEntry)
+		pw.ownerErrors = make(map[schema.GroupKind]bool)
+		pw.ownerTTL = ownerTTL
+		pw.ownerErrorTTL = ownerErrorTTL
+		pw.queue = workqueue.New()
+		pw.workloads = make(map[string]workloadRef)
+	}
+
+	// The namespaces are not bound to the node, they are watched in the whole cluster
+	pw.clusterInformers = metadatainformer.NewSharedInformerFactory(client, 10*time.Minute)
+	if len(opts.NamespaceLabels) > 0 {
+		pw.namespaceInformer = pw.clusterInformers.ForResource(corev1.SchemeGroupVersion.WithResource("namespaces")).
+			Informer()
+	}
+
+	return pw
 }
 
 func (pw *PodWatcher) Run(ctx context.Context) error {
-	informers := informers.NewSharedInformerFactory(pw.client, 10*time.Minute)
-	podInformer := informers.Core().V1().Pods().Informer()
-	podInformer.AddEventHandler(
+	if pw.opts.NodeName == "" {
+		logrus.Warn("No node name, watching the pods of the whole cluster")
+	}
+
+	// The informers cache the kept metadata only
+	if err := pw.podInformer.SetTransform(pw.transform); err != nil {
+		return err
+	}
+	synced := []cache.InformerSynced{pw.podInformer.HasSynced}
+	if pw.namespaceInformer != nil {
+		if err := pw.namespaceInformer.SetTransform(pw.transformNamespace); err != nil {
+			return err
+		}
+		synced = append(synced, pw.namespaceInformer.HasSynced)
+	}
+
+	_, err := pw.podInformer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    pw.addPod,
 			UpdateFunc: pw.updatePod,
 			DeleteFunc: pw.deletePod,
 		},
 	)
-	informers.Start(ctx.Done())
+	if err != nil {
+		return err
+	}
+	pw.informers.Start(ctx.Done())
+	pw.clusterInformers.Start(ctx.Done())
+	if pw.queue != nil {
+		go pw.runWorkloads(ctx)
+	}
 
-	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
 		logrus.Warn("timed out waiting for caches to sync. Starting informers")
 	}
 	<-ctx.Done()
 	return nil
 }
 
+func (pw *PodWatcher) tweakListOptions(options *metav1.ListOptions) {
+	if pw.opts.NodeName != "" {
+		options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", pw.opts.NodeName).String()
+	}
+}
+
+// transform drops the metadata of the pod, that is not kept.
+func (pw *PodWatcher) transform(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		// A tombstone of a deleted pod
+		return obj, nil
+	}
+
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+			Labels:          keep(pod.Labels, pw.opts.Labels),
+			Annotations:     keep(pod.Annotations, pw.opts.Annotations),
+			OwnerReferences: controllerOf(pod, pw.opts.Workloads),
+		},
+	}, nil
+}
+
+func keep(m map[string]string, keys []string) map[string]string {
+	var kept map[string]string
+	for _, key := range keys {
+		if val, exists := m[key]; exists {
+			if kept == nil {
+				kept = make(map[string]string, len(keys))
+			}
+			kept[key] = val
+		}
+	}
+	return kept
+}
+
 func namespacedName(meta *metav1.ObjectMeta) string {
 	return meta.Namespace + "/" + meta.Name
 }
 
 func (pw *PodWatcher) addPod(obj interface{}) {
-	pod := obj.(*corev1.Pod)
+	pod, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return
+	}
+	key := namespacedName(&pod.ObjectMeta)
 	pw.mu.Lock()
-	pw.m[namespacedName(&pod.ObjectMeta)] = &pod.ObjectMeta
+	pw.m[key] = &pod.ObjectMeta
 	pw.mu.Unlock()
+
+	// The workloads are resolved again on every update, including the resyncs
+	if pw.queue != nil && len(pod.OwnerReferences) > 0 {
+		pw.queue.Add(key)
+	}
 }
 
 func (pw *PodWatcher) updatePod(_, newObj interface{}) {
-	pod := newObj.(*corev1.Pod)
-	pw.mu.Lock()
-	pw.m[namespacedName(&pod.ObjectMeta)] = &pod.ObjectMeta
-	pw.mu.Unlock()
+	pw.addPod(newObj)
 }
 
 func (pw *PodWatcher) deletePod(obj interface{}) {
-	pod := obj.(*corev1.Pod)
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return
+	}
+	key := namespacedName(&pod.ObjectMeta)
 	pw.mu.Lock()
-	delete(pw.m, namespacedName(&pod.ObjectMeta))
+	delete(pw.m, key)
+	delete(pw.workloads, key)
 	pw.mu.Unlock()
 }
 
+// GetObjectMeta returns the metadata of the pod, or nil, if the pod is unknown or the watcher is nil.
 func (pw *PodWatcher) GetObjectMeta(namespace, name string) *metav1.ObjectMeta {
+	if pw == nil {
+		return nil
+	}
+
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
 	return pw.m[namespace+"/"+name]
 }
+
+// transformNamespace drops the metadata of the namespace, that is not kept.
+func (pw *PodWatcher) transformNamespace(obj interface{}) (interface{}, error) {
+	namespace, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return obj, nil
+	}
+
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: namespace.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            namespace.Name,
+			UID:             namespace.UID,
+			ResourceVersion: namespace.ResourceVersion,
+			Labels:          keep(namespace.Labels, pw.opts.NamespaceLabels),
+		},
+	}, nil
+}
+
+// GetNamespaceLabels returns the kept labels of the namespace, or nil, if the namespace is unknown,
+// the namespaces are not watched or the watcher is nil.
+func (pw *PodWatcher) GetNamespaceLabels(namespace string) map[string]string {
+	if pw == nil || pw.namespaceInformer == nil {
+		return nil
+	}
+
+	obj, exists, err := pw.namespaceInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return nil
+	}
+	if meta, ok := obj.(*metav1.PartialObjectMetadata); ok {
+		return meta.Labels
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package podwatcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newPod(namespace, name string, labels, annotations map[string]string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate},
+			},
+		},
+	}
+}
+
+func TestPodWatcher(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		newPod("default", "pod-a",
+			map[string]string{"team": "ml", "pod-template-hash": "5d4f8"},
+			map[string]string{"example.com/cost-center": "cc-42", "kubectl.kubernetes.io/last-applied-configuration": "{}"}),
+	)
+
+	var (
+		mtx           sync.Mutex
+		fieldSelector string
+	)
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		fieldSelector = action.(k8stesting.ListAction).GetListRestrictions().Fields.String()
+		return false, nil, nil
+	})
+
+	pw := NewWithClient(client, Options{
+		NodeName:    "node-0",
+		Labels:      []string{"team", "missing"},
+		Annotations: []string{"example.com/cost-center"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, pw.Run(ctx))
+	}()
+
+	require.Eventually(t, func() bool {
+		return pw.GetObjectMeta("default", "pod-a") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Only the pods of the node are listed, and only the labels and annotations to keep are kept
+	mtx.Lock()
+	assert.Equal(t, "spec.nodeName=node-0", fieldSelector)
+	mtx.Unlock()
+
+	meta := pw.GetObjectMeta("default", "pod-a")
+	assert.Equal(t, map[string]string{"team": "ml"}, meta.Labels)
+	assert.Equal(t, map[string]string{"example.com/cost-center": "cc-42"}, meta.Annotations)
+	assert.Empty(t, meta.ManagedFields)
+	assert.Nil(t, pw.GetObjectMeta("default", "pod-b"))
+
+	var nilWatcher *PodWatcher
+	assert.Nil(t, nilWatcher.GetObjectMeta("default", "pod-a"))
+}
+
+func TestPodWatcher_NamespaceLabels(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+
+	client := metadatafake.NewSimpleMetadataClient(scheme, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "ml",
+			Labels: map[string]string{"cost-center": "cc-7", "kubernetes.io/metadata.name": "ml"},
+		},
+	})
+
+	pw := NewWithClient(client, Options{NamespaceLabels: []string{"cost-center"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, pw.Run(ctx))
+	}()
+
+	require.Eventually(t, func() bool {
+		return pw.GetNamespaceLabels("ml") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Only the labels to keep are kept
+	assert.Equal(t, map[string]string{"cost-center": "cc-7"}, pw.GetNamespaceLabels("ml"))
+	assert.Nil(t, pw.GetNamespaceLabels("default"))
+
+	// The namespaces are not watched without labels to keep
+	assert.Nil(t, NewWithClient(client, Options{}).GetNamespaceLabels("ml"))
+
+	var nilWatcher *PodWatcher
+	assert.Nil(t, nilWatcher.GetNamespaceLabels("ml"))
+}
+
+func TestPodWatcher_DeletePod(t *testing.T) {
+	pw := NewWithClient(nil, Options{})
+	pod := newPod("default", "pod-a", nil, nil)
+
+	pw.addPod(pod)
+	require.NotNil(t, pw.GetObjectMeta("default", "pod-a"))
+
+	// The deletion may be missed, while the watch is down
+	pw.deletePod(cache.DeletedFinalStateUnknown{Key: "default/pod-a", Obj: pod})
+	assert.Nil(t, pw.GetObjectMeta("default", "pod-a"))
+}
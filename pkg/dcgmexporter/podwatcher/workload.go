@@ -0,0 +1,224 @@
+package podwatcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// maxOwnerDepth bounds the walk up the controllers, in case of a cycle of ownerReferences.
+	maxOwnerDepth = 8
+	// ownerTTL is how long the controllers are cached, a controller of a pod rarely changes
+	ownerTTL = 10 * time.Minute
+	// ownerErrorTTL is how long the failures to get a controller are cached
+	ownerErrorTTL = time.Minute
+	// ownerTimeout bounds the get of a controller
+	ownerTimeout = 5 * time.Second
+)
+
+// builtinWorkloadKinds are the controllers of the pods, which are themselves controlled by a workload:
+// the ReplicaSets by Deployments and the Jobs by CronJobs. The StatefulSets, the DaemonSets
+// and the standalone ReplicaSets and Jobs are the workloads of their pods.
+func builtinWorkloadKinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{
+		appsv1.SchemeGroupVersion.WithKind("ReplicaSet"),
+		batchv1.SchemeGroupVersion.WithKind("Job"),
+	}
+}
+
+// ParseWorkloadKinds parses kinds of the form <apiVersion>/<kind>, e.g. kubeflow.org/v1/PyTorchJob.
+func ParseWorkloadKinds(kinds []string) ([]schema.GroupVersionKind, error) {
+	var gvks []schema.GroupVersionKind
+	for _, s := range kinds {
+		i := strings.LastIndex(s, "/")
+		if i <= 0 || i == len(s)-1 {
+			return nil, fmt.Errorf("invalid workload kind %q, expected <apiVersion>/<kind>", s)
+		}
+		gv, err := schema.ParseGroupVersion(s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid workload kind %q; err: %w", s, err)
+		}
+		gvks = append(gvks, gv.WithKind(s[i+1:]))
+	}
+	return gvks, nil
+}
+
+// controllerOf returns the controller reference of the object only, or nil, if the workloads are not resolved.
+func controllerOf(obj *metav1.PartialObjectMetadata, workloads bool) []metav1.OwnerReference {
+	if !workloads {
+		return nil
+	}
+	if ref := metav1.GetControllerOfNoCopy(obj); ref != nil {
+		return []metav1.OwnerReference{*ref}
+	}
+	return nil
+}
+
+// ownerKey identifies a controller in the owner cache.
+type ownerKey struct {
+	kind      schema.GroupKind
+	namespace string
+	name      string
+}
+
+// ownerEntry is the controller reference of a cached controller, nil if it has none or it does not exist.
+// failed is set, when the controller could not be got.
+type ownerEntry struct {
+	controller *metav1.OwnerReference
+	failed     bool
+	expires    time.Time
+}
+
+// workloadRef is the resolved workload of a pod.
+type workloadRef struct {
+	kind string
+	name string
+}
+
+// getController returns the controller reference of the controller of the kind, or nil, if it has none.
+// The controllers are got on demand, and cached for ownerTTL, so that only the controllers of the watched pods
+// are transferred, instead of watching them in the whole cluster. The failures are cached for ownerErrorTTL,
+// and logged once per kind. ok is false, if the kind is not followed or the controller cannot be got.
+func (pw *PodWatcher) getController(
+	ctx context.Context, kind schema.GroupKind, namespace, name string,
+) (*metav1.OwnerReference, bool) {
+	resource, followed := pw.owners[kind]
+	if !followed {
+		return nil, false
+	}
+
+	key := ownerKey{kind: kind, namespace: namespace, name: name}
+	now := time.Now()
+	pw.mu.Lock()
+	entry, cached := pw.ownerCache[key]
+	pw.mu.Unlock()
+	if cached && now.Before(entry.expires) {
+		return entry.controller, !entry.failed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ownerTimeout)
+	defer cancel()
+	owner, err := pw.client.Resource(resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		// The missing controllers are cached as well, not to be got for every pod
+		entry = ownerEntry{expires: now.Add(pw.ownerTTL)}
+	case err != nil:
+		entry = ownerEntry{failed: true, expires: now.Add(pw.ownerErrorTTL)}
+		pw.warnOwnerError(kind, err)
+	default:
+		entry = ownerEntry{controller: metav1.GetControllerOf(owner), expires: now.Add(pw.ownerTTL)}
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for k, e := range pw.ownerCache {
+		if !now.Before(e.expires) {
+			delete(pw.ownerCache, k)
+		}
+	}
+	pw.ownerCache[key] = entry
+	return entry.controller, !entry.failed
+}
+
+// warnOwnerError logs the first failure to get a controller of the kind, e.g. for a lack of RBAC,
+// the next ones at debug level.
+func (pw *PodWatcher) warnOwnerError(kind schema.GroupKind, err error) {
+	pw.mu.Lock()
+	warned := pw.ownerErrors[kind]
+	pw.ownerErrors[kind] = true
+	pw.mu.Unlock()
+
+	entry := logrus.WithError(err).WithField("kind", kind.String())
+	if warned {
+		entry.Debug("Failed to get the controller of a pod")
+	} else {
+		entry.Warn("Failed to get the controller of a pod, its workload is the last controller resolved")
+	}
+}
+
+// runWorkloads resolves the workloads of the pods queued by the pod informer, until the context is done,
+// so that the controllers are never got on the collections.
+func (pw *PodWatcher) runWorkloads(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		pw.queue.ShutDown()
+	}()
+
+	for {
+		key, shutdown := pw.queue.Get()
+		if shutdown {
+			return
+		}
+		pw.resolveWorkload(ctx, key.(string))
+		pw.queue.Done(key)
+	}
+}
+
+// resolveWorkload walks up the controllers of the pod, and keeps its top-most known controller.
+func (pw *PodWatcher) resolveWorkload(ctx context.Context, key string) {
+	pw.mu.Lock()
+	pod := pw.m[key]
+	pw.mu.Unlock()
+	if pod == nil {
+		return
+	}
+	ref := metav1.GetControllerOfNoCopy(pod)
+	if ref == nil {
+		return
+	}
+
+	for i := 0; i < maxOwnerDepth; i++ {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			break
+		}
+		next, found := pw.getController(ctx, gv.WithKind(ref.Kind).GroupKind(), pod.Namespace, ref.Name)
+		if !found || next == nil {
+			break
+		}
+		ref = next
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	// The pod could have been deleted meanwhile
+	if _, exists := pw.m[key]; exists {
+		pw.workloads[key] = workloadRef{kind: ref.Kind, name: ref.Name}
+	}
+}
+
+// Workload returns the kind and the name of the workload of the pod, i.e. its top-most known controller,
+// e.g. the Deployment of the ReplicaSet of the pod. A pod without a controller is its own workload.
+// The workloads are resolved in the background, until then the workload is the controller of the pod.
+// ok is false, if the pod is unknown, the workloads are not resolved or the watcher is nil.
+func (pw *PodWatcher) Workload(namespace, name string) (kind, workload string, ok bool) {
+	if pw == nil || !pw.opts.Workloads {
+		return "", "", false
+	}
+
+	key := namespace + "/" + name
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	pod := pw.m[key]
+	if pod == nil {
+		return "", "", false
+	}
+	if resolved, exists := pw.workloads[key]; exists {
+		return resolved.kind, resolved.name, true
+	}
+	if ref := metav1.GetControllerOfNoCopy(pod); ref != nil {
+		return ref.Kind, ref.Name, true
+	}
+	return "Pod", pod.Name, true
+}
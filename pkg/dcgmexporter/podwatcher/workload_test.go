@@ -0,0 +1,221 @@
+package podwatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func newObject(apiVersion, kind, name string, controller *metav1.PartialObjectMetadata) *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+	}
+	if controller != nil {
+		obj.OwnerReferences = []metav1.OwnerReference{
+			// Owners, which are not the controller, are ignored
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+			{
+				APIVersion: controller.APIVersion,
+				Kind:       controller.Kind,
+				Name:       controller.Name,
+				Controller: ptr.To(true),
+			},
+		}
+	}
+	return obj
+}
+
+func TestPodWatcher_Workload(t *testing.T) {
+	deployment := newObject("apps/v1", "Deployment", "web", nil)
+	replicaSet := newObject("apps/v1", "ReplicaSet", "web-5d4f8", deployment)
+	standaloneReplicaSet := newObject("apps/v1", "ReplicaSet", "standalone", nil)
+	cronJob := newObject("batch/v1", "CronJob", "nightly", nil)
+	job := newObject("batch/v1", "Job", "nightly-28000000", cronJob)
+	statefulSet := newObject("apps/v1", "StatefulSet", "db", nil)
+	rayJob := newObject("ray.io/v1", "RayJob", "tune", nil)
+	rayCluster := newObject("ray.io/v1", "RayCluster", "tune-raycluster", rayJob)
+	pyTorchJob := newObject("kubeflow.org/v1", "PyTorchJob", "bert", nil)
+
+	objects := []runtime.Object{
+		replicaSet, standaloneReplicaSet, job, rayCluster,
+		newObject("v1", "Pod", "web-5d4f8-abcde", replicaSet),
+		newObject("v1", "Pod", "standalone-abcde", standaloneReplicaSet),
+		newObject("v1", "Pod", "nightly-28000000-abcde", job),
+		newObject("v1", "Pod", "db-0", statefulSet),
+		newObject("v1", "Pod", "tune-raycluster-worker-abcde", rayCluster),
+		newObject("v1", "Pod", "bert-worker-0", pyTorchJob),
+		newObject("v1", "Pod", "debug", nil),
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme, objects...)
+
+	kinds, err := ParseWorkloadKinds([]string{"ray.io/v1/RayCluster"})
+	require.NoError(t, err)
+	pw := NewWithClient(client, Options{Workloads: true, WorkloadKinds: kinds})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, pw.Run(ctx))
+	}()
+
+	require.Eventually(t, pw.podInformer.HasSynced, 5*time.Second, 10*time.Millisecond)
+
+	tests := []struct {
+		pod          string
+		expectedKind string
+		expectedName string
+	}{
+		{pod: "web-5d4f8-abcde", expectedKind: "Deployment", expectedName: "web"},
+		{pod: "standalone-abcde", expectedKind: "ReplicaSet", expectedName: "standalone"},
+		{pod: "nightly-28000000-abcde", expectedKind: "CronJob", expectedName: "nightly"},
+		{pod: "db-0", expectedKind: "StatefulSet", expectedName: "db"},
+		{pod: "tune-raycluster-worker-abcde", expectedKind: "RayJob", expectedName: "tune"},
+		// The PyTorchJobs are not watched, but control their pods
+		{pod: "bert-worker-0", expectedKind: "PyTorchJob", expectedName: "bert"},
+		{pod: "debug", expectedKind: "Pod", expectedName: "debug"},
+	}
+	// The workloads are resolved in the background
+	require.Eventually(t, func() bool {
+		kind, _, _ := pw.Workload("default", "tune-raycluster-worker-abcde")
+		return kind == "RayJob"
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, tt := range tests {
+		t.Run(tt.pod, func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				kind, name, ok := pw.Workload("default", tt.pod)
+				return ok && kind == tt.expectedKind && name == tt.expectedName
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+
+	_, _, ok := pw.Workload("default", "unknown")
+	assert.False(t, ok)
+
+	// The controllers are kept only
+	assert.Len(t, pw.GetObjectMeta("default", "web-5d4f8-abcde").OwnerReferences, 1)
+
+	var nilWatcher *PodWatcher
+	_, _, ok = nilWatcher.Workload("default", "debug")
+	assert.False(t, ok)
+}
+
+// countGets returns the number of gets of the resource by the client.
+func countGets(client *metadatafake.FakeMetadataClient, resource string) int {
+	var gets int
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == resource {
+			gets++
+		}
+	}
+	return gets
+}
+
+func TestPodWatcher_Workload_CachesControllers(t *testing.T) {
+	deployment := newObject("apps/v1", "Deployment", "web", nil)
+	replicaSet := newObject("apps/v1", "ReplicaSet", "web-5d4f8", deployment)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		replicaSet,
+		newObject("v1", "Pod", "web-5d4f8-abcde", replicaSet),
+		newObject("v1", "Pod", "web-5d4f8-fghij", replicaSet))
+	pw := NewWithClient(client, Options{Workloads: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, pw.Run(ctx))
+	}()
+	for _, pod := range []string{"web-5d4f8-abcde", "web-5d4f8-fghij"} {
+		require.Eventually(t, func() bool {
+			kind, name, _ := pw.Workload("default", pod)
+			return kind == "Deployment" && name == "web"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// The controllers are got once for all the pods, and neither listed nor watched
+	for i := 0; i < 3; i++ {
+		pw.Workload("default", "web-5d4f8-abcde")
+	}
+	assert.Equal(t, 1, countGets(client, "replicasets"))
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "replicasets" {
+			assert.Equal(t, "get", action.GetVerb())
+		}
+	}
+
+	// The expired controllers are got again
+	pw.mu.Lock()
+	for key, entry := range pw.ownerCache {
+		entry.expires = time.Now()
+		pw.ownerCache[key] = entry
+	}
+	pw.mu.Unlock()
+	pw.resolveWorkload(ctx, "default/web-5d4f8-abcde")
+	assert.Equal(t, 2, countGets(client, "replicasets"))
+}
+
+func TestPodWatcher_Workload_ControllerError(t *testing.T) {
+	deployment := newObject("apps/v1", "Deployment", "web", nil)
+	replicaSet := newObject("apps/v1", "ReplicaSet", "web-5d4f8", deployment)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, metav1.AddMetaToScheme(scheme))
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		replicaSet, newObject("v1", "Pod", "web-5d4f8-abcde", replicaSet))
+	// The service account is not granted to get the ReplicaSets
+	client.PrependReactor("get", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "replicasets"},
+			"web-5d4f8", errors.New("forbidden"))
+	})
+	pw := NewWithClient(client, Options{Workloads: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, pw.Run(ctx))
+	}()
+	require.Eventually(t, func() bool {
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		return len(pw.ownerCache) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The workload is the controller of the pod, and the failure is not retried within ownerErrorTTL
+	pw.resolveWorkload(ctx, "default/web-5d4f8-abcde")
+	kind, name, ok := pw.Workload("default", "web-5d4f8-abcde")
+	require.True(t, ok)
+	assert.Equal(t, "ReplicaSet", kind)
+	assert.Equal(t, "web-5d4f8", name)
+	assert.Equal(t, 1, countGets(client, "replicasets"))
+}
+
+func TestParseWorkloadKinds(t *testing.T) {
+	kinds, err := ParseWorkloadKinds([]string{"kubeflow.org/v1/PyTorchJob", "ray.io/v1/RayCluster"})
+	require.NoError(t, err)
+	assert.Equal(t, []schema.GroupVersionKind{
+		{Group: "kubeflow.org", Version: "v1", Kind: "PyTorchJob"},
+		{Group: "ray.io", Version: "v1", Kind: "RayCluster"},
+	}, kinds)
+
+	for _, invalid := range []string{"PyTorchJob", "kubeflow.org/v1/", "/PyTorchJob", "kubeflow.org/v1/beta/PyTorchJob"} {
+		_, err := ParseWorkloadKinds([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
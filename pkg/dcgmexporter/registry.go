@@ -18,6 +18,7 @@ package dcgmexporter
 
 import (
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -25,11 +26,17 @@ import (
 type Registry struct {
 	collectors []Collector
 	mtx        sync.RWMutex
+	status     *collectorStatusTracker
+	// last are the metrics of the last Gather
+	last MetricsByCounter
+	// limiter enforces the series limits of the pipeline, if set
+	limiter *seriesLimiter
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
 		collectors: make([]Collector, 0),
+		status:     newCollectorStatusTracker(),
 	}
 }
 
@@ -53,7 +60,9 @@ func (r *Registry) Gather() (MetricsByCounter, error) {
 	for _, c := range r.collectors {
 		c := c // creates new c, see https://golang.org/doc/faq#closures_and_goroutines
 		g.Go(func() error {
+			start := time.Now()
 			metrics, err := c.GetMetrics()
+			r.status.observe(collectorName(c), start, metrics, err)
 			if err != nil {
 				return err
 			}
@@ -79,10 +88,27 @@ func (r *Registry) Gather() (MetricsByCounter, error) {
 		output[key.(Counter)] = value.([]Metric)
 		return true // continue iteration
 	})
+	if r.limiter != nil {
+		r.limiter.LimitRegistry(output)
+	}
+	r.last = output
 
 	return output, nil
 }
 
+// CollectorStatus returns the outcome of the last collections of the registered collectors.
+func (r *Registry) CollectorStatus() []CollectorStatus {
+	return r.status.get()
+}
+
+// lastMetrics returns the metrics of the last Gather, or nil, if there was none.
+func (r *Registry) lastMetrics() MetricsByCounter {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	return r.last
+}
+
 // Cleanup resources of registered collectors
 func (r *Registry) Cleanup() {
 	for _, c := range r.collectors {
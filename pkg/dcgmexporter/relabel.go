@@ -0,0 +1,365 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type RelabelAction string
+
+const (
+	RelabelReplace   RelabelAction = "replace"
+	RelabelKeep      RelabelAction = "keep"
+	RelabelDrop      RelabelAction = "drop"
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	RelabelLabelKeep RelabelAction = "labelkeep"
+	RelabelLabelMap  RelabelAction = "labelmap"
+	RelabelRename    RelabelAction = "rename"
+)
+
+// metricNameLabel is the pseudo label holding the name of the metric, as in Prometheus.
+const metricNameLabel = "__name__"
+
+// RelabelConfig is a rule of the relabeling chain. It follows the Prometheus metric_relabel_configs:
+//   - replace: sets target_label to the replacement, if the regex matches the concatenated source_labels.
+//     With target_label "__name__" it renames the metric.
+//   - keep, drop: keeps or drops the series, where the regex matches the concatenated source_labels.
+//   - labeldrop, labelkeep: drops the labels, which names match, or do not match, the regex.
+//   - labelmap: copies the labels, which names match the regex, to the labels named by the replacement.
+//   - rename: like labelmap, but the original labels are removed.
+type RelabelConfig struct {
+	SourceLabels []string      `yaml:"source_labels"`
+	Separator    string        `yaml:"separator"`
+	Regex        string        `yaml:"regex"`
+	TargetLabel  string        `yaml:"target_label"`
+	Replacement  string        `yaml:"replacement"`
+	Action       RelabelAction `yaml:"action"`
+}
+
+// UnmarshalYAML sets the Prometheus defaults for the omitted fields.
+func (rc *RelabelConfig) UnmarshalYAML(value *yaml.Node) error {
+	*rc = RelabelConfig{
+		Separator:   ";",
+		Regex:       "(.*)",
+		Replacement: "$1",
+		Action:      RelabelReplace,
+	}
+	type plain RelabelConfig
+	return value.Decode((*plain)(rc))
+}
+
+type relabelRule struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+func newRelabelRule(rc RelabelConfig) (relabelRule, error) {
+	// As in Prometheus, the regex is fully anchored
+	regex, err := regexp.Compile("^(?:" + rc.Regex + ")$")
+	if err != nil {
+		return relabelRule{}, fmt.Errorf("invalid regex '%s'; err: %w", rc.Regex, err)
+	}
+
+	switch rc.Action {
+	case RelabelReplace:
+		if rc.TargetLabel == "" {
+			return relabelRule{}, fmt.Errorf("action '%s' requires target_label", rc.Action)
+		}
+		// The target labels with references are validated once expanded
+		if !strings.Contains(rc.TargetLabel, "$") && !validTargetLabel(rc.TargetLabel) {
+			return relabelRule{}, fmt.Errorf("invalid target_label '%s'", rc.TargetLabel)
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(rc.SourceLabels) == 0 {
+			return relabelRule{}, fmt.Errorf("action '%s' requires source_labels", rc.Action)
+		}
+	case RelabelLabelDrop, RelabelLabelKeep, RelabelLabelMap, RelabelRename:
+	default:
+		return relabelRule{}, fmt.Errorf("unknown relabel action '%s'", rc.Action)
+	}
+
+	return relabelRule{RelabelConfig: rc, regex: regex}, nil
+}
+
+// validTargetLabel returns whether the label can be set by a rule, i.e. it is a valid label name, or the metric name.
+func validTargetLabel(name string) bool {
+	return name == metricNameLabel || labelNameRegex.MatchString(name)
+}
+
+// apply applies the rule to the labels. It returns false, when the series must be dropped.
+func (r relabelRule) apply(labels map[string]string) bool {
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, r.Separator)
+
+	switch r.Action {
+	case RelabelReplace:
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		target := string(r.regex.ExpandString(nil, r.TargetLabel, value, match))
+		replacement := string(r.regex.ExpandString(nil, r.Replacement, value, match))
+		// As in Prometheus, the rule is skipped, when the expanded label name is invalid
+		if !validTargetLabel(target) {
+			return true
+		}
+		if target == metricNameLabel && replacement != "" && !metricNameRegex.MatchString(replacement) {
+			return true
+		}
+		if replacement == "" {
+			delete(labels, target)
+		} else {
+			labels[target] = replacement
+		}
+	case RelabelKeep:
+		return r.regex.MatchString(value)
+	case RelabelDrop:
+		return !r.regex.MatchString(value)
+	case RelabelLabelDrop, RelabelLabelKeep:
+		for name := range labels {
+			if name == metricNameLabel {
+				continue
+			}
+			if r.regex.MatchString(name) == (r.Action == RelabelLabelDrop) {
+				delete(labels, name)
+			}
+		}
+	case RelabelLabelMap, RelabelRename:
+		renamed := map[string]string{}
+		for name, v := range labels {
+			if name == metricNameLabel || !r.regex.MatchString(name) {
+				continue
+			}
+			// The labels mapped to invalid names are left as they are
+			newName := r.regex.ReplaceAllString(name, r.Replacement)
+			if !labelNameRegex.MatchString(newName) {
+				continue
+			}
+			renamed[newName] = v
+			if r.Action == RelabelRename {
+				delete(labels, name)
+			}
+		}
+		for name, v := range renamed {
+			labels[name] = v
+		}
+	}
+
+	return true
+}
+
+// metricLabelField is a label, that is rendered from a field of the Metric rather than from its Labels.
+type metricLabelField struct {
+	name  string
+	field func(m *Metric) *string
+}
+
+func gpuField(m *Metric) *string       { return &m.GPU }
+func gpuDeviceField(m *Metric) *string { return &m.GPUDevice }
+func hostnameLabelField() metricLabelField {
+	return metricLabelField{"Hostname", func(m *Metric) *string { return &m.Hostname }}
+}
+
+// metricLabelFields returns the labels, that the encoder of the entity type renders from the fields of the Metric.
+func metricLabelFields(entityType dcgm.Field_Entity_Group, m *Metric) []metricLabelField {
+	switch entityType {
+	case dcgm.FE_SWITCH:
+		return []metricLabelField{{"nvswitch", gpuField}, hostnameLabelField()}
+	case dcgm.FE_LINK:
+		return []metricLabelField{{"nvlink", gpuField}, {"nvswitch", gpuDeviceField}, hostnameLabelField()}
+	case dcgm.FE_CPU:
+		return []metricLabelField{{"cpu", gpuField}, hostnameLabelField()}
+	case dcgm.FE_CPU_CORE:
+		return []metricLabelField{{"cpucore", gpuField}, {"cpu", gpuDeviceField}, hostnameLabelField()}
+	}
+
+	uuidLabel := m.UUID
+	if uuidLabel == "" {
+		uuidLabel = "UUID"
+	}
+
+	return []metricLabelField{
+		{"gpu", gpuField},
+		{uuidLabel, func(m *Metric) *string { return &m.GPUUUID }},
+		{"pci_bus_id", func(m *Metric) *string { return &m.GPUPCIBusID }},
+		{"device", gpuDeviceField},
+		{"modelName", func(m *Metric) *string { return &m.GPUModelName }},
+		{"GPU_I_PROFILE", func(m *Metric) *string { return &m.MigProfile }},
+		{"GPU_I_ID", func(m *Metric) *string { return &m.GPUInstanceID }},
+		hostnameLabelField(),
+	}
+}
+
+// relabeler applies the static labels and the relabel rules of the exporter configuration.
+// It runs on the metrics of every entity type, and before they are observed by OpenTelemetry,
+// so the Prometheus output, the Registry collectors and OTLP see the same series.
+type relabeler struct {
+	staticLabels map[string]string
+	rules        []relabelRule
+
+	// collisions are the metric names, which collisions were logged
+	mtx        sync.Mutex
+	collisions map[string]bool
+}
+
+func newRelabeler(c *Config) (*relabeler, error) {
+	r := &relabeler{staticLabels: c.StaticLabels, collisions: map[string]bool{}}
+
+	for i, rc := range c.MetricRelabelConfigs {
+		rule, err := newRelabelRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel config #%d; err: %w", i, err)
+		}
+		r.rules = append(r.rules, rule)
+	}
+
+	return r, nil
+}
+
+// getRelabeler returns the relabeler of the configuration, or nil when there is nothing to relabel.
+func getRelabeler(c *Config) *relabeler {
+	if len(c.StaticLabels) == 0 && len(c.MetricRelabelConfigs) == 0 {
+		return nil
+	}
+
+	r, err := newRelabeler(c)
+	if err != nil {
+		logrus.Warnf("Could not enable relabeling: %v", err)
+		return nil
+	}
+
+	return r
+}
+
+func (r *relabeler) Name() string {
+	return "relabeler"
+}
+
+func (r *relabeler) Process(metrics MetricsByCounter, sysInfo SystemInfo) error {
+	relabeled := make(MetricsByCounter, len(metrics))
+	renamed := map[Counter]bool{}
+
+	for counter, metricVals := range metrics {
+		for _, metricVal := range metricVals {
+			newMetric, keep := r.relabel(sysInfo.InfoType, counter, metricVal)
+			if keep {
+				relabeled[newMetric.Counter] = append(relabeled[newMetric.Counter], newMetric)
+				if newMetric.Counter.FieldName != counter.FieldName {
+					renamed[newMetric.Counter] = true
+				}
+			}
+		}
+	}
+
+	// A counter renamed onto the name of another counter would be exposed twice, with different HELP and TYPE,
+	// the series of the renamed counter are dropped instead
+	byName := make(map[string][]Counter, len(relabeled))
+	for counter := range relabeled {
+		byName[counter.FieldName] = append(byName[counter.FieldName], counter)
+	}
+	for name, counters := range byName {
+		if len(counters) < 2 {
+			continue
+		}
+		for _, counter := range counters {
+			if renamed[counter] {
+				delete(relabeled, counter)
+				r.logCollision(name)
+			}
+		}
+	}
+
+	clear(metrics)
+	for counter, metricVals := range relabeled {
+		metrics[counter] = metricVals
+	}
+
+	return nil
+}
+
+// logCollision warns once per metric name, that counters were renamed onto it.
+func (r *relabeler) logCollision(name string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.collisions[name] {
+		r.collisions[name] = true
+		logrus.Warnf("Dropping the series renamed to '%s' by the relabel rules, another counter has this name", name)
+	}
+}
+
+func (r *relabeler) relabel(entityType dcgm.Field_Entity_Group, counter Counter, m Metric) (Metric, bool) {
+	fields := metricLabelFields(entityType, &m)
+
+	labels := make(map[string]string, len(fields)+len(m.Labels)+len(m.Attributes)+len(r.staticLabels)+1)
+	labels[metricNameLabel] = counter.FieldName
+	for _, f := range fields {
+		if v := *f.field(&m); v != "" {
+			labels[f.name] = v
+		}
+	}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	for k, v := range m.Attributes {
+		labels[k] = v
+	}
+	for k, v := range r.staticLabels {
+		labels[k] = v
+	}
+
+	for _, rule := range r.rules {
+		if !rule.apply(labels) {
+			return m, false
+		}
+	}
+
+	newCounter := counter
+	if name := labels[metricNameLabel]; name != "" {
+		newCounter.FieldName = name
+	}
+	delete(labels, metricNameLabel)
+	m.Counter = newCounter
+
+	for _, f := range fields {
+		*f.field(&m) = labels[f.name]
+		delete(labels, f.name)
+	}
+
+	// The labels, that came from the attributes, remain attributes, the rest become labels
+	attributes := make(map[string]string, len(m.Attributes))
+	for k := range m.Attributes {
+		if v, exists := labels[k]; exists {
+			attributes[k] = v
+			delete(labels, k)
+		}
+	}
+	m.Labels = labels
+	m.Attributes = attributes
+
+	return m, true
+}
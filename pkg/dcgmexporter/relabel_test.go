@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRelabelTestMetrics() (Counter, MetricsByCounter) {
+	counter := Counter{FieldID: dcgm.DCGM_FI_DEV_GPU_UTIL, FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "gauge"}
+	newMetric := func(gpu string) Metric {
+		return Metric{
+			Counter:      counter,
+			Value:        "42",
+			GPU:          gpu,
+			UUID:         "UUID",
+			GPUUUID:      "GPU-" + gpu,
+			GPUDevice:    "nvidia" + gpu,
+			GPUModelName: "NVIDIA A100-SXM4-80GB",
+			GPUPCIBusID:  "00000000:0" + gpu + ":00.0",
+			Hostname:     "node-1",
+			Labels:       map[string]string{"DCGM_FI_DRIVER_VERSION": "550.54"},
+			Attributes:   map[string]string{"pod": "pod-" + gpu, "namespace": "default"},
+		}
+	}
+	return counter, MetricsByCounter{counter: {newMetric("0"), newMetric("1")}}
+}
+
+func TestRelabeler(t *testing.T) {
+	_, metrics := newRelabelTestMetrics()
+
+	r, err := newRelabeler(&Config{
+		StaticLabels: map[string]string{"cluster": "eu-1"},
+		MetricRelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"pod"}, Separator: ";", Regex: "pod-1", Action: RelabelDrop},
+			{Regex: "Hostname|pci_bus_id|modelName", Action: RelabelLabelDrop},
+			{Regex: "DCGM_FI_(.+)", Replacement: "dcgm_$1", Action: RelabelRename},
+			{SourceLabels: []string{"__name__"}, Separator: ";", Regex: "DCGM_FI_DEV_(.+)", TargetLabel: "__name__", Replacement: "gpu_$1", Action: RelabelReplace},
+			{SourceLabels: []string{"gpu", "namespace"}, Separator: "/", Regex: "(.*)", TargetLabel: "slot", Replacement: "$1", Action: RelabelReplace},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{InfoType: dcgm.FE_GPU}))
+
+	require.Len(t, metrics, 1)
+	for counter, metricVals := range metrics {
+		assert.Equal(t, "gpu_GPU_UTIL", counter.FieldName)
+		assert.Equal(t, "gauge", counter.PromType)
+		require.Len(t, metricVals, 1)
+
+		m := metricVals[0]
+		assert.Equal(t, counter, m.Counter)
+		assert.Equal(t, "0", m.GPU)
+		assert.Equal(t, "GPU-0", m.GPUUUID)
+		assert.Equal(t, "nvidia0", m.GPUDevice)
+		assert.Empty(t, m.Hostname)
+		assert.Empty(t, m.GPUPCIBusID)
+		assert.Empty(t, m.GPUModelName)
+		assert.Equal(t, map[string]string{"dcgm_DRIVER_VERSION": "550.54", "cluster": "eu-1", "slot": "0/default"}, m.Labels)
+		assert.Equal(t, map[string]string{"pod": "pod-0", "namespace": "default"}, m.Attributes)
+
+		out := encodeMetrics(dcgm.FE_GPU, metrics)
+		assert.NotContains(t, out, "pci_bus_id")
+		assert.NotContains(t, out, "modelName")
+		assert.True(t, strings.Contains(out, `gpu_GPU_UTIL{gpu="0",UUID="GPU-0",device="nvidia0",`), out)
+	}
+}
+
+func TestRelabelerKeep(t *testing.T) {
+	_, metrics := newRelabelTestMetrics()
+
+	r, err := newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"__name__", "gpu"}, Separator: ";", Regex: "DCGM_FI_DEV_GPU_UTIL;1", Action: RelabelKeep},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{}))
+
+	require.Len(t, metrics, 1)
+	for _, metricVals := range metrics {
+		require.Len(t, metricVals, 1)
+		assert.Equal(t, "1", metricVals[0].GPU)
+	}
+
+	r, err = newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"gpu"}, Separator: ";", Regex: ".*", Action: RelabelDrop},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{}))
+	assert.Empty(t, metrics, "counters without series are expected to be removed")
+}
+
+func TestRelabelerEntityLabels(t *testing.T) {
+	counter := Counter{FieldName: "DCGM_FI_DEV_NVSWITCH_LINK_THROUGHPUT_TX", PromType: "gauge"}
+	metrics := MetricsByCounter{
+		counter: {{Counter: counter, Value: "1", GPU: "3", GPUDevice: "0", Labels: map[string]string{}}},
+	}
+
+	r, err := newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"nvlink"}, Separator: ";", Regex: "(.*)", TargetLabel: "link", Replacement: "$1", Action: RelabelReplace},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{InfoType: dcgm.FE_LINK}))
+
+	assert.Equal(t, map[string]string{"link": "3"}, metrics[counter][0].Labels)
+	assert.Equal(t, "0", metrics[counter][0].GPUDevice)
+}
+
+func TestRelabelerInvalidNames(t *testing.T) {
+	_, err := newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			{Regex: "(.*)", TargetLabel: "bad-label", Replacement: "$1", Action: RelabelReplace},
+		},
+	})
+	assert.Error(t, err)
+
+	_, metrics := newRelabelTestMetrics()
+	r, err := newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			// The expanded target labels and metric names are invalid
+			{SourceLabels: []string{"device"}, Separator: ";", Regex: "(.*)", TargetLabel: "${1}-x", Replacement: "x", Action: RelabelReplace},
+			{SourceLabels: []string{"device"}, Separator: ";", Regex: "(.*)", TargetLabel: "__name__", Replacement: "${1}-x", Action: RelabelReplace},
+			{Regex: "DCGM_FI_(.+)", Replacement: "dcgm-$1", Action: RelabelLabelMap},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{InfoType: dcgm.FE_GPU}))
+
+	for counter, metricVals := range metrics {
+		assert.Equal(t, "DCGM_FI_DEV_GPU_UTIL", counter.FieldName)
+		for _, m := range metricVals {
+			assert.Equal(t, map[string]string{"DCGM_FI_DRIVER_VERSION": "550.54"}, m.Labels)
+		}
+	}
+}
+
+func TestRelabelerRenameCollision(t *testing.T) {
+	counter, metrics := newRelabelTestMetrics()
+	temp := Counter{FieldID: dcgm.DCGM_FI_DEV_GPU_TEMP, FieldName: "DCGM_FI_DEV_GPU_TEMP", PromType: "gauge"}
+	metrics[temp] = []Metric{{Counter: temp, Value: "60", GPU: "0", Labels: map[string]string{}}}
+
+	r, err := newRelabeler(&Config{
+		MetricRelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"__name__"}, Separator: ";", Regex: "DCGM_FI_DEV_GPU_UTIL", TargetLabel: "__name__", Replacement: "DCGM_FI_DEV_GPU_TEMP", Action: RelabelReplace},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Process(metrics, SystemInfo{InfoType: dcgm.FE_GPU}))
+
+	// The renamed counter is dropped, rather than exposed with a second HELP and TYPE
+	assert.Equal(t, MetricsByCounter{temp: metrics[temp]}, metrics)
+	assert.NotContains(t, metrics, counter)
+	assert.Equal(t, 1, strings.Count(encodeMetrics(dcgm.FE_GPU, metrics), "# TYPE DCGM_FI_DEV_GPU_TEMP"))
+}
+
+func TestParseExporterConfigRelabeling(t *testing.T) {
+	config, err := parseExporterConfig(strings.NewReader(`
+staticLabels:
+  cluster: eu-1
+metricRelabelConfigs:
+  - source_labels: [modelName]
+    target_label: model
+  - regex: Hostname
+    action: labeldrop
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "eu-1"}, config.StaticLabels)
+	assert.Equal(t, []RelabelConfig{
+		{SourceLabels: []string{"modelName"}, Separator: ";", Regex: "(.*)", TargetLabel: "model", Replacement: "$1", Action: RelabelReplace},
+		{Separator: ";", Regex: "Hostname", Replacement: "$1", Action: RelabelLabelDrop},
+	}, config.MetricRelabelConfigs)
+
+	for name, content := range map[string]string{
+		"invalid static label": "staticLabels: {'my-cluster': a}",
+		"invalid regex":        "metricRelabelConfigs: [{regex: '(', action: labeldrop}]",
+		"unknown action":       "metricRelabelConfigs: [{action: hashmod}]",
+		"no target label":      "metricRelabelConfigs: [{source_labels: [gpu]}]",
+		"no source labels":     "metricRelabelConfigs: [{action: drop}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseExporterConfig(strings.NewReader(content))
+			assert.Error(t, err)
+		})
+	}
+}
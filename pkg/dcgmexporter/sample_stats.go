@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/sirupsen/logrus"
+)
+
+// entityField identifies the samples of a single field of a single entity.
+type entityField struct {
+	entity  dcgm.GroupEntityPair
+	fieldID uint
+}
+
+// sampleHistory reads all DCGM samples of the watched fields, that were taken since the previous read.
+type sampleHistory struct {
+	groups     []dcgm.GroupHandle
+	fieldGroup dcgm.FieldHandle
+	since      time.Time
+}
+
+// collectOnScrapeSampleKeepAge is the minimum age of the samples kept with CollectOnScrape, where the collections
+// are as far apart as the scrapes, which are not known in advance.
+const collectOnScrapeSampleKeepAge = 5 * time.Minute
+
+// newSampleHistory watches the device fields of the entities, whose counters need the sample history, every
+// sampleInterval, and keeps the samples long enough, so that no sample is lost between two collections.
+func newSampleHistory(
+	counters []Counter, deviceFields []dcgm.Short, sysInfo SystemInfo, config *Config,
+) (*sampleHistory, []func(), error) {
+	var fields []dcgm.Short
+	for _, counter := range counters {
+		// The fields of the other entity types are not watched for these entities
+		if counter.sampleHistory() && slices.Contains(deviceFields, counter.FieldID) &&
+			!slices.Contains(fields, counter.FieldID) {
+			fields = append(fields, counter.FieldID)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+
+	maxKeepAge := sampleKeepAge(config)
+
+	groups, fieldGroup, cleanups, err := SetupDcgmFieldsWatchWithHistory(fields,
+		sysInfo,
+		int64(config.SampleInterval)*1000,
+		maxKeepAge.Seconds(),
+		0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logrus.Infof("Sample history is enabled for %d fields of %s entities", len(fields), sysInfo.InfoType)
+
+	return &sampleHistory{
+		groups:     groups,
+		fieldGroup: fieldGroup,
+		since:      time.Now(),
+	}, cleanups, nil
+}
+
+// sampleKeepAge returns how long DCGM keeps the samples: two collect intervals, or, when collecting on scrape,
+// two min collect intervals, but at least collectOnScrapeSampleKeepAge.
+func sampleKeepAge(config *Config) time.Duration {
+	if !config.CollectOnScrape {
+		return 2 * time.Duration(config.CollectInterval) * time.Millisecond
+	}
+	return max(2*time.Duration(config.MinCollectInterval)*time.Millisecond, collectOnScrapeSampleKeepAge)
+}
+
+// read returns the values of the samples since the previous read grouped by entity and field.
+// Blank values are skipped.
+func (h *sampleHistory) read() (map[entityField][]float64, error) {
+	samples := make(map[entityField][]float64)
+	next := h.since
+
+	for _, group := range h.groups {
+		values, nextSince, err := dcgm.GetValuesSince(group, h.fieldGroup, h.since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sample history; err: %w", err)
+		}
+
+		if nextSince.After(next) {
+			next = nextSince
+		}
+
+		for _, val := range values {
+			if val.Status != 0 {
+				continue
+			}
+
+			v := ToString(dcgm.FieldValue_v1{
+				FieldId:   val.FieldId,
+				FieldType: val.FieldType,
+				Status:    val.Status,
+				Ts:        val.Ts,
+				Value:     val.Value,
+			})
+			if v == SkipDCGMValue || v == FailedToConvert {
+				continue
+			}
+
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+
+			key := entityField{
+				entity:  dcgm.GroupEntityPair{EntityGroupId: val.EntityGroupId, EntityId: val.EntityId},
+				fieldID: val.FieldId,
+			}
+			samples[key] = append(samples[key], f)
+		}
+	}
+
+	h.since = next
+
+	return samples, nil
+}
+
+// sampleStats are the statistics of the samples of a single series.
+type sampleStats struct {
+	Min       float64
+	Max       float64
+	Mean      float64
+	Quantiles []float64
+}
+
+// computeSampleStats computes the statistics of non-empty samples.
+// The quantiles are estimated by linear interpolation between the closest ranks.
+func computeSampleStats(samples []float64, quantiles []float64) sampleStats {
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+
+	stats := sampleStats{
+		Min:       sorted[0],
+		Max:       sorted[len(sorted)-1],
+		Mean:      sum / float64(len(sorted)),
+		Quantiles: make([]float64, len(quantiles)),
+	}
+
+	for i, q := range quantiles {
+		rank := q * float64(len(sorted)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		stats.Quantiles[i] = sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+	}
+
+	return stats
+}
+
+// sampleStatsCounters returns the counters of the <FIELD>_MIN, <FIELD>_MAX, <FIELD>_MEAN
+// and <FIELD>_QUANTILE series in this order.
+func sampleStatsCounters(counter Counter) []Counter {
+	newCounter := func(suffix, help string) Counter {
+		c := counter
+		c.FieldName += suffix
+		c.PromType = "gauge"
+		c.Help = fmt.Sprintf("%s %s of the samples since the previous collection.", counter.Help, help)
+		c.Options = nil
+		return c
+	}
+
+	return []Counter{
+		newCounter(sampleMinSuffix, "Minimum"),
+		newCounter(sampleMaxSuffix, "Maximum"),
+		newCounter(sampleMeanSuffix, "Mean"),
+		newCounter(sampleQuantileSuffix, "Quantiles"),
+	}
+}
+
+// appendSampleStats appends the statistics series of every counter with the "stats" option.
+// The series copy the labels of the metric of the latest value, that was collected for the same entity.
+func appendSampleStats(metrics MetricsByCounter, entity dcgm.GroupEntityPair, samples map[entityField][]float64) {
+	var statsCounters []Counter
+	for counter := range metrics {
+		if counter.sampleStats() {
+			statsCounters = append(statsCounters, counter)
+		}
+	}
+
+	for _, counter := range statsCounters {
+		metricVals := metrics[counter]
+		if len(metricVals) == 0 {
+			continue
+		}
+
+		values := samples[entityField{entity: entity, fieldID: uint(counter.FieldID)}]
+		if len(values) == 0 {
+			continue
+		}
+
+		stats := computeSampleStats(values, counter.quantiles())
+		derived := sampleStatsCounters(counter)
+
+		newMetric := func(c Counter, value float64) Metric {
+			m := metricVals[0]
+			m.Counter = c
+			m.Value = strconv.FormatFloat(value, 'f', -1, 64)
+			m.Samples = nil
+			return m
+		}
+
+		metrics[derived[0]] = append(metrics[derived[0]], newMetric(derived[0], stats.Min))
+		metrics[derived[1]] = append(metrics[derived[1]], newMetric(derived[1], stats.Max))
+		metrics[derived[2]] = append(metrics[derived[2]], newMetric(derived[2], stats.Mean))
+
+		for i, q := range counter.quantiles() {
+			m := newMetric(derived[3], stats.Quantiles[i])
+			// Labels are rendered for every entity type, unlike attributes
+			m.Labels = maps.Clone(m.Labels)
+			if m.Labels == nil {
+				m.Labels = map[string]string{}
+			}
+			m.Labels[quantileLabel] = strconv.FormatFloat(q, 'f', -1, 64)
+			metrics[derived[3]] = append(metrics[derived[3]], m)
+		}
+	}
+}
+
+// attachSamples sets the samples of the metrics of histogram and summary counters.
+func attachSamples(metrics MetricsByCounter, entity dcgm.GroupEntityPair, samples map[entityField][]float64) {
+	for counter, metricVals := range metrics {
+		if counter.PromType != "histogram" && counter.PromType != "summary" {
+			continue
+		}
+
+		values := samples[entityField{entity: entity, fieldID: uint(counter.FieldID)}]
+		if values == nil {
+			// No samples since the previous collection, the latest value must not be observed again
+			values = []float64{}
+		}
+		for i := range metricVals {
+			metricVals[i].Samples = values
+		}
+	}
+}
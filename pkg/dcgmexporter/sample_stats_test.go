@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeSampleStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []float64
+		quantiles []float64
+		want      sampleStats
+	}{
+		{
+			name:      "single sample",
+			samples:   []float64{42},
+			quantiles: []float64{0.5, 0.99},
+			want:      sampleStats{Min: 42, Max: 42, Mean: 42, Quantiles: []float64{42, 42}},
+		},
+		{
+			name:      "unsorted samples",
+			samples:   []float64{300, 100, 400, 200, 500},
+			quantiles: []float64{0, 0.5, 0.9, 1},
+			want:      sampleStats{Min: 100, Max: 500, Mean: 300, Quantiles: []float64{100, 300, 460, 500}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeSampleStats(tt.samples, tt.quantiles)
+			assert.Equal(t, tt.want.Min, got.Min)
+			assert.Equal(t, tt.want.Max, got.Max)
+			assert.Equal(t, tt.want.Mean, got.Mean)
+			assert.InDeltaSlice(t, tt.want.Quantiles, got.Quantiles, 1e-9)
+		})
+	}
+}
+
+func TestAppendSampleStats(t *testing.T) {
+	counter := Counter{
+		FieldID:   dcgm.DCGM_FI_DEV_POWER_USAGE,
+		FieldName: "DCGM_FI_DEV_POWER_USAGE",
+		PromType:  "gauge",
+		Options:   &CounterOptions{SampleStats: true, Quantiles: []float64{0.5}},
+	}
+	entity := dcgm.GroupEntityPair{EntityGroupId: dcgm.FE_GPU, EntityId: 0}
+
+	metrics := MetricsByCounter{
+		counter: {
+			{
+				Counter:    counter,
+				Value:      "150",
+				GPU:        "0",
+				Labels:     map[string]string{"DCGM_FI_DRIVER_VERSION": "550.54"},
+				Attributes: map[string]string{},
+			},
+		},
+	}
+	samples := map[entityField][]float64{
+		{entity: entity, fieldID: uint(dcgm.DCGM_FI_DEV_POWER_USAGE)}:                       {100, 150, 200},
+		{entity: dcgm.GroupEntityPair{EntityGroupId: dcgm.FE_GPU, EntityId: 1}, fieldID: 0}: {1},
+	}
+
+	appendSampleStats(metrics, entity, samples)
+
+	require.Len(t, metrics, 5)
+	want := map[string]string{
+		"DCGM_FI_DEV_POWER_USAGE_MIN":      "100",
+		"DCGM_FI_DEV_POWER_USAGE_MAX":      "200",
+		"DCGM_FI_DEV_POWER_USAGE_MEAN":     "150",
+		"DCGM_FI_DEV_POWER_USAGE_QUANTILE": "150",
+	}
+	for c, metricVals := range metrics {
+		if c == counter {
+			continue
+		}
+		require.Len(t, metricVals, 1)
+		assert.Equal(t, want[c.FieldName], metricVals[0].Value, c.FieldName)
+		assert.Equal(t, "gauge", c.PromType)
+		assert.Equal(t, "550.54", metricVals[0].Labels["DCGM_FI_DRIVER_VERSION"])
+		if c.FieldName == "DCGM_FI_DEV_POWER_USAGE_QUANTILE" {
+			assert.Equal(t, "0.5", metricVals[0].Labels[quantileLabel])
+		}
+	}
+	assert.NotContains(t, metrics[counter][0].Labels, quantileLabel, "the labels of the latest value must not change")
+}
+
+func TestNewSampleHistory_OtherEntityFields(t *testing.T) {
+	counters := []Counter{{FieldID: dcgm.DCGM_FI_DEV_GPU_UTIL, FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "histogram"}}
+
+	// The GPU field is not watched for the switches
+	history, cleanups, err := newSampleHistory(counters, []dcgm.Short{dcgm.DCGM_FI_DEV_NVSWITCH_POWER_VDD},
+		SystemInfo{InfoType: dcgm.FE_SWITCH}, &Config{CollectInterval: 30000, SampleInterval: 1000})
+	require.NoError(t, err)
+	assert.Nil(t, history)
+	assert.Empty(t, cleanups)
+}
+
+func TestSampleKeepAge(t *testing.T) {
+	assert.Equal(t, 60*time.Second, sampleKeepAge(&Config{CollectInterval: 30000}))
+	assert.Equal(t, collectOnScrapeSampleKeepAge,
+		sampleKeepAge(&Config{CollectInterval: 30000, CollectOnScrape: true, MinCollectInterval: 1000}))
+	assert.Equal(t, 20*time.Minute,
+		sampleKeepAge(&Config{CollectInterval: 30000, CollectOnScrape: true, MinCollectInterval: 600000}))
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// scrapeCache runs the collection on demand. The result is reused for the minimum interval,
+// and the concurrent calls wait for a single collection instead of starting their own.
+type scrapeCache struct {
+	minInterval time.Duration
+	collect     func() (string, error)
+	now         func() time.Time
+
+	group singleflight.Group
+
+	mtx         sync.Mutex
+	last        scrapeResult
+	collectedAt time.Time
+}
+
+type scrapeResult struct {
+	output      string
+	collectedAt time.Time
+	err         error
+}
+
+func newScrapeCache(minInterval time.Duration, collect func() (string, error)) *scrapeCache {
+	return &scrapeCache{
+		minInterval: minInterval,
+		collect:     collect,
+		now:         time.Now,
+	}
+}
+
+// cached returns the result of the last collection, if it is not older than the minimum interval.
+func (sc *scrapeCache) cached() (scrapeResult, bool) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if sc.collectedAt.IsZero() || sc.now().Sub(sc.collectedAt) >= sc.minInterval {
+		return scrapeResult{}, false
+	}
+	return sc.last, true
+}
+
+// Get returns the cached result, or collects the metrics. The time of the collection identifies it,
+// the calls within the minimum interval get the same time.
+func (sc *scrapeCache) Get() (string, time.Time, error) {
+	if result, ok := sc.cached(); ok {
+		return result.output, result.collectedAt, result.err
+	}
+
+	v, _, _ := sc.group.Do("collect", func() (interface{}, error) {
+		// Another call could have collected the metrics, while this one was waiting
+		if result, ok := sc.cached(); ok {
+			return result, nil
+		}
+
+		output, err := sc.collect()
+		result := scrapeResult{output: output, collectedAt: sc.now(), err: err}
+
+		sc.mtx.Lock()
+		sc.last, sc.collectedAt = result, result.collectedAt
+		sc.mtx.Unlock()
+
+		return result, nil
+	})
+
+	result := v.(scrapeResult)
+	return result.output, result.collectedAt, result.err
+}
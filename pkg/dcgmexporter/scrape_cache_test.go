@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeCache(t *testing.T) {
+	var collections atomic.Int32
+	sc := newScrapeCache(time.Second, func() (string, error) {
+		return fmt.Sprint(collections.Add(1)), nil
+	})
+	now := time.Now()
+	sc.now = func() time.Time { return now }
+
+	output, collectedAt, err := sc.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "1", output)
+	assert.Equal(t, now, collectedAt)
+
+	start := now
+	now = now.Add(500 * time.Millisecond)
+	output, collectedAt, _ = sc.Get()
+	assert.Equal(t, "1", output, "the result is expected to be reused within the minimum interval")
+	assert.Equal(t, start, collectedAt)
+
+	now = now.Add(500 * time.Millisecond)
+	output, collectedAt, _ = sc.Get()
+	assert.Equal(t, "2", output)
+	assert.Equal(t, now, collectedAt)
+}
+
+func TestScrapeCache_Coalescing(t *testing.T) {
+	var collections atomic.Int32
+	release := make(chan struct{})
+	sc := newScrapeCache(time.Minute, func() (string, error) {
+		collections.Add(1)
+		<-release
+		return "metrics", nil
+	})
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 10)
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputs[i], _, _ = sc.Get()
+		}(i)
+	}
+
+	// Let the goroutines join the pending collection
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), collections.Load())
+	for _, output := range outputs {
+		assert.Equal(t, "metrics", output)
+	}
+}
+
+func TestScrapeCache_Error(t *testing.T) {
+	sc := newScrapeCache(time.Minute, func() (string, error) {
+		return "", errors.New("boom")
+	})
+
+	_, _, err := sc.Get()
+	assert.EqualError(t, err, "boom")
+	_, _, err = sc.Get()
+	assert.EqualError(t, err, "boom", "the failure is expected to be cached too")
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const seriesLimitedMetricName = "dcgm_exporter_series_limited_total"
+
+// seriesLimiter enforces the per-counter and the global series limits.
+//
+// The excess is dropped deterministically: the series admitted in the previous collection are kept first,
+// so that the exposed set does not flap, and the rest are admitted in the order of their fingerprints.
+// The counters are processed in the order of their names, when the global limit is applied.
+//
+// A metric counts as all the series exposed for it, i.e. with the buckets, _sum and _count of histograms
+// and summaries, and with the <FIELD>_COUNTER series of integrated counters, so that the limits bound
+// the exposed series. The series of the Registry collectors share the global limit with the pipeline.
+type seriesLimiter struct {
+	mtx sync.Mutex
+
+	globalLimit     int
+	perCounterLimit int
+	counterLimits   map[string]int
+
+	admitted   map[string]uint64 // Fingerprint of the admitted series -> generation
+	generation uint64
+	total      int // Series admitted in the current collection
+	collected  int // Series admitted in the last complete collection
+
+	limited map[string]uint64 // Counter name -> series dropped since the start
+	warned  map[string]bool   // Counters limited in the current collection
+}
+
+// newSeriesLimiter returns the limiter of the configuration, or nil when there are no limits.
+func newSeriesLimiter(c *Config) *seriesLimiter {
+	if c.SeriesLimit <= 0 && c.SeriesLimitPerCounter <= 0 && len(c.SeriesLimits) == 0 {
+		return nil
+	}
+
+	return &seriesLimiter{
+		globalLimit:     c.SeriesLimit,
+		perCounterLimit: c.SeriesLimitPerCounter,
+		counterLimits:   c.SeriesLimits,
+		admitted:        make(map[string]uint64),
+		limited:         make(map[string]uint64),
+		warned:          make(map[string]bool),
+	}
+}
+
+// begin starts a new collection. The series, that were not admitted in the previous one, lose their priority.
+func (l *seriesLimiter) begin() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for fp, generation := range l.admitted {
+		if generation != l.generation {
+			delete(l.admitted, fp)
+		}
+	}
+
+	l.generation++
+	l.total = 0
+}
+
+// end completes the collection. The series of the Registry collectors are limited by what it left.
+func (l *seriesLimiter) end() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.collected = l.total
+}
+
+func (l *seriesLimiter) counterLimit(name string) int {
+	if limit, exists := l.counterLimits[name]; exists {
+		return limit
+	}
+	return l.perCounterLimit
+}
+
+// seriesWeight returns the number of series exposed for every metric of the counter.
+func seriesWeight(counter Counter) int {
+	weight := 1
+	switch counter.PromType {
+	case "histogram":
+		// The buckets, the +Inf bucket, _sum and _count
+		weight = len(counter.buckets()) + 3
+	case "summary":
+		weight = len(counter.quantiles()) + 2
+	}
+	if counter.integrate() {
+		weight++
+	}
+	return weight
+}
+
+// Limit drops the series over the limits from the metrics of the pipeline.
+func (l *seriesLimiter) Limit(metrics MetricsByCounter) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.total = l.limit(metrics, l.total)
+}
+
+// LimitRegistry drops the series over the limits from the metrics of the Registry collectors.
+// They are limited by the series left by the last collection of the pipeline, every time they are gathered.
+func (l *seriesLimiter) LimitRegistry(metrics MetricsByCounter) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.limit(metrics, l.collected)
+}
+
+// limit drops the series over the limits, when total series are admitted already, and returns the new total.
+func (l *seriesLimiter) limit(metrics MetricsByCounter, total int) int {
+	counters := make([]Counter, 0, len(metrics))
+	for counter := range metrics {
+		counters = append(counters, counter)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].FieldName < counters[j].FieldName
+	})
+
+	for _, counter := range counters {
+		metricVals := metrics[counter]
+		weight := seriesWeight(counter)
+
+		limit := len(metricVals) * weight
+		if counterLimit := l.counterLimit(counter.FieldName); counterLimit > 0 {
+			limit = min(limit, counterLimit)
+		}
+		if l.globalLimit > 0 {
+			limit = min(limit, max(l.globalLimit-total, 0))
+		}
+		// The metrics are admitted with all their series, or not at all
+		limit /= weight
+
+		fingerprints := make([]string, len(metricVals))
+		for i := range metricVals {
+			fingerprints[i] = metricVals[i].metricFingerprint()
+		}
+
+		if limit < len(metricVals) {
+			metricVals = l.selectSeries(metricVals, fingerprints, limit)
+			dropped := (len(fingerprints) - limit) * weight
+			l.limited[counter.FieldName] += uint64(dropped)
+
+			if !l.warned[counter.FieldName] {
+				logrus.Warnf("Series limit reached for '%s'; dropping %d of %d series", counter.FieldName, dropped, len(fingerprints)*weight)
+			}
+			l.warned[counter.FieldName] = true
+		} else {
+			if l.warned[counter.FieldName] {
+				logrus.Infof("Series of '%s' are within the limits again", counter.FieldName)
+			}
+			delete(l.warned, counter.FieldName)
+
+			for _, fp := range fingerprints {
+				l.admitted[fp] = l.generation
+			}
+		}
+
+		total += len(metricVals) * weight
+		if len(metricVals) == 0 {
+			delete(metrics, counter)
+		} else {
+			metrics[counter] = metricVals
+		}
+	}
+
+	return total
+}
+
+// selectSeries returns the first limit series in the order of admission, and records them as admitted.
+func (l *seriesLimiter) selectSeries(metricVals []Metric, fingerprints []string, limit int) []Metric {
+	indices := make([]int, len(metricVals))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	slices.SortFunc(indices, func(a, b int) int {
+		_, aAdmitted := l.admitted[fingerprints[a]]
+		_, bAdmitted := l.admitted[fingerprints[b]]
+		if aAdmitted != bAdmitted {
+			if aAdmitted {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(fingerprints[a], fingerprints[b])
+	})
+
+	selected := make([]Metric, 0, limit)
+	for _, i := range indices[:limit] {
+		selected = append(selected, metricVals[i])
+		l.admitted[fingerprints[i]] = l.generation
+	}
+
+	return selected
+}
+
+// format returns the dcgm_exporter_series_limited_total metric in the Prometheus exposition format.
+func (l *seriesLimiter) format() string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# HELP %s Number of series dropped by the series limits.\n", seriesLimitedMetricName)
+	fmt.Fprintf(&sb, "# TYPE %s counter\n", seriesLimitedMetricName)
+
+	names := make([]string, 0, len(l.limited))
+	for name := range l.limited {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s{counter=\"%s\"} %d\n", seriesLimitedMetricName, name, l.limited[name])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLimiterTestMetrics(counter Counter, jobs ...string) []Metric {
+	var metrics []Metric
+	for _, job := range jobs {
+		metrics = append(metrics, Metric{
+			Counter:    counter,
+			Value:      "1",
+			GPU:        "0",
+			Attributes: map[string]string{hpcJobAttribute: job},
+		})
+	}
+	return metrics
+}
+
+func seriesJobs(metrics []Metric) []string {
+	var jobs []string
+	for _, m := range metrics {
+		jobs = append(jobs, m.Attributes[hpcJobAttribute])
+	}
+	return jobs
+}
+
+func TestSeriesLimiter_PerCounter(t *testing.T) {
+	util := Counter{FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "gauge"}
+	power := Counter{FieldName: "DCGM_FI_DEV_POWER_USAGE", PromType: "gauge"}
+
+	l := newSeriesLimiter(&Config{
+		SeriesLimitPerCounter: 2,
+		SeriesLimits:          map[string]int{"DCGM_FI_DEV_POWER_USAGE": 3},
+	})
+	require.NotNil(t, l)
+
+	l.begin()
+	metrics := MetricsByCounter{
+		util:  newLimiterTestMetrics(util, "a", "b"),
+		power: newLimiterTestMetrics(power, "a", "b", "c"),
+	}
+	l.Limit(metrics)
+	assert.Len(t, metrics[util], 2)
+	assert.Len(t, metrics[power], 3)
+
+	// The series admitted before are kept, the new one is dropped
+	l.begin()
+	metrics = MetricsByCounter{
+		util: newLimiterTestMetrics(util, "c", "b", "a"),
+	}
+	l.Limit(metrics)
+	assert.ElementsMatch(t, []string{"a", "b"}, seriesJobs(metrics[util]))
+	assert.Equal(t, map[string]uint64{"DCGM_FI_DEV_GPU_UTIL": 1}, l.limited)
+
+	// The same selection is made, whatever the order of the series
+	l.begin()
+	metrics = MetricsByCounter{
+		util: newLimiterTestMetrics(util, "b", "a", "c"),
+	}
+	l.Limit(metrics)
+	assert.ElementsMatch(t, []string{"a", "b"}, seriesJobs(metrics[util]))
+	assert.Equal(t, map[string]uint64{"DCGM_FI_DEV_GPU_UTIL": 2}, l.limited)
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(l.format()))
+	require.NoError(t, err)
+	require.Contains(t, families, seriesLimitedMetricName)
+	require.Len(t, families[seriesLimitedMetricName].GetMetric(), 1)
+	assert.Equal(t, float64(2), families[seriesLimitedMetricName].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestSeriesLimiter_Global(t *testing.T) {
+	util := Counter{FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "gauge"}
+	power := Counter{FieldName: "DCGM_FI_DEV_POWER_USAGE", PromType: "gauge"}
+
+	l := newSeriesLimiter(&Config{SeriesLimit: 3})
+
+	l.begin()
+	metrics := MetricsByCounter{
+		power: newLimiterTestMetrics(power, "a", "b"),
+		util:  newLimiterTestMetrics(util, "a", "b"),
+	}
+	l.Limit(metrics)
+	// The counters are processed in the order of their names
+	assert.Len(t, metrics[util], 2)
+	assert.Len(t, metrics[power], 1)
+
+	// The global limit spans the metrics of all entity types of the collection
+	other := MetricsByCounter{util: newLimiterTestMetrics(util, "x")}
+	l.Limit(other)
+	assert.Empty(t, other)
+	assert.Equal(t, map[string]uint64{"DCGM_FI_DEV_GPU_UTIL": 1, "DCGM_FI_DEV_POWER_USAGE": 1}, l.limited)
+}
+
+func countSeries(metrics MetricsByCounter) int {
+	n := 0
+	for _, metricVals := range metrics {
+		n += len(metricVals)
+	}
+	return n
+}
+
+func TestSeriesLimiter_ExposedSeries(t *testing.T) {
+	// 2 buckets, +Inf, _sum and _count
+	util := Counter{FieldName: "DCGM_FI_DEV_GPU_UTIL", PromType: "histogram", Options: &CounterOptions{Buckets: []float64{50, 100}}}
+	// The gauge and its _COUNTER series
+	power := Counter{FieldName: "DCGM_FI_DEV_POWER_USAGE", PromType: "gauge", Options: &CounterOptions{Integrate: true}}
+	xid := Counter{FieldName: "DCGM_EXP_XID_ERRORS_COUNT", PromType: "gauge"}
+
+	l := newSeriesLimiter(&Config{SeriesLimit: 14})
+
+	l.begin()
+	metrics := MetricsByCounter{
+		power: newLimiterTestMetrics(power, "a", "b"),
+		util:  newLimiterTestMetrics(util, "a", "b", "c"),
+	}
+	l.Limit(metrics)
+	assert.Len(t, metrics[power], 2)
+	assert.Len(t, metrics[util], 2)
+
+	exposed := newHistogramAggregator().Aggregate(metrics)
+	maps.Copy(exposed, newCounterIntegrator("").Integrate(metrics))
+	assert.Equal(t, 14, countSeries(exposed))
+	l.end()
+
+	// The Registry collectors share the global limit, whenever they are gathered
+	collector := new(mockCollector)
+	collector.On("GetMetrics").Return(MetricsByCounter{xid: newLimiterTestMetrics(xid, "a", "b")}, nil)
+	reg := NewRegistry()
+	reg.Register(collector)
+	reg.limiter = l
+
+	for range 2 {
+		gathered, err := reg.Gather()
+		require.NoError(t, err)
+		assert.Empty(t, gathered)
+	}
+	assert.Equal(t, map[string]uint64{
+		"DCGM_FI_DEV_GPU_UTIL":      5,
+		"DCGM_EXP_XID_ERRORS_COUNT": 4,
+	}, l.limited)
+
+	// The series, that the pipeline does not use, are left to the Registry collectors
+	l.begin()
+	metrics = MetricsByCounter{util: newLimiterTestMetrics(util, "a", "b")}
+	l.Limit(metrics)
+	l.end()
+
+	gathered, err := reg.Gather()
+	require.NoError(t, err)
+	assert.Equal(t, 2, countSeries(gathered))
+}
+
+func TestNewSeriesLimiter_Disabled(t *testing.T) {
+	assert.Nil(t, newSeriesLimiter(&Config{}))
+}
@@ -18,7 +18,10 @@ package dcgmexporter
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -27,6 +30,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/NVIDIA/dcgm-exporter/internal/pkg/logging"
+	"github.com/NVIDIA/dcgm-exporter/pkg/dcgmexporter/kubeauth"
 )
 
 func NewMetricsServer(c *Config, metrics chan string, registry *Registry) (*MetricsServer, func(), error) {
@@ -44,33 +48,61 @@ func NewMetricsServer(c *Config, metrics chan string, registry *Registry) (*Metr
 			WebConfigFile:      &c.WebConfigFile,
 		},
 		metricsChan: metrics,
-		metrics:     "",
 		registry:    registry,
+		assignments: c.Assignments,
+		gpuIDType:   c.KubernetesGPUIdType,
+		config:      c,
+		streams:     newStreamBroadcaster(maxStreamClients),
 	}
+	// Shutdown waits for the streams, which never go idle
+	serverv1.server.RegisterOnShutdown(serverv1.streams.close)
 
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.WriteHeader(http.StatusOK)
-		_, err := w.Write([]byte(`<html>
-			<head><title>GPU Exporter</title></head>
-			<body>
-			<h1>GPU Exporter</h1>
-			<p><a href="./metrics">Metrics</a></p>
-			</body>
-			</html>`))
+	// The metrics, the API and the status page require the same authentication
+	protect := func(h http.HandlerFunc) http.Handler { return h }
+	if c.KubernetesAuth {
+		authenticator, err := kubeauth.NewInCluster(kubeauth.Config{
+			Verb:     c.KubernetesAuthVerb,
+			Path:     c.KubernetesAuthPath,
+			CacheTTL: time.Duration(c.KubernetesAuthCacheTTL) * time.Millisecond,
+		})
 		if err != nil {
-			logrus.WithError(err).Error("Failed to write response.")
-			http.Error(w, "failed to write response", http.StatusInternalServerError)
-			return
+			return nil, func() {}, fmt.Errorf("failed to enable Kubernetes authentication; err: %w", err)
 		}
-	})
+		protect = func(h http.HandlerFunc) http.Handler { return authenticator.Middleware(h) }
+	}
 
+	// The status page exposes the configuration, so it is moved to the admin listener, if there is one
+	if c.AdminAddress != "" {
+		router.HandleFunc("/", serverv1.LandingPage)
+	} else {
+		router.Handle("/", protect(serverv1.StatusPage))
+	}
 	router.HandleFunc("/health", serverv1.Health)
-	router.HandleFunc("/metrics", serverv1.Metrics)
+	router.Handle("/metrics", protect(serverv1.Metrics))
+	router.Handle("/api/v1/devices", protect(serverv1.Devices)).Methods(http.MethodGet)
+	router.Handle("/api/v1/assignments", protect(serverv1.Assignments)).Methods(http.MethodGet)
+	router.Handle("/api/v1/stream", protect(serverv1.Stream)).Methods(http.MethodGet)
 
 	return serverv1, func() {}, nil
 }
 
+// LandingPage links the metrics, when the status page is served on the admin listener.
+func (s *MetricsServer) LandingPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(`<html>
+			<head><title>GPU Exporter</title></head>
+			<body>
+			<h1>GPU Exporter</h1>
+			<p><a href="./metrics">Metrics</a></p>
+			</body>
+			</html>`))
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response.")
+	}
+}
+
 func (s *MetricsServer) Run(stop chan interface{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// Wrap the logrus logger with the LogrusAdapter
@@ -109,30 +141,107 @@ func (s *MetricsServer) Run(stop chan interface{}, wg *sync.WaitGroup) {
 	}
 }
 
+// SetCollector makes the server collect the metrics on every scrape with the function,
+// e.g. MetricsPipeline.Collect, instead of serving the last metrics received from the channel.
+// The function returns the time of the collection, which identifies it.
+func (s *MetricsServer) SetCollector(collect func() (string, time.Time, error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.collect = collect
+}
+
+// Metrics serves the metrics of the last collection. The response is compressed with the content coding
+// negotiated from Accept-Encoding, and the conditional requests get 304 Not Modified, until the next collection.
 func (s *MetricsServer) Metrics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte(s.getMetrics()))
+	snapshot := s.scrapeMetrics()
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+	body, etag, err := snapshot.response(s.registry, encoding)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to write response.")
 		http.Error(w, "failed to write response", http.StatusInternalServerError)
 		return
 	}
-	metrics, err := s.registry.Gather()
-	if err != nil {
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Vary", "Accept-Encoding")
+	header.Set("ETag", etag)
+	if !snapshot.collectedAt.IsZero() {
+		header.Set("Last-Modified", snapshot.collectedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, snapshot.collectedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", metricsContentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	if encoding != encodingIdentity {
+		header.Set("Content-Encoding", encoding)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
 		logrus.WithError(err).Error("Failed to write response.")
-		http.Error(w, "failed to write response", http.StatusInternalServerError)
+	}
+}
+
+// SetInventory sets the inventory of the devices served on /api/v1/devices.
+func (s *MetricsServer) SetInventory(inventory *Inventory) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.inventory = inventory
+}
+
+func (s *MetricsServer) Devices(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	inventory := s.inventory
+	s.Unlock()
+
+	if inventory == nil {
+		http.Error(w, "inventory is not available", http.StatusServiceUnavailable)
 		return
 	}
-	err = encodeExpMetrics(w, metrics)
-	if err != nil {
-		http.Error(w, "failed to write response", http.StatusInternalServerError)
+
+	writeJSON(w, inventory)
+}
+
+// Assignments serves the pods and the HPC jobs, that the GPUs and the MIG instances of the inventory
+// are assigned to, as mapped in the last collection. The unassigned devices are listed too.
+func (s *MetricsServer) Assignments(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	inventory := s.inventory
+	s.Unlock()
+
+	if inventory == nil || s.assignments == nil {
+		http.Error(w, "assignments are not available", http.StatusServiceUnavailable)
 		return
 	}
+
+	writeJSON(w, s.assignments.Assignments(inventory, s.gpuIDType))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logrus.WithError(err).Error("Failed to write response.")
+	}
 }
 
 func (s *MetricsServer) Health(w http.ResponseWriter, r *http.Request) {
-	if s.getMetrics() == "" {
+	if !s.healthy() {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusServiceUnavailable)
 		_, err := w.Write([]byte("KO"))
@@ -152,15 +261,71 @@ func (s *MetricsServer) Health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *MetricsServer) updateMetrics(m string) {
+	snapshot := newMetricsSnapshot(m)
+	s.snapshot.Store(snapshot)
+	s.streams.publish(snapshot)
+}
+
+// getMetrics returns the last snapshot; it never waits for an update.
+func (s *MetricsServer) getMetrics() *metricsSnapshot {
+	if snapshot := s.snapshot.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &metricsSnapshot{}
+}
+
+// scrapeMetrics returns the metrics to serve. They are collected now, if the server has a collector.
+func (s *MetricsServer) scrapeMetrics() *metricsSnapshot {
+	s.Lock()
+	collect := s.collect
+	s.Unlock()
+
+	if collect == nil {
+		return s.getMetrics()
+	}
+
+	metrics, collectedAt, err := collect()
+
+	s.Lock()
+	s.lastCollectErr = err
+	s.Unlock()
+
+	if err != nil {
+		/* serve no data rather than stale data */
+		logrus.Errorf("Failed to collect metrics; err: %v", err)
+		metrics = ""
+	}
+
+	// The scrapes within the minimum interval get the same collection, and so the same snapshot.
+	// Every other collection gets a new snapshot, even with the same metrics, so that the Registry
+	// is gathered again.
+	snapshot := s.getMetrics()
+	if !snapshot.collectedAt.Equal(collectedAt) {
+		snapshot = &metricsSnapshot{data: metrics, collectedAt: collectedAt}
+		s.snapshot.Store(snapshot)
+		s.streams.publish(snapshot)
+	}
+
+	return snapshot
+}
+
+// collectErr returns the error of the last collection on scrape.
+func (s *MetricsServer) collectErr() error {
 	s.Lock()
 	defer s.Unlock()
 
-	s.metrics = m
+	return s.lastCollectErr
 }
 
-func (s *MetricsServer) getMetrics() string {
+// healthy reports whether there are metrics to serve. A server, that collects on scrape,
+// is healthy until a collection fails, so that the health checks do not trigger collections.
+func (s *MetricsServer) healthy() bool {
 	s.Lock()
 	defer s.Unlock()
 
-	return s.metrics
+	if s.collect != nil {
+		return s.lastCollectErr == nil
+	}
+
+	return s.getMetrics().data != ""
 }
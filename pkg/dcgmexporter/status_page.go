@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "<redacted>"
+
+// secretConfigField matches the names of the Config fields, whose values are never shown.
+var secretConfigField = regexp.MustCompile(`(?i)password|secret|token|credential`)
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(*t).Truncate(time.Second))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>GPU Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.ok { color: #080; }
+.ko { color: #c00; }
+</style>
+</head>
+<body>
+<h1>GPU Exporter</h1>
+{{- if .Links }}
+<p>
+<a href="./metrics">Metrics</a> |
+<a href="./api/v1/devices">Devices</a> |
+<a href="./api/v1/assignments">Assignments</a>
+</p>
+{{- end }}
+
+<h2>Exporter</h2>
+<table>
+<tr><th>Version</th><td>{{ .Version }}</td></tr>
+<tr><th>Health</th><td>{{ if .Healthy }}<span class="ok">healthy</span>{{ else }}<span class="ko">unhealthy</span>{{ end }}</td></tr>
+<tr><th>Last collection</th><td>{{ since .LastCollection }}</td></tr>
+<tr><th>Metrics size</th><td>{{ .MetricsBytes }} bytes</td></tr>
+{{- if .LastError }}
+<tr><th>Last error</th><td class="ko">{{ .LastError }}</td></tr>
+{{- end }}
+</table>
+
+<h2>Collectors</h2>
+<table>
+<tr><th>Collector</th><th>Health</th><th>Last collection</th><th>Duration</th><th>Series</th><th>Last error</th></tr>
+{{- range .Collectors }}
+<tr>
+<td>{{ .Name }}</td>
+<td>{{ if .Healthy }}<span class="ok">healthy</span>{{ else }}<span class="ko">failing</span>{{ end }}</td>
+<td>{{ since .LastCollection }}</td>
+<td>{{ .Duration }}</td>
+<td>{{ .Series }}</td>
+<td class="ko">{{ .LastError }}</td>
+</tr>
+{{- else }}
+<tr><td colspan="6">No collection yet</td></tr>
+{{- end }}
+</table>
+
+<h2>Devices</h2>
+{{- with .Inventory }}
+<table>
+<tr><th>GPU</th><th>UUID</th><th>PCI bus ID</th><th>Model</th><th>Memory</th><th>Driver</th><th>Watched</th><th>MIG instances</th></tr>
+{{- range .GPUs }}
+<tr>
+<td>{{ .GPU }}</td><td>{{ .UUID }}</td><td>{{ .PCIBusID }}</td><td>{{ .Model }}</td><td>{{ .MemoryMiB }} MiB</td>
+<td>{{ .DriverVersion }}</td><td>{{ .Watched }}</td>
+<td>{{ range .GPUInstances }}{{ .InstanceID }}: {{ .Profile }}{{ if .Watched }} (watched){{ end }}<br>{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+{{- if .Switches }}
+<table>
+<tr><th>NVSwitch</th><th>Watched</th><th>NVLinks</th></tr>
+{{- range .Switches }}
+<tr><td>{{ .EntityID }}</td><td>{{ .Watched }}</td><td>{{ range .Links }}{{ .Index }}: {{ .State }} {{ end }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- if .CPUs }}
+<table>
+<tr><th>CPU</th><th>Watched</th><th>Cores</th></tr>
+{{- range .CPUs }}
+<tr><td>{{ .EntityID }}</td><td>{{ .Watched }}</td><td>{{ len .Cores }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+
+<h2>Counters</h2>
+<table>
+<tr><th>Entity</th><th>Counter</th><th>Field ID</th><th>Type</th></tr>
+{{- range $entity, $counters := .Counters }}
+{{- range $counters }}
+<tr><td>{{ $entity }}</td><td>{{ .Name }}</td><td>{{ .FieldID }}</td><td>{{ .PromType }}</td></tr>
+{{- end }}
+{{- end }}
+</table>
+{{- else }}
+<p>The devices are not discovered yet.</p>
+{{- end }}
+
+<h2>Assignments</h2>
+{{- with .Assignments }}
+<table>
+<tr><th>GPU</th><th>MIG instance</th><th>UUID</th><th>Pod</th><th>Container</th><th>HPC jobs</th></tr>
+{{- range .Devices }}
+<tr>
+<td>{{ .GPU }}</td><td>{{ if .GPUInstanceID }}{{ .GPUInstanceID }} ({{ .MigProfile }}){{ end }}</td><td>{{ .UUID }}</td>
+<td>{{ range .Pods }}{{ .Namespace }}/{{ .Name }}{{ with .Replica }} (replica {{ . }}){{ end }}{{ with .ClaimName }} (claim {{ . }}){{ end }} {{ else }}-{{ end }}</td>
+<td>{{ range .Pods }}{{ .Container }} {{ end }}</td>
+<td>{{ range .Jobs }}{{ . }} {{ end }}</td>
+</tr>
+{{- end }}
+</table>
+{{- else }}
+<p>The pod and HPC job mapping is not available.</p>
+{{- end }}
+
+<h2>Recent XID events</h2>
+{{- if .XIDEvents }}
+<p>Within the last {{ .XIDWindow }}.</p>
+<table>
+<tr><th>GPU</th><th>MIG instance</th><th>UUID</th><th>XID</th><th>Count</th><th>Pod</th></tr>
+{{- range .XIDEvents }}
+<tr>
+<td>{{ .GPU }}</td><td>{{ .GPUInstanceID }}</td><td>{{ .UUID }}</td><td>{{ .XID }}</td><td>{{ .Count }}</td>
+<td>{{ if .Pod }}{{ .Namespace }}/{{ .Pod }}{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+{{- else }}
+<p>No XID events{{ if .XIDWindow }} within the last {{ .XIDWindow }}{{ end }}.</p>
+{{- end }}
+
+<h2>Configuration</h2>
+<table>
+{{- range .Config }}
+<tr><th>{{ .Name }}</th><td>{{ .Value }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+type statusPage struct {
+	// Links links the metrics and the API, when the page is served on the same listener
+	Links          bool
+	Version        string
+	Healthy        bool
+	LastCollection *time.Time
+	MetricsBytes   int
+	LastError      string
+	Collectors     []CollectorStatus
+	Inventory      *Inventory
+	Assignments    *Assignments
+	XIDWindow      string
+	XIDEvents      []xidEvent
+	Config         []configEntry
+}
+
+type configEntry struct {
+	Name  string
+	Value string
+}
+
+type xidEvent struct {
+	GPU           string
+	UUID          string
+	GPUInstanceID string
+	XID           string
+	Count         string
+	Pod           string
+	Namespace     string
+}
+
+// SetCollectorStatus sets the function returning the status of the pipeline collectors, e.g. MetricsPipeline.CollectorStatus,
+// shown on the status page.
+func (s *MetricsServer) SetCollectorStatus(collectorStatus func() []CollectorStatus) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.collectorStatus = collectorStatus
+}
+
+// StatusPage serves the human-readable state of the exporter: the version, the health of the collectors,
+// the devices and the counters, the assignments, the recent XID events and the effective configuration.
+// It is served on the admin listener, if there is one, and on the metrics listener otherwise.
+func (s *MetricsServer) StatusPage(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := statusPageTemplate.Execute(&buf, s.statusPage()); err != nil {
+		logrus.WithError(err).Error("Failed to render status page.")
+		http.Error(w, "failed to render status page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logrus.WithError(err).Error("Failed to write response.")
+	}
+}
+
+func (s *MetricsServer) statusPage() statusPage {
+	s.Lock()
+	inventory := s.inventory
+	collectorStatus := s.collectorStatus
+	s.Unlock()
+
+	snapshot := s.getMetrics()
+	// The Registry collectors are gathered, when the snapshot is rendered, so render it, unless it was scraped already
+	if _, _, err := snapshot.response(s.registry, encodingIdentity); err != nil {
+		logrus.WithError(err).Warn("Failed to render metrics for status page.")
+	}
+
+	page := statusPage{
+		Links:        s.config == nil || s.config.AdminAddress == "",
+		Healthy:      s.healthy(),
+		MetricsBytes: len(snapshot.data),
+		Inventory:    inventory,
+		Config:       redactedConfig(s.config),
+	}
+	if !snapshot.collectedAt.IsZero() {
+		page.LastCollection = &snapshot.collectedAt
+	}
+	if err := s.collectErr(); err != nil {
+		page.LastError = err.Error()
+	}
+	if collectorStatus != nil {
+		page.Collectors = collectorStatus()
+	}
+	if s.registry != nil {
+		page.Collectors = append(page.Collectors, s.registry.CollectorStatus()...)
+		page.XIDEvents = xidEvents(s.registry.lastMetrics())
+	}
+	if s.config != nil {
+		page.Version = s.config.Version
+		page.XIDWindow = (time.Duration(s.config.XIDCountWindowSize) * time.Millisecond).String()
+	}
+	if inventory != nil && s.assignments != nil {
+		assignments := s.assignments.Assignments(inventory, s.gpuIDType)
+		page.Assignments = &assignments
+	}
+
+	return page
+}
+
+// xidEvents returns the XID events counted by the DCGM_EXP_XID_ERRORS_COUNT collector within its window.
+func xidEvents(metrics MetricsByCounter) []xidEvent {
+	var events []xidEvent
+	for counter, metricVals := range metrics {
+		if counter.FieldName != dcgmExpXIDErrorsCount {
+			continue
+		}
+		for _, m := range metricVals {
+			if m.Value == "0" {
+				continue
+			}
+			events = append(events, xidEvent{
+				GPU:           m.GPU,
+				UUID:          m.GPUUUID,
+				GPUInstanceID: m.GPUInstanceID,
+				XID:           m.Labels["xid"],
+				Count:         m.Value,
+				Pod:           m.Attributes[podAttribute],
+				Namespace:     m.Attributes[namespaceAttribute],
+			})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].GPU != events[j].GPU {
+			return events[i].GPU < events[j].GPU
+		}
+		if events[i].GPUInstanceID != events[j].GPUInstanceID {
+			return events[i].GPUInstanceID < events[j].GPUInstanceID
+		}
+		return events[i].XID < events[j].XID
+	})
+
+	return events
+}
+
+// redactedConfig returns the settings of the Config. The runtime objects, e.g. the PodWatcher, are left out,
+// and the secrets, i.e. the fields named like a password or a token, and the passwords in URLs, are redacted.
+func redactedConfig(c *Config) []configEntry {
+	if c == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(*c)
+	entries := make([]configEntry, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+
+		switch value.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Func, reflect.Chan:
+			continue
+		}
+
+		entry := configEntry{Name: field.Name, Value: fmt.Sprintf("%+v", value.Interface())}
+		switch {
+		case secretConfigField.MatchString(field.Name) && !value.IsZero():
+			entry.Value = redacted
+		case value.Kind() == reflect.String:
+			entry.Value = redactURL(entry.Value)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// redactURL redacts the password of the URL, if the value is a URL with a password.
+func redactURL(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
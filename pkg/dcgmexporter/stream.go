@@ -0,0 +1,409 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxStreamClients is the maximum number of concurrent streams.
+	maxStreamClients = 64
+	// streamKeepAliveInterval is the interval of the comments sent to keep idle streams open through proxies.
+	streamKeepAliveInterval = 15 * time.Second
+)
+
+// streamFilterLabels maps the query parameters of /api/v1/stream to the labels they match.
+// The labels of the old namespace are matched too.
+var streamFilterLabels = map[string][]string{
+	"gpu":       {"gpu"},
+	"uuid":      {"UUID", "uuid"},
+	"device":    {"device"},
+	"nvswitch":  {"nvswitch"},
+	"nvlink":    {"nvlink"},
+	"cpu":       {"cpu"},
+	"cpucore":   {"cpucore"},
+	"pod":       {podAttribute, oldPodAttribute},
+	"namespace": {namespaceAttribute, oldNamespaceAttribute},
+	"container": {containerAttribute, oldContainerAttribute},
+}
+
+// StreamSample is a sample of a metric, as in the text exposition format. The value is a string,
+// as in the Prometheus HTTP API, because JSON has no NaN and infinities.
+type StreamSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  string            `json:"value"`
+
+	// family is the name of the metric family, e.g. "x" of the sample "x_bucket"
+	family string
+}
+
+// streamEvent is the data of a "metrics" event of /api/v1/stream.
+type streamEvent struct {
+	CollectedAt time.Time      `json:"collectedAt"`
+	Metrics     []StreamSample `json:"metrics"`
+}
+
+// streamBroadcaster publishes the snapshots to the streams. A stream, that does not keep up, skips to the latest snapshot.
+type streamBroadcaster struct {
+	mtx        sync.Mutex
+	clients    map[chan *metricsSnapshot]struct{}
+	maxClients int
+	// done is closed, when the server shuts down, the streams end then
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newStreamBroadcaster(maxClients int) *streamBroadcaster {
+	return &streamBroadcaster{
+		clients:    make(map[chan *metricsSnapshot]struct{}),
+		maxClients: maxClients,
+		done:       make(chan struct{}),
+	}
+}
+
+// subscribe returns the channel of the published snapshots and the function to unsubscribe,
+// or false, if there are too many streams or the broadcaster is closed.
+func (b *streamBroadcaster) subscribe() (<-chan *metricsSnapshot, func(), bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if len(b.clients) >= b.maxClients || b.closed() {
+		return nil, nil, false
+	}
+
+	ch := make(chan *metricsSnapshot, 1)
+	b.clients[ch] = struct{}{}
+
+	return ch, func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+
+		delete(b.clients, ch)
+	}, true
+}
+
+// close ends the streams. http.Server.Shutdown does not cancel the requests, and waits for them,
+// so it is registered with RegisterOnShutdown.
+func (b *streamBroadcaster) close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *streamBroadcaster) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *streamBroadcaster) publish(snapshot *metricsSnapshot) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for ch := range b.clients {
+		// Replace the snapshot, that was not received yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- snapshot
+	}
+}
+
+// streamFilter selects the samples of a stream. The values of a parameter are alternatives,
+// and the sample must match all the parameters.
+type streamFilter struct {
+	names    map[string]bool
+	matchers []streamMatcher
+}
+
+type streamMatcher struct {
+	labels []string
+	values map[string]bool
+}
+
+func newStreamFilter(query url.Values) streamFilter {
+	filter := streamFilter{}
+
+	if names := query["metric"]; len(names) > 0 {
+		filter.names = make(map[string]bool, len(names))
+		for _, name := range names {
+			filter.names[name] = true
+		}
+	}
+
+	for param, labels := range streamFilterLabels {
+		values := query[param]
+		if len(values) == 0 {
+			continue
+		}
+		matcher := streamMatcher{labels: labels, values: make(map[string]bool, len(values))}
+		for _, value := range values {
+			matcher.values[value] = true
+		}
+		filter.matchers = append(filter.matchers, matcher)
+	}
+
+	return filter
+}
+
+func (f streamFilter) match(sample StreamSample) bool {
+	if f.names != nil && !f.names[sample.Name] && !f.names[sample.family] {
+		return false
+	}
+
+	for _, matcher := range f.matchers {
+		matched := false
+		for _, label := range matcher.labels {
+			if value, exists := sample.Labels[label]; exists && matcher.values[value] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// streamSamples returns the samples of the snapshot. They are parsed on the first call.
+func (s *metricsSnapshot) streamSamples() ([]StreamSample, error) {
+	s.samplesOnce.Do(func() {
+		s.samples, s.samplesErr = parseStreamSamples(s.data)
+	})
+	return s.samples, s.samplesErr
+}
+
+func parseStreamSamples(data string) ([]StreamSample, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics; err: %w", err)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var samples []StreamSample
+	for _, name := range names {
+		family := families[name]
+		for _, m := range family.GetMetric() {
+			samples = append(samples, familySamples(name, family.GetType(), m)...)
+		}
+	}
+
+	return samples, nil
+}
+
+// familySamples returns the samples of the metric, as they are written in the text exposition format.
+func familySamples(family string, metricType dto.MetricType, m *dto.Metric) []StreamSample {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+
+	sample := func(suffix string, value float64, labels map[string]string) StreamSample {
+		return StreamSample{
+			Name:   family + suffix,
+			Labels: labels,
+			Value:  strconv.FormatFloat(value, 'g', -1, 64),
+			family: family,
+		}
+	}
+	withLabel := func(name string, value float64) map[string]string {
+		l := maps.Clone(labels)
+		l[name] = strconv.FormatFloat(value, 'g', -1, 64)
+		return l
+	}
+
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return []StreamSample{sample("", m.GetCounter().GetValue(), labels)}
+	case dto.MetricType_GAUGE:
+		return []StreamSample{sample("", m.GetGauge().GetValue(), labels)}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		samples := make([]StreamSample, 0, len(h.GetBucket())+2)
+		for _, bucket := range h.GetBucket() {
+			samples = append(samples, sample("_bucket", float64(bucket.GetCumulativeCount()), withLabel("le", bucket.GetUpperBound())))
+		}
+		return append(samples,
+			sample("_sum", h.GetSampleSum(), labels),
+			sample("_count", float64(h.GetSampleCount()), labels))
+	case dto.MetricType_SUMMARY:
+		summary := m.GetSummary()
+		samples := make([]StreamSample, 0, len(summary.GetQuantile())+2)
+		for _, quantile := range summary.GetQuantile() {
+			samples = append(samples, sample("", quantile.GetValue(), withLabel("quantile", quantile.GetQuantile())))
+		}
+		return append(samples,
+			sample("_sum", summary.GetSampleSum(), labels),
+			sample("_count", float64(summary.GetSampleCount()), labels))
+	default:
+		return []StreamSample{sample("", m.GetUntyped().GetValue(), labels)}
+	}
+}
+
+// Stream pushes every new collection as a Server-Sent Events "metrics" event, whose data is the JSON of the samples
+// selected by the query parameters: metric, gpu, uuid, device, nvswitch, nvlink, cpu, cpucore, pod, namespace and container.
+func (s *MetricsServer) Stream(w http.ResponseWriter, r *http.Request) {
+	if s.streams == nil {
+		http.Error(w, "streaming is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	snapshots, unsubscribe, ok := s.streams.subscribe()
+	if !ok {
+		http.Error(w, "too many streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+
+	// With collect on scrape, the streams trigger the collections every collect interval, as the ticker would,
+	// so that they do not depend on the scrapes. The scrapes and the other streams share the collections.
+	var collectTicks <-chan time.Time
+	if interval := s.streamCollectInterval(); interval > 0 {
+		s.scrapeMetrics()
+
+		collectTicker := time.NewTicker(interval)
+		defer collectTicker.Stop()
+		collectTicks = collectTicker.C
+	}
+
+	filter := newStreamFilter(r.URL.Query())
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		logrus.WithError(err).Warn("Failed to clear write deadline of stream.")
+	}
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logrus.WithError(err).Error("Failed to write response.")
+		return
+	}
+
+	// The snapshot published since the subscription may be the last one, which is written first
+	var last *metricsSnapshot
+	if snapshot := s.getMetrics(); !snapshot.collectedAt.IsZero() {
+		if err := writeStreamEvent(w, rc, snapshot, filter); err != nil {
+			return
+		}
+		last = snapshot
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.done:
+			return
+		case snapshot := <-snapshots:
+			if snapshot == last {
+				continue
+			}
+			if err := writeStreamEvent(w, rc, snapshot, filter); err != nil {
+				return
+			}
+			last = snapshot
+		case <-collectTicks:
+			// The new snapshot, if any, is published to the streams
+			s.scrapeMetrics()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamCollectInterval returns the interval of the collections triggered by the streams, or 0,
+// when the metrics are collected every collect interval anyway.
+func (s *MetricsServer) streamCollectInterval() time.Duration {
+	s.Lock()
+	collect := s.collect
+	s.Unlock()
+
+	if collect == nil || s.config == nil {
+		return 0
+	}
+	return time.Duration(s.config.CollectInterval) * time.Millisecond
+}
+
+func writeStreamEvent(w http.ResponseWriter, rc *http.ResponseController, snapshot *metricsSnapshot, filter streamFilter) error {
+	samples, err := snapshot.streamSamples()
+	if err != nil {
+		// Skip the snapshot, the stream goes on with the next one
+		logrus.WithError(err).Error("Failed to stream metrics.")
+		return nil
+	}
+
+	event := streamEvent{CollectedAt: snapshot.collectedAt, Metrics: []StreamSample{}}
+	for _, sample := range samples {
+		if filter.match(sample) {
+			event.Metrics = append(event.Metrics, sample)
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to stream metrics.")
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
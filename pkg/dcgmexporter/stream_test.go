@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dcgmexporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const streamTestMetrics = `# HELP DCGM_FI_DEV_GPU_TEMP GPU temperature (in C).
+# TYPE DCGM_FI_DEV_GPU_TEMP gauge
+DCGM_FI_DEV_GPU_TEMP{gpu="0",UUID="GPU-0",pod="train-0",namespace="ml"} 42
+DCGM_FI_DEV_GPU_TEMP{gpu="1",UUID="GPU-1"} 37
+# HELP DCGM_FI_DEV_POWER_USAGE Power draw (in W).
+# TYPE DCGM_FI_DEV_POWER_USAGE gauge
+DCGM_FI_DEV_POWER_USAGE{gpu="0",UUID="GPU-0",pod="train-0",namespace="ml"} 120.5
+# HELP DCGM_FI_DEV_SM_CLOCK_HISTOGRAM SM clock frequency (in MHz).
+# TYPE DCGM_FI_DEV_SM_CLOCK_HISTOGRAM histogram
+DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_bucket{gpu="0",le="1000"} 1
+DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_bucket{gpu="0",le="+Inf"} 3
+DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_sum{gpu="0"} 4200
+DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_count{gpu="0"} 3
+`
+
+func TestParseStreamSamples(t *testing.T) {
+	samples, err := parseStreamSamples(streamTestMetrics)
+	require.NoError(t, err)
+	require.Len(t, samples, 7)
+
+	assert.Equal(t, StreamSample{
+		Name:   "DCGM_FI_DEV_GPU_TEMP",
+		Labels: map[string]string{"gpu": "0", "UUID": "GPU-0", "pod": "train-0", "namespace": "ml"},
+		Value:  "42",
+		family: "DCGM_FI_DEV_GPU_TEMP",
+	}, samples[0])
+	assert.Equal(t, "120.5", samples[2].Value)
+	assert.Equal(t, StreamSample{
+		Name:   "DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_bucket",
+		Labels: map[string]string{"gpu": "0", "le": "+Inf"},
+		Value:  "3",
+		family: "DCGM_FI_DEV_SM_CLOCK_HISTOGRAM",
+	}, samples[4])
+	assert.Equal(t, "DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_sum", samples[5].Name)
+	assert.Equal(t, map[string]string{"gpu": "0"}, samples[5].Labels)
+
+	_, err = parseStreamSamples("not metrics{")
+	assert.Error(t, err)
+}
+
+func TestStreamFilter(t *testing.T) {
+	samples, err := parseStreamSamples(streamTestMetrics)
+	require.NoError(t, err)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: []string{"DCGM_FI_DEV_GPU_TEMP", "DCGM_FI_DEV_GPU_TEMP", "DCGM_FI_DEV_POWER_USAGE",
+			"DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_bucket", "DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_bucket",
+			"DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_sum", "DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_count"}},
+		{query: "metric=DCGM_FI_DEV_GPU_TEMP", want: []string{"DCGM_FI_DEV_GPU_TEMP", "DCGM_FI_DEV_GPU_TEMP"}},
+		{query: "metric=DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_count", want: []string{"DCGM_FI_DEV_SM_CLOCK_HISTOGRAM_count"}},
+		{query: "metric=DCGM_FI_DEV_GPU_TEMP&metric=DCGM_FI_DEV_POWER_USAGE&gpu=1", want: []string{"DCGM_FI_DEV_GPU_TEMP"}},
+		{query: "uuid=GPU-0&metric=DCGM_FI_DEV_POWER_USAGE", want: []string{"DCGM_FI_DEV_POWER_USAGE"}},
+		{query: "pod=train-0&namespace=ml", want: []string{"DCGM_FI_DEV_GPU_TEMP", "DCGM_FI_DEV_POWER_USAGE"}},
+		{query: "pod=train-0&namespace=default", want: nil},
+		{query: "nvswitch=0", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			filter := newStreamFilter(query)
+
+			var got []string
+			for _, sample := range samples {
+				if filter.match(sample) {
+					got = append(got, sample.Name)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStreamBroadcaster(t *testing.T) {
+	b := newStreamBroadcaster(1)
+
+	ch, unsubscribe, ok := b.subscribe()
+	require.True(t, ok)
+	_, _, ok = b.subscribe()
+	assert.False(t, ok, "too many streams")
+
+	// A slow stream gets the latest snapshot
+	first, second := newMetricsSnapshot("a 1\n"), newMetricsSnapshot("a 2\n")
+	b.publish(first)
+	b.publish(second)
+	assert.Same(t, second, <-ch)
+
+	unsubscribe()
+	_, unsubscribe, ok = b.subscribe()
+	require.True(t, ok)
+	unsubscribe()
+
+	b.close()
+	_, _, ok = b.subscribe()
+	assert.False(t, ok, "closed")
+	assert.NotPanics(t, b.close)
+
+	var nilBroadcaster *streamBroadcaster
+	assert.NotPanics(t, func() { nilBroadcaster.publish(first) })
+}
+
+func readStreamEvent(t *testing.T, reader *bufio.Reader) streamEvent {
+	t.Helper()
+
+	var event string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.Equal(t, "metrics", event)
+			var got streamEvent
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got))
+			return got
+		}
+	}
+}
+
+func TestMetricsServer_Stream(t *testing.T) {
+	s := &MetricsServer{streams: newStreamBroadcaster(maxStreamClients)}
+	s.updateMetrics(streamTestMetrics)
+
+	server := httptest.NewServer(http.HandlerFunc(s.Stream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?metric=DCGM_FI_DEV_GPU_TEMP&gpu=0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	reader := bufio.NewReader(resp.Body)
+
+	// The stream starts with the last collection
+	event := readStreamEvent(t, reader)
+	require.Len(t, event.Metrics, 1)
+	assert.Equal(t, "42", event.Metrics[0].Value)
+	assert.Equal(t, "train-0", event.Metrics[0].Labels["pod"])
+
+	require.Eventually(t, func() bool {
+		s.streams.mtx.Lock()
+		defer s.streams.mtx.Unlock()
+		return len(s.streams.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	s.updateMetrics(strings.Replace(streamTestMetrics, "} 42", "} 43", 1))
+
+	event = readStreamEvent(t, reader)
+	require.Len(t, event.Metrics, 1)
+	assert.Equal(t, "43", event.Metrics[0].Value)
+	assert.False(t, event.CollectedAt.IsZero())
+}
+
+func TestMetricsServer_Stream_Shutdown(t *testing.T) {
+	s, cleanup, err := NewMetricsServer(&Config{Address: "127.0.0.1:0"}, make(chan string), NewRegistry())
+	require.NoError(t, err)
+	defer cleanup()
+	s.updateMetrics(streamTestMetrics)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.server.Serve(listener)
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/api/v1/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reader := bufio.NewReader(resp.Body)
+	readStreamEvent(t, reader)
+
+	// The open stream does not block the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, s.server.Shutdown(ctx))
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+
+	_, err = io.ReadAll(reader)
+	assert.NoError(t, err, "the stream is expected to end")
+}
+
+func TestMetricsServer_Stream_Unavailable(t *testing.T) {
+	s := &MetricsServer{}
+
+	rec := httptest.NewRecorder()
+	s.Stream(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stream", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// The rejected streams do not trigger collections
+	var collections atomic.Int32
+	s.config = &Config{CollectInterval: 50}
+	s.SetCollector(func() (string, time.Time, error) {
+		collections.Add(1)
+		return streamTestMetrics, time.Now(), nil
+	})
+	s.streams = newStreamBroadcaster(0)
+	rec = httptest.NewRecorder()
+	s.Stream(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stream", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too many streams")
+	assert.Zero(t, collections.Load())
+}
+
+func TestMetricsServer_Stream_CollectOnScrape(t *testing.T) {
+	s := &MetricsServer{
+		streams: newStreamBroadcaster(maxStreamClients),
+		config:  &Config{CollectInterval: 50},
+	}
+	var collections atomic.Int32
+	s.SetCollector(func() (string, time.Time, error) {
+		n := collections.Add(1)
+		return strings.Replace(streamTestMetrics, "} 42", fmt.Sprintf("} %d", 42+n), 1), time.Now(), nil
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(s.Stream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?metric=DCGM_FI_DEV_GPU_TEMP&gpu=0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	reader := bufio.NewReader(resp.Body)
+
+	// The stream collects the metrics without a scrape, at the start and every collect interval
+	event := readStreamEvent(t, reader)
+	require.Len(t, event.Metrics, 1)
+	assert.Equal(t, "43", event.Metrics[0].Value)
+
+	event = readStreamEvent(t, reader)
+	require.Len(t, event.Metrics, 1)
+	assert.Equal(t, "44", event.Metrics[0].Value)
+}
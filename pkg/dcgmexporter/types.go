@@ -17,13 +17,13 @@
 package dcgmexporter
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"time"
 
 	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
 	"github.com/prometheus/exporter-toolkit/web"
@@ -36,6 +36,7 @@ var (
 
 	nvidiaResourceName      = "nvidia.com/gpu"
 	nvidiaMigResourcePrefix = "nvidia.com/mig-"
+	nvidiaCDIVendor         = "nvidia.com"
 	MIG_UUID_PREFIX         = "MIG-"
 
 	// Note standard resource attributes
@@ -43,6 +44,15 @@ var (
 	namespaceAttribute = "namespace"
 	containerAttribute = "container"
 
+	sharingStrategyAttribute = "gpu_sharing_strategy"
+	replicaAttribute         = "gpu_replica"
+
+	workloadKindAttribute = "workload_kind"
+	workloadNameAttribute = "workload_name"
+
+	claimAttribute          = "resource_claim"
+	claimNamespaceAttribute = "resource_claim_namespace"
+
 	hpcJobAttribute = "hpc_job"
 
 	oldPodAttribute       = "pod_name"
@@ -60,12 +70,7 @@ type Transform interface {
 type MetricsPipeline struct {
 	config *Config
 
-	transformations      []Transform
-	migMetricsFormat     *template.Template
-	switchMetricsFormat  *template.Template
-	linkMetricsFormat    *template.Template
-	cpuMetricsFormat     *template.Template
-	cpuCoreMetricsFormat *template.Template
+	transformations []Transform
 
 	counters        []Counter
 	gpuCollector    *DCGMCollector
@@ -74,8 +79,16 @@ type MetricsPipeline struct {
 	cpuCollector    *DCGMCollector
 	coreCollector   *DCGMCollector
 
-	otelMeters  *OtelMeters
-	gpuCounters map[string]float64
+	otelMeters *OtelMeters
+	relabeler  *relabeler
+	limiter    *seriesLimiter
+	integrator *counterIntegrator
+	status     *collectorStatusTracker
+	histograms map[dcgm.Field_Entity_Group]*histogramAggregator
+	encoders   map[dcgm.Field_Entity_Group]*metricsEncoder
+
+	scrapeOnce  sync.Once
+	scrapeCache *scrapeCache
 }
 
 type OtelMeters struct {
@@ -92,6 +105,9 @@ type DCGMCollector struct {
 	SysInfo                  SystemInfo
 	Hostname                 string
 	ReplaceBlanksInModelName bool
+
+	// sampleHistory is nil, unless there are counters with the "stats" option
+	sampleHistory *sampleHistory
 }
 
 type Counter struct {
@@ -99,6 +115,54 @@ type Counter struct {
 	FieldName string
 	PromType  string
 	Help      string
+	// Options holds the optional settings from the fourth column of the counters file.
+	// It is nil when the column is absent.
+	Options *CounterOptions
+}
+
+// CounterOptions represents the per-counter settings, that can be declared in the counters file.
+type CounterOptions struct {
+	// Integrate enables the synthesized <FIELD>_COUNTER series, which holds the value
+	// of the counter integrated over time (value × elapsed seconds).
+	Integrate bool
+	// SampleStats enables the <FIELD>_MIN, <FIELD>_MAX, <FIELD>_MEAN and <FIELD>_QUANTILE series,
+	// computed from all DCGM samples since the previous collection.
+	SampleStats bool
+	// Quantiles of the <FIELD>_QUANTILE series and of summaries.
+	Quantiles []float64
+	// Buckets are the upper bounds of the histogram buckets.
+	Buckets []float64
+}
+
+// sampleStats reports whether the statistics from the DCGM sample history are enabled for the counter.
+func (c Counter) sampleStats() bool {
+	return c.Options != nil && c.Options.SampleStats
+}
+
+// sampleHistory reports whether the counter needs all DCGM samples since the previous collection.
+func (c Counter) sampleHistory() bool {
+	return c.sampleStats() || c.PromType == "histogram" || c.PromType == "summary"
+}
+
+// quantiles returns the quantiles of the counter, or the default ones.
+func (c Counter) quantiles() []float64 {
+	if c.Options != nil && c.Options.Quantiles != nil {
+		return c.Options.Quantiles
+	}
+	return defaultSampleStatsQuantiles
+}
+
+// buckets returns the histogram buckets of the counter, or the default ones.
+func (c Counter) buckets() []float64 {
+	if c.Options != nil && c.Options.Buckets != nil {
+		return c.Options.Buckets
+	}
+	return defaultHistogramBuckets
+}
+
+// integrate reports whether the synthesized <FIELD>_COUNTER series is enabled for the counter.
+func (c Counter) integrate() bool {
+	return c.Options != nil && c.Options.Integrate
 }
 
 type Metric struct {
@@ -119,6 +183,12 @@ type Metric struct {
 
 	Labels     map[string]string
 	Attributes map[string]string
+
+	// Samples are the DCGM samples since the previous collection for counters,
+	// that need the sample history. Value holds the latest sample.
+	Samples []float64
+	// NameSuffix is appended to the name of the counter, e.g. "_bucket" for histograms.
+	NameSuffix string
 }
 
 // metricFingerprint produces a string that should uniquely identify a metric
@@ -126,24 +196,24 @@ type Metric struct {
 // The idea is to identify the metric by name and labels, so it could be used when selecting a right counter
 // for a metric.
 func (m *Metric) metricFingerprint() string {
-	fingerprint := sha256.New()
-	fmt.Fprintf(fingerprint, "name=%s,", m.Counter.FieldName)
-	fmt.Fprintf(fingerprint, "gpu=%s,", m.GPU)
-	fmt.Fprintf(fingerprint, "gpu_uuid=%s,", m.GPUUUID)
-	fmt.Fprintf(fingerprint, "gpu_device=%s,", m.GPUDevice)
-	fmt.Fprintf(fingerprint, "gpu_model_name=%s,", m.GPUModelName)
-	fmt.Fprintf(fingerprint, "gpu_pci_bus_id=%s,", m.GPUPCIBusID)
-	fmt.Fprintf(fingerprint, "uuid=%s,", m.UUID)
-	fmt.Fprintf(fingerprint, "mig_profile=%s,", m.MigProfile)
-	fmt.Fprintf(fingerprint, "gpu_instance_id=%s,", m.GPUInstanceID)
-	fmt.Fprintf(fingerprint, "hostname=%s,", m.Hostname)
+	var h fingerprintHash = fnvOffset64
+	h.addLabel("name", m.Counter.FieldName)
+	h.addLabel("gpu", m.GPU)
+	h.addLabel("gpu_uuid", m.GPUUUID)
+	h.addLabel("gpu_device", m.GPUDevice)
+	h.addLabel("gpu_model_name", m.GPUModelName)
+	h.addLabel("gpu_pci_bus_id", m.GPUPCIBusID)
+	h.addLabel("uuid", m.UUID)
+	h.addLabel("mig_profile", m.MigProfile)
+	h.addLabel("gpu_instance_id", m.GPUInstanceID)
+	h.addLabel("hostname", m.Hostname)
 	keys := make([]string, 0, max(len(m.Labels), len(m.Attributes)))
 	for k := range m.Labels {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Fprintf(fingerprint, "%s=%s,", k, m.Labels[k])
+		h.addLabel(k, m.Labels[k])
 	}
 	keys = keys[:0]
 	for k := range m.Attributes {
@@ -151,11 +221,34 @@ func (m *Metric) metricFingerprint() string {
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Fprintf(fingerprint, "%s=%s,", k, m.Attributes[k])
+		h.addLabel(k, m.Attributes[k])
 	}
-	return hex.EncodeToString(fingerprint.Sum(nil))
+	return strconv.FormatUint(uint64(h), 16)
 }
 
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fingerprintHash is the 64-bit FNV-1a hash. Unlike hash/fnv, it hashes strings without allocations.
+type fingerprintHash uint64
+
+func (h *fingerprintHash) add(s string) {
+	for i := 0; i < len(s); i++ {
+		*h ^= fingerprintHash(s[i])
+		*h *= fnvPrime64
+	}
+}
+
+func (h *fingerprintHash) addLabel(name, value string) {
+	h.add(name)
+	h.add("=")
+	h.add(value)
+	h.add(",")
+}
+
+// getIDOfType returns the ID of the device of the metric, as kubelet returns it with the KubernetesGPUIDType.
 func (m Metric) getIDOfType(idType KubernetesGPUIDType) (string, error) {
 	// For MIG devices, return the MIG profile instead of
 	if m.MigProfile != "" {
@@ -166,6 +259,13 @@ func (m Metric) getIDOfType(idType KubernetesGPUIDType) (string, error) {
 		return m.GPUUUID, nil
 	case DeviceName:
 		return m.GPUDevice, nil
+	case PCIBusID:
+		return normalizePCIBusID(m.GPUPCIBusID), nil
+	case GPUIndex:
+		return m.GPU, nil
+	case AutoGPUID:
+		// The pod mapper keys the GPUs by UUID, whatever the format of their device IDs
+		return m.GPUUUID, nil
 	}
 	return "", fmt.Errorf("unsupported KubernetesGPUIDType for MetricID '%s'", idType)
 }
@@ -183,19 +283,58 @@ type MetricsServer struct {
 
 	server      *http.Server
 	webConfig   *web.FlagConfig
-	metrics     string
+	snapshot    atomic.Pointer[metricsSnapshot]
 	metricsChan chan string
 	registry    *Registry
+	// collect is set, when the metrics are collected on scrape rather than received from metricsChan
+	collect        func() (string, time.Time, error)
+	lastCollectErr error
+	inventory      *Inventory
+	assignments    *AssignmentTracker
+	gpuIDType      KubernetesGPUIDType
+	// config and collectorStatus are shown on the status page
+	config          *Config
+	collectorStatus func() []CollectorStatus
+	streams         *streamBroadcaster
+}
+
+// metricsSnapshot is the immutable result of a collection. The server swaps the snapshots atomically,
+// so that the scrapes never wait for an update, nor the updates for a slow scrape.
+type metricsSnapshot struct {
+	data        string
+	collectedAt time.Time
+
+	// The response body and its compressed encodings are rendered on the first scrape of the snapshot
+	mtx     sync.Mutex
+	body    []byte
+	etag    string
+	encoded map[string][]byte
+
+	// The samples are parsed once for all the streams
+	samplesOnce sync.Once
+	samples     []StreamSample
+	samplesErr  error
+}
+
+func newMetricsSnapshot(data string) *metricsSnapshot {
+	return &metricsSnapshot{data: data, collectedAt: time.Now()}
 }
 
 type PodMapper struct {
-	Config *Config
+	Config        *Config
+	deviceIDRules []deviceIDRule
 }
 
 type PodInfo struct {
-	Name      string
-	Namespace string
-	Container string
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Container string `json:"container"`
+	// SharingStrategy and Replica are set, when the pod got a replica of a GPU shared by several pods.
+	SharingStrategy string `json:"sharingStrategy,omitempty"`
+	Replica         string `json:"replica,omitempty"`
+	// ClaimName and ClaimNamespace are set, when the pod got the device through a DRA resource claim.
+	ClaimName      string `json:"claimName,omitempty"`
+	ClaimNamespace string `json:"claimNamespace,omitempty"`
 }
 
 // MetricsByCounter represents a map where each Counter is associated with a slice of Metric objects
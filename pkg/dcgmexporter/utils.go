@@ -17,8 +17,6 @@
 package dcgmexporter
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"sync"
 	"time"
@@ -37,29 +35,3 @@ func WaitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) error {
 		return fmt.Errorf("timeout waiting for WaitGroup")
 	}
 }
-
-func deepCopy[T any](src T) (dst T, err error) {
-	var buf bytes.Buffer
-
-	defer func() {
-		if r := recover(); r != nil {
-			// If there was a panic, return the zero value of T and the error.
-			dst = *new(T)
-			err = fmt.Errorf("panic occurred: %v", r)
-		}
-	}()
-
-	// Create an encoder and send a value.
-	err = gob.NewEncoder(&buf).Encode(src)
-	if err != nil {
-		return *new(T), err
-	}
-
-	// Create a new instance of the type T and decode into that.
-	err = gob.NewDecoder(&buf).Decode(&dst)
-	if err != nil {
-		return *new(T), err
-	}
-
-	return dst, nil
-}